@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kakao/cite/models"
+)
+
+var approvalHeader = []string{"ID", "ACTION", "SHA", "IMAGE", "REQUESTED_BY", "EXPIRES", "APPROVERS", "STATE"}
+
+func approvalsList(client *Client, opts *options, args []string) error {
+	nsName, svcName, err := serviceArgs(opts, args, 0)
+	if err != nil {
+		return err
+	}
+	approvals := []models.Approval{}
+	if err := client.Get(servicePath(nsName, svcName, "approvals"), &approvals); err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, approval := range approvals {
+		rows = append(rows, approvalRow(approval))
+	}
+	return output(opts, approvals, approvalHeader, rows)
+}
+
+func approvalsApprove(client *Client, opts *options, args []string) error {
+	return decideApproval(client, opts, args, "approve")
+}
+
+func approvalsReject(client *Client, opts *options, args []string) error {
+	return decideApproval(client, opts, args, "reject")
+}
+
+func decideApproval(client *Client, opts *options, args []string, decision string) error {
+	nsName, svcName, err := serviceArgs(opts, args, 1)
+	if err != nil {
+		return err
+	}
+	approval := new(models.Approval)
+	if err := client.Post(servicePath(nsName, svcName, "approvals", args[1], decision), nil, approval); err != nil {
+		return err
+	}
+	return output(opts, approval, approvalHeader, [][]string{approvalRow(*approval)})
+}
+
+func approvalRow(approval models.Approval) []string {
+	logins := []string{}
+	for _, approver := range approval.Approvers {
+		logins = append(logins, approver.Login)
+	}
+	action := approval.Action
+	if approval.Action == models.APPROVAL_ACTION_ACTIVATE {
+		action += " " + strconv.Itoa(approval.DeployID)
+	}
+	return []string{
+		approval.ID,
+		action,
+		shortSHA(approval.SHA),
+		approval.Image,
+		approval.RequestedBy,
+		approval.ExpiresAt.Local().Format(time.RFC3339),
+		fmt.Sprintf("%d/%d %s", len(approval.Approvers), approval.Required, strings.Join(logins, ",")),
+		approval.State,
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kakao/cite/models"
+)
+
+const (
+	CLIENT_TIMEOUT = 60 * time.Second
+)
+
+// Client calls the json api of cite with a personal api token.
+type Client struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+func NewClient(server, token string) *Client {
+	return &Client{
+		server: strings.TrimRight(server, "/"),
+		token:  token,
+		client: &http.Client{},
+	}
+}
+
+// Get decodes the json response of the path into out.
+func (this *Client) Get(path string, out interface{}) error {
+	return this.do(http.MethodGet, path, nil, out)
+}
+
+func (this *Client) Post(path string, in, out interface{}) error {
+	return this.do(http.MethodPost, path, in, out)
+}
+
+func (this *Client) Put(path string, in, out interface{}) error {
+	return this.do(http.MethodPut, path, in, out)
+}
+
+// Stream copies the plain text response of the path to w. it has no
+// timeout, since followed logs last until they are interrupted.
+func (this *Client) Stream(path string, query url.Values, w io.Writer) error {
+	req, err := this.newRequest(http.MethodGet, path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := checkResponse(res); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+func (this *Client) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		inJSON, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(inJSON)
+	}
+	req, err := this.newRequest(method, path, body)
+	if err != nil {
+		return err
+	}
+
+	client := *this.client
+	client.Timeout = CLIENT_TIMEOUT
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := checkResponse(res); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func (this *Client) newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, this.server+"/v2"+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "token "+this.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// checkResponse returns the message of failed responses as an error.
+func checkResponse(res *http.Response) error {
+	if res.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+	var apiErr models.APIError
+	if err := json.NewDecoder(res.Body).Decode(&apiErr); err != nil || len(apiErr.Message) == 0 {
+		return fmt.Errorf("%s %s: %s", res.Request.Method, res.Request.URL.Path, res.Status)
+	}
+	return fmt.Errorf("%s: %s", res.Status, apiErr.Message)
+}
+
+// servicePath returns the api path of the service, followed by elems. names
+// of namespaces and services are dns labels, so they need no escaping.
+func servicePath(nsName, svcName string, elems ...string) string {
+	return strings.Join(append([]string{"/namespaces", nsName, "services", svcName}, elems...), "/")
+}
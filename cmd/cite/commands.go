@@ -0,0 +1,478 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kakao/cite/models"
+)
+
+func namespacesList(client *Client, opts *options, args []string) error {
+	var nss []models.APINamespace
+	if err := client.Get("/namespaces", &nss); err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, ns := range nss {
+		rows = append(rows, []string{ns.Name, ns.Org})
+	}
+	return output(opts, nss, []string{"NAME", "ORG"}, rows)
+}
+
+func servicesList(client *Client, opts *options, args []string) error {
+	nsName, err := requireNamespace(opts)
+	if err != nil {
+		return err
+	}
+	var svcs []models.APIService
+	if err := client.Get("/namespaces/"+nsName+"/services", &svcs); err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, svc := range svcs {
+		rows = append(rows, serviceRow(svc))
+	}
+	return output(opts, svcs, serviceHeader, rows)
+}
+
+func servicesGet(client *Client, opts *options, args []string) error {
+	svc, err := getService(client, opts, args)
+	if err != nil {
+		return err
+	}
+	return output(opts, svc, serviceHeader, [][]string{serviceRow(*svc)})
+}
+
+var serviceHeader = []string{"NAME", "REPO", "BRANCH", "SHA", "DEPLOY_ID", "REPLICAS"}
+
+func serviceRow(svc models.APIService) []string {
+	meta := svc.Metadata
+	if meta == nil {
+		meta = new(models.Metadata)
+	}
+	return []string{
+		svc.Name,
+		meta.GithubOrg + "/" + meta.GithubRepo,
+		meta.GitBranch,
+		shortSHA(svc.SHA),
+		svc.DeployID,
+		strconv.Itoa(meta.Replicas),
+	}
+}
+
+func getService(client *Client, opts *options, args []string) (*models.APIService, error) {
+	nsName, svcName, err := serviceArgs(opts, args, 0)
+	if err != nil {
+		return nil, err
+	}
+	svc := new(models.APIService)
+	if err := client.Get(servicePath(nsName, svcName), svc); err != nil {
+		return nil, err
+	}
+	return svc, nil
+}
+
+func history(client *Client, opts *options, args []string) error {
+	nsName, svcName, err := serviceArgs(opts, args, 0)
+	if err != nil {
+		return err
+	}
+	records, err := getHistory(client, nsName, svcName)
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, record := range records {
+		rows = append(rows, []string{
+			strconv.Itoa(record.DeployID),
+			shortSHA(record.SHA),
+			record.Image,
+			record.TriggeredBy,
+			record.Strategy,
+			record.StartedAt.Local().Format(time.RFC3339),
+			record.Result,
+		})
+	}
+	return output(opts, records, []string{"DEPLOY_ID", "SHA", "IMAGE", "TRIGGERED_BY", "STRATEGY", "STARTED", "RESULT"}, rows)
+}
+
+func getHistory(client *Client, nsName, svcName string) ([]models.DeployRecord, error) {
+	var records []models.DeployRecord
+	err := client.Get(servicePath(nsName, svcName, "history"), &records)
+	return records, err
+}
+
+func buildsList(client *Client, opts *options, args []string) error {
+	nsName, svcName, err := serviceArgs(opts, args, 0)
+	if err != nil {
+		return err
+	}
+	records, err := getBuilds(client, nsName, svcName)
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, record := range records {
+		rows = append(rows, buildRow(record))
+	}
+	return output(opts, records, buildHeader, rows)
+}
+
+func build(client *Client, opts *options, args []string) error {
+	nsName, svcName, err := serviceArgs(opts, args, 1)
+	if err != nil {
+		return err
+	}
+	record := new(models.BuildRecord)
+	if err := client.Post(servicePath(nsName, svcName, "builds"), models.APIBuildRequest{SHA: args[1]}, record); err != nil {
+		return err
+	}
+	return output(opts, record, buildHeader, [][]string{buildRow(*record)})
+}
+
+var buildHeader = []string{"ID", "SHA", "BUILDER", "REQUESTED_BY", "IMAGE", "STATE", "DURATION"}
+
+func buildRow(record models.BuildRecord) []string {
+	return []string{
+		record.ID,
+		shortSHA(record.SHA),
+		record.Builder,
+		record.RequestedBy,
+		record.Image,
+		record.State,
+		record.Duration().String(),
+	}
+}
+
+func getBuilds(client *Client, nsName, svcName string) ([]models.BuildRecord, error) {
+	var records []models.BuildRecord
+	err := client.Get(servicePath(nsName, svcName, "builds"), &records)
+	return records, err
+}
+
+// deploy deploys the sha. the image is the latest successful build of the
+// sha unless it is given.
+func deploy(client *Client, opts *options, args []string) error {
+	nsName, svcName, err := serviceArgs(opts, args, 1)
+	if err != nil {
+		return err
+	}
+	sha := args[1]
+	image := opts.image
+	if len(image) == 0 {
+		records, err := getBuilds(client, nsName, svcName)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if strings.HasPrefix(record.SHA, sha) && record.State == models.BUILD_STATE_SUCCESS && len(record.Image) > 0 {
+				sha = record.SHA
+				image = record.Image
+				break
+			}
+		}
+		if len(image) == 0 {
+			return fmt.Errorf("no image built for %s, build it first or give --image", sha)
+		}
+	}
+
+	res := new(models.APIDeployResponse)
+	req := models.APIDeployRequest{
+		SHA:   sha,
+		Image: image,
+	}
+	if err := client.Post(servicePath(nsName, svcName, "deploys"), req, res); err != nil {
+		return err
+	}
+	if res.Approval != nil {
+		return outputApproval(opts, res)
+	}
+	return output(opts, res, []string{"DEPLOY_ID", "SHA", "IMAGE"},
+		[][]string{{strconv.Itoa(res.DeployID), shortSHA(res.SHA), res.Image}})
+}
+
+func activate(client *Client, opts *options, args []string) error {
+	nsName, svcName, err := serviceArgs(opts, args, 2)
+	if err != nil {
+		return err
+	}
+	return activateDeploy(client, opts, nsName, svcName, args[1], args[2])
+}
+
+// activateDeploy activates the deploy, and prints the service, or the
+// pending approval for protected services.
+func activateDeploy(client *Client, opts *options, nsName, svcName, sha, deployID string) error {
+	var body json.RawMessage
+	req := models.APIActivateRequest{
+		SHA:      sha,
+		DeployID: deployID,
+	}
+	if err := client.Post(servicePath(nsName, svcName, "activate"), req, &body); err != nil {
+		return err
+	}
+	res := new(models.APIDeployResponse)
+	if err := json.Unmarshal(body, res); err == nil && res.Approval != nil {
+		return outputApproval(opts, res)
+	}
+	svc := new(models.APIService)
+	if err := json.Unmarshal(body, svc); err != nil {
+		return err
+	}
+	return output(opts, svc, serviceHeader, [][]string{serviceRow(*svc)})
+}
+
+// outputApproval prints the pending approval of the deploy or the activation,
+// with a hint on stderr.
+func outputApproval(opts *options, res *models.APIDeployResponse) error {
+	fmt.Fprintf(os.Stderr, "%s of %s is waiting for %d approvals until %s\n",
+		res.Approval.Action, shortSHA(res.SHA), res.Approval.Required, res.Approval.ExpiresAt.Local().Format(time.RFC3339))
+	return output(opts, res, approvalHeader, [][]string{approvalRow(*res.Approval)})
+}
+
+// rollback activates the latest successful deploy before the active one, or
+// the deploy given by --to.
+func rollback(client *Client, opts *options, args []string) error {
+	svc, err := getService(client, opts, args)
+	if err != nil {
+		return err
+	}
+	records, err := getHistory(client, svc.Namespace, svc.Name)
+	if err != nil {
+		return err
+	}
+
+	var target *models.DeployRecord
+	active := false
+	for i, record := range records {
+		if opts.to > 0 {
+			if record.DeployID == opts.to {
+				target = &records[i]
+				break
+			}
+			continue
+		}
+		if strconv.Itoa(record.DeployID) == svc.DeployID {
+			active = true
+			continue
+		}
+		if active && record.Result == models.DEPLOY_RESULT_SUCCESS {
+			target = &records[i]
+			break
+		}
+	}
+	switch {
+	case target != nil:
+	case opts.to > 0:
+		return fmt.Errorf("deploy %d not found in history of %s/%s", opts.to, svc.Namespace, svc.Name)
+	case !active:
+		return fmt.Errorf("active deploy %s not found in history of %s/%s, give --to", svc.DeployID, svc.Namespace, svc.Name)
+	default:
+		return fmt.Errorf("no successful deploy before %s in history of %s/%s", svc.DeployID, svc.Namespace, svc.Name)
+	}
+
+	fmt.Fprintf(os.Stderr, "rolling back %s/%s to deploy %d of %s\n", svc.Namespace, svc.Name, target.DeployID, shortSHA(target.SHA))
+	return activateDeploy(client, opts, svc.Namespace, svc.Name, target.SHA, strconv.Itoa(target.DeployID))
+}
+
+func scale(client *Client, opts *options, args []string) error {
+	nsName, svcName, err := serviceArgs(opts, args, 1)
+	if err != nil {
+		return err
+	}
+	replicas, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid replicas %s: %v", args[1], err)
+	}
+	svc := new(models.APIService)
+	req := models.APIScaleRequest{
+		Replicas: replicas,
+		RC:       opts.rc,
+	}
+	if err := client.Post(servicePath(nsName, svcName, "scale"), req, svc); err != nil {
+		return err
+	}
+	return output(opts, svc, serviceHeader, [][]string{serviceRow(*svc)})
+}
+
+// logs prints logs of the pod, or of a running pod of the active deploy.
+func logs(client *Client, opts *options, args []string) error {
+	svc, err := getService(client, opts, args)
+	if err != nil {
+		return err
+	}
+
+	podName := ""
+	if len(args) > 1 {
+		podName = args[1]
+	} else {
+		var pods []models.APIPod
+		if err := client.Get(servicePath(svc.Namespace, svc.Name, "pods"), &pods); err != nil {
+			return err
+		}
+		for _, pod := range pods {
+			if pod.DeployID == svc.DeployID && pod.Phase == "Running" {
+				podName = pod.Name
+				break
+			}
+		}
+		if len(podName) == 0 {
+			return fmt.Errorf("no running pod of %s/%s", svc.Namespace, svc.Name)
+		}
+		fmt.Fprintf(os.Stderr, "logs of pod %s\n", podName)
+	}
+
+	query := url.Values{}
+	query.Set("tail", strconv.FormatInt(opts.tail, 10))
+	if len(opts.container) > 0 {
+		query.Set("container", opts.container)
+	}
+	if len(opts.since) > 0 {
+		query.Set("since", opts.since)
+	}
+	if opts.follow {
+		query.Set("follow", "true")
+	}
+	if opts.previous {
+		query.Set("previous", "true")
+	}
+	return client.Stream("/namespaces/"+svc.Namespace+"/pods/"+podName+"/log/stream", query, os.Stdout)
+}
+
+func envList(client *Client, opts *options, args []string) error {
+	nsName, svcName, err := serviceArgs(opts, args, 0)
+	if err != nil {
+		return err
+	}
+	meta := new(models.Metadata)
+	if err := client.Get(servicePath(nsName, svcName, "settings"), meta); err != nil {
+		return err
+	}
+	env := meta.EnvironmentMap()
+	rows := [][]string{}
+	for _, line := range strings.Split(meta.Environment, "\n") {
+		if key := envKey(line); len(key) > 0 {
+			rows = append(rows, []string{key, env[key]})
+		}
+	}
+	for _, key := range meta.SecretEnvKeys {
+		rows = append(rows, []string{key, "(secret)"})
+	}
+	return output(opts, env, []string{"KEY", "VALUE"}, rows)
+}
+
+// envSet replaces lines of the keys in the environment of the service, and
+// appends keys which are not set yet.
+func envSet(client *Client, opts *options, args []string) error {
+	nsName, svcName, err := serviceArgs(opts, args, 1)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]string)
+	keys := []string{}
+	for _, arg := range args[1:] {
+		entries := strings.SplitN(arg, "=", 2)
+		if len(entries) != 2 || len(entries[0]) == 0 {
+			return fmt.Errorf("invalid environment variable %s, KEY=VALUE expected", arg)
+		}
+		if _, ok := values[entries[0]]; !ok {
+			keys = append(keys, entries[0])
+		}
+		values[entries[0]] = entries[1]
+	}
+
+	return updateEnv(client, opts, nsName, svcName, func(lines []string) []string {
+		updated := []string{}
+		set := make(map[string]bool)
+		for _, line := range lines {
+			key := envKey(line)
+			if value, ok := values[key]; ok {
+				line = key + "=" + value
+				set[key] = true
+			}
+			updated = append(updated, line)
+		}
+		for _, key := range keys {
+			if !set[key] {
+				updated = append(updated, key+"="+values[key])
+			}
+		}
+		return updated
+	})
+}
+
+func envUnset(client *Client, opts *options, args []string) error {
+	nsName, svcName, err := serviceArgs(opts, args, 1)
+	if err != nil {
+		return err
+	}
+	unset := make(map[string]bool)
+	for _, key := range args[1:] {
+		unset[key] = true
+	}
+
+	return updateEnv(client, opts, nsName, svcName, func(lines []string) []string {
+		updated := []string{}
+		for _, line := range lines {
+			if !unset[envKey(line)] {
+				updated = append(updated, line)
+			}
+		}
+		return updated
+	})
+}
+
+// updateEnv rewrites lines of the environment of the service by update, and
+// saves the settings.
+func updateEnv(client *Client, opts *options, nsName, svcName string, update func(lines []string) []string) error {
+	meta := new(models.Metadata)
+	if err := client.Get(servicePath(nsName, svcName, "settings"), meta); err != nil {
+		return err
+	}
+	lines := []string{}
+	if len(strings.TrimSpace(meta.Environment)) > 0 {
+		lines = strings.Split(strings.TrimRight(meta.Environment, "\n"), "\n")
+	}
+	meta.Environment = strings.Join(update(lines), "\n")
+
+	updated := new(models.Metadata)
+	if err := client.Put(servicePath(nsName, svcName, "settings"), meta, updated); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "environment of %s/%s updated. deploy again to apply it.\n", nsName, svcName)
+	return envList(client, opts, []string{svcName})
+}
+
+// envKey returns the key of the environment line, or empty string for
+// comments and blank lines.
+func envKey(line string) string {
+	entries := strings.SplitN(line, "=", 2)
+	if len(entries) != 2 || strings.HasPrefix(line, "#") {
+		return ""
+	}
+	return entries[0]
+}
+
+// serviceArgs returns the namespace and the service of the first arg, and
+// checks that at least required args follow it.
+func serviceArgs(opts *options, args []string, required int) (string, string, error) {
+	nsName, err := requireNamespace(opts)
+	if err != nil {
+		return "", "", err
+	}
+	if len(args) < 1+required {
+		return "", "", fmt.Errorf("service and %d more args required, see cite --help", required)
+	}
+	return nsName, args[0], nil
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
@@ -0,0 +1,188 @@
+// cite is the command-line client of the cite json api. it authenticates
+// with a personal api token created on the profile settings page.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	flag "github.com/spf13/pflag"
+)
+
+const usage = `usage: cite [flags] <command> [args]
+
+commands:
+  namespaces ls                       list namespaces
+  services ls                         list services of the namespace
+  services get <svc>                  show the service
+  history <svc>                       list deploys of the service
+  builds ls <svc>                     list builds of the service
+  build <svc> <sha>                   build the sha
+  deploy <svc> <sha> [--image]        deploy the sha, with the image built for it by default
+  activate <svc> <sha> <deploy_id>    route the service to the deploy
+  rollback <svc> [--to <deploy_id>]   activate the previous successful deploy
+  scale <svc> <replicas> [--rc]       scale the service
+  logs <svc> [pod] [-f]               print logs of a pod of the service
+  env ls <svc>                        list environment variables
+  env set <svc> KEY=VALUE...          set environment variables
+  env unset <svc> KEY...              unset environment variables
+  pipelines ls                        list pipelines of the namespace
+  pipelines get <pipeline>            show stages of the pipeline and their deploys
+  promotions <pipeline>               list promotions of the pipeline
+  promote <pipeline> <stage>          promote the deploy of the stage to the next stage
+  approve <pipeline> <id>             approve the waiting promotion
+  reject <pipeline> <id>              reject the waiting promotion
+  approvals <svc>                     list approvals of the protected service
+  approvals approve <svc> <id>        approve the pending deploy or activation
+  approvals reject <svc> <id>         reject the pending deploy or activation
+
+flags:
+`
+
+// options are flags of all commands. commands ignore flags of others.
+type options struct {
+	server    string
+	token     string
+	namespace string
+	output    string
+
+	image     string
+	rc        string
+	to        int
+	follow    bool
+	container string
+	tail      int64
+	since     string
+	previous  bool
+}
+
+type command func(client *Client, opts *options, args []string) error
+
+var commands = map[string]command{
+	"namespaces ls": namespacesList,
+	"services ls":   servicesList,
+	"services get":  servicesGet,
+	"history":       history,
+	"builds ls":     buildsList,
+	"build":         build,
+	"deploy":        deploy,
+	"activate":      activate,
+	"rollback":      rollback,
+	"scale":         scale,
+	"logs":          logs,
+	"env ls":        envList,
+	"env set":       envSet,
+	"env unset":     envUnset,
+	"pipelines ls":  pipelinesList,
+	"pipelines get": pipelinesGet,
+	"promotions":    promotionsList,
+	"promote":       promote,
+	"approve":       approve,
+	"reject":        reject,
+
+	"approvals":         approvalsList,
+	"approvals approve": approvalsApprove,
+	"approvals reject":  approvalsReject,
+}
+
+func main() {
+	opts := new(options)
+	flags := flag.NewFlagSet("cite", flag.ExitOnError)
+	flags.StringVar(&opts.server, "server", os.Getenv("CITE_SERVER"), "url of cite, e.g. https://cite.example.com ($CITE_SERVER)")
+	flags.StringVar(&opts.token, "token", os.Getenv("CITE_TOKEN"), "personal api token ($CITE_TOKEN)")
+	flags.StringVarP(&opts.namespace, "namespace", "n", os.Getenv("CITE_NAMESPACE"), "namespace of services ($CITE_NAMESPACE)")
+	flags.StringVarP(&opts.output, "output", "o", "table", "output format, table or json")
+	flags.StringVar(&opts.image, "image", "", "image to deploy")
+	flags.StringVar(&opts.rc, "rc", "", "replication controller to scale, the active one by default")
+	flags.IntVar(&opts.to, "to", 0, "deploy_id to roll back to")
+	flags.BoolVarP(&opts.follow, "follow", "f", false, "follow logs")
+	flags.StringVarP(&opts.container, "container", "c", "", "container of the pod")
+	flags.Int64Var(&opts.tail, "tail", 100, "lines of recent logs")
+	flags.StringVar(&opts.since, "since", "", "logs newer than the duration, e.g. 10m")
+	flags.BoolVarP(&opts.previous, "previous", "p", false, "logs of the previous container")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+
+	args := flags.Args()
+	cmd, args := findCommand(args)
+	if cmd == nil {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if len(opts.server) == 0 || len(opts.token) == 0 {
+		fatalf("--server and --token are required")
+	}
+	if opts.output != "table" && opts.output != "json" {
+		fatalf("invalid output format %s", opts.output)
+	}
+
+	if err := cmd(NewClient(opts.server, opts.token), opts, args); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// findCommand returns the command named by leading args, and the rest of
+// args.
+func findCommand(args []string) (command, []string) {
+	if len(args) >= 2 {
+		if cmd, ok := commands[args[0]+" "+args[1]]; ok {
+			return cmd, args[2:]
+		}
+	}
+	if len(args) >= 1 {
+		if cmd, ok := commands[args[0]]; ok {
+			return cmd, args[1:]
+		}
+	}
+	return nil, nil
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "cite: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+// requireNamespace returns the namespace option, which most commands need.
+func requireNamespace(opts *options) (string, error) {
+	if len(opts.namespace) == 0 {
+		return "", fmt.Errorf("--namespace is required")
+	}
+	return opts.namespace, nil
+}
+
+// output prints v as json, or as a table of rows with the header.
+func output(opts *options, v interface{}, header []string, rows [][]string) error {
+	if opts.output == "json" {
+		return printJSON(os.Stdout, v)
+	}
+	return printTable(os.Stdout, header, rows)
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+func printTable(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, row := range append([][]string{header}, rows...) {
+		for i, col := range row {
+			if i > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, col)
+		}
+		fmt.Fprint(tw, "\n")
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kakao/cite/models"
+)
+
+var promotionHeader = []string{"ID", "FROM", "TO", "SHA", "IMAGE", "REQUESTED_BY", "REQUESTED", "DECIDED_BY", "STATE"}
+
+func pipelinesList(client *Client, opts *options, args []string) error {
+	nsName, err := requireNamespace(opts)
+	if err != nil {
+		return err
+	}
+	pipelines := []models.Pipeline{}
+	if err := client.Get(pipelinePath(nsName), &pipelines); err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, pipeline := range pipelines {
+		stages := []string{}
+		for _, stage := range pipeline.Stages {
+			stages = append(stages, stage.Name)
+		}
+		rows = append(rows, []string{pipeline.Name, strings.Join(stages, " -> "), pipeline.CreatedBy})
+	}
+	return output(opts, pipelines, []string{"NAME", "STAGES", "CREATED_BY"}, rows)
+}
+
+// pipelinesGet prints stages of the pipeline with their active deploys.
+func pipelinesGet(client *Client, opts *options, args []string) error {
+	pipeline, err := getPipeline(client, opts, args)
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for i, stage := range pipeline.Stages {
+		sha, image, deployID := "-", "-", "-"
+		if active := pipeline.Active[i]; active != nil {
+			sha, image, deployID = shortSHA(active.SHA), active.Image, strconv.Itoa(active.DeployID)
+		}
+		rows = append(rows, []string{
+			stage.Name,
+			stage.Target(),
+			stage.GitHubEnvironment(),
+			strconv.FormatBool(stage.Approval),
+			sha,
+			image,
+			deployID,
+		})
+	}
+	return output(opts, pipeline, []string{"STAGE", "SERVICE", "ENVIRONMENT", "APPROVAL", "SHA", "IMAGE", "DEPLOY_ID"}, rows)
+}
+
+func promotionsList(client *Client, opts *options, args []string) error {
+	pipeline, err := getPipeline(client, opts, args)
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, promotion := range pipeline.Promotions {
+		rows = append(rows, promotionRow(pipeline, promotion))
+	}
+	return output(opts, pipeline.Promotions, promotionHeader, rows)
+}
+
+// promote promotes the active deploy of the stage to the next stage.
+func promote(client *Client, opts *options, args []string) error {
+	pipeline, err := getPipeline(client, opts, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("pipeline and stage to promote from required, see cite --help")
+	}
+	promotion := new(models.Promotion)
+	req := models.APIPromoteRequest{From: args[1]}
+	if err := client.Post(pipelinePath(opts.namespace, pipeline.Name, "promotions"), req, promotion); err != nil {
+		return err
+	}
+	return output(opts, promotion, promotionHeader, [][]string{promotionRow(pipeline, *promotion)})
+}
+
+func approve(client *Client, opts *options, args []string) error {
+	return decide(client, opts, args, "approve")
+}
+
+func reject(client *Client, opts *options, args []string) error {
+	return decide(client, opts, args, "reject")
+}
+
+func decide(client *Client, opts *options, args []string, decision string) error {
+	pipeline, err := getPipeline(client, opts, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("pipeline and promotion id required, see cite --help")
+	}
+	promotion := new(models.Promotion)
+	if err := client.Post(pipelinePath(opts.namespace, pipeline.Name, "promotions", args[1], decision), nil, promotion); err != nil {
+		return err
+	}
+	return output(opts, promotion, promotionHeader, [][]string{promotionRow(pipeline, *promotion)})
+}
+
+func getPipeline(client *Client, opts *options, args []string) (*models.APIPipeline, error) {
+	nsName, err := requireNamespace(opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 1 {
+		return nil, fmt.Errorf("pipeline required, see cite --help")
+	}
+	pipeline := new(models.APIPipeline)
+	if err := client.Get(pipelinePath(nsName, args[0]), pipeline); err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
+
+func promotionRow(pipeline *models.APIPipeline, promotion models.Promotion) []string {
+	stageName := func(idx int) string {
+		if idx < len(pipeline.Stages) {
+			return pipeline.Stages[idx].Name
+		}
+		return strconv.Itoa(idx)
+	}
+	return []string{
+		promotion.ID,
+		stageName(promotion.From),
+		stageName(promotion.To),
+		shortSHA(promotion.SHA),
+		promotion.Image,
+		promotion.RequestedBy,
+		promotion.RequestedAt.Local().Format(time.RFC3339),
+		promotion.DecidedBy,
+		promotion.State,
+	}
+}
+
+// pipelinePath returns the api path of pipelines of the namespace, followed
+// by elems.
+func pipelinePath(nsName string, elems ...string) string {
+	return strings.Join(append([]string{"/namespaces", nsName, "pipelines"}, elems...), "/")
+}
@@ -22,7 +22,7 @@ func GetCiteService(c echo.Context) error {
 
 	nsName := util.NormalizeByHyphen("", owner)
 	svcLabels := k8s.GetLabels(repo, branch)
-	svcs, err := k8s.GetServices(nsName, svcLabels)
+	svcs, err := k8s.GetBranchServices(nsName, svcLabels)
 
 	if err != nil || len(svcs) < 1 {
 		errMsg := fmt.Sprintf("service not found. owner:%s, repo:%s, branch:%s",
@@ -46,6 +46,7 @@ func GetGarbageCollection(c echo.Context) error {
 	// dryrun = true
 	// ttl, _ = time.ParseDuration("1s")
 	rcMap := make(map[string]k8sApi.ReplicationController)
+	var previewList []string
 	logger.Infof("is dryrun? %v", dryrun)
 	logger.Debugf("unused rc ttl: %v", ttl)
 
@@ -66,6 +67,30 @@ func GetGarbageCollection(c echo.Context) error {
 			}
 			logger.Debugf("namespace: %s", ns.Name)
 
+			// delete expired previews with their RCs
+			previews, err := k8s.GetPreviewServices(ns.Name, map[string]string{})
+			if err != nil {
+				msg := fmt.Sprintf("failed to list previews on namespace %s: %v", ns.Name, err)
+				logger.Errorf(msg)
+				noti.SendSystem(msg)
+				return
+			}
+			for _, preview := range previews {
+				if !models.PreviewExpired(&preview) {
+					continue
+				}
+				if !dryrun {
+					err = k8s.DeleteService(ns.Name, preview.Name)
+					if err != nil {
+						msg := fmt.Sprintf("failed to delete preview %s/%s: %v", ns.Name, preview.Name, err)
+						logger.Errorf(msg)
+						noti.SendSystem(msg)
+						return
+					}
+				}
+				previewList = append(previewList, fmt.Sprintf("%s/%s", ns.Name, preview.Name))
+			}
+
 			// get all RCs
 			rcs, err := k8s.GetReplicationControllers(ns.Name, map[string]string{})
 			if err != nil {
@@ -80,8 +105,8 @@ func GetGarbageCollection(c echo.Context) error {
 				if time.Since(rc.CreationTimestamp.Time) < ttl {
 					continue
 				}
-				rcLabels := k8sLabels.FormatLabels(rc.Labels)
-				rcMap[rcLabels] = rc
+				rcSelector := k8sLabels.FormatLabels(rc.Spec.Selector)
+				rcMap[rcSelector] = rc
 			}
 
 			// get all SVCs
@@ -95,11 +120,49 @@ func GetGarbageCollection(c echo.Context) error {
 
 			for _, svc := range svcs {
 				logger.Debugf("service: %s/%s", svc.Namespace, svc.Name)
+
+				meta, err := models.UnmarshalMetadata(svc.Annotations[models.CITE_K8S_ANNOTATION_KEY])
+				if err == nil && !dryrun {
+					meta.Service = svc.Name
+					if err := k8s.LoadNotificationTokens(ns.Name, meta); err != nil {
+						logger.Warningf("failed to load notification tokens. ns:%s, svc:%s: %v", ns.Name, svc.Name, err)
+					}
+
+					// expire approvals nobody decided in time
+					if meta.Protection.Enable {
+						if n, err := expireApprovals(ns.Name, meta); err != nil {
+							logger.Errorf("failed to expire approvals of %s/%s: %v", ns.Name, svc.Name, err)
+						} else if n > 0 {
+							logger.Infof("expired %d approvals of %s/%s", n, ns.Name, svc.Name)
+						}
+					}
+
+					// watch again job builds left pending by restarts
+					if n, err := models.ReconcileBuildJobs(ns.Name, meta, onBuildResult); err != nil {
+						logger.Errorf("failed to reconcile build jobs of %s/%s: %v", ns.Name, svc.Name, err)
+					} else if n > 0 {
+						logger.Infof("reconciled %d build jobs of %s/%s", n, ns.Name, svc.Name)
+					}
+				}
 				svcSelector := k8sLabels.FormatLabels(svc.Spec.Selector)
 
 				// remove active RC from rcMap
 				delete(rcMap, svcSelector)
+
+				// keep both RCs of a canary in progress
+				if canary, _ := models.GetCanary(&svc); canary != nil {
+					delete(rcMap, k8sLabels.FormatLabels(canary.Stable))
+					delete(rcMap, k8sLabels.FormatLabels(canary.Canary))
+				}
+			}
+		}
+
+		if len(previewList) > 0 {
+			msgHead := fmt.Sprintf("* deleted expired previews: %v", len(previewList))
+			if dryrun {
+				msgHead += " (dryrun)"
 			}
+			noti.SendSystem(fmt.Sprintf("%s\n%s", msgHead, strings.Join(previewList, "\n")))
 		}
 
 		// remove remaining RCs
@@ -0,0 +1,425 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/kakao/cite/models"
+	"github.com/labstack/echo"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/util/sets"
+)
+
+// GetAPINamespaces returns namespaces of the user and the orgs of the user.
+func GetAPINamespaces(c echo.Context) error {
+	nss, nsOrgs, err := userNamespaces(c)
+	if err != nil {
+		return err
+	}
+
+	apiNSs := []models.APINamespace{}
+	for _, ns := range nss {
+		apiNSs = append(apiNSs, models.APINamespace{
+			Name: ns.Name,
+			Org:  nsOrgs[ns.Name],
+		})
+	}
+	return c.JSON(http.StatusOK, apiNSs)
+}
+
+func GetAPIServices(c echo.Context) error {
+	nsName := c.Param("namespace")
+
+	svcRequirement, _ := labels.NewRequirement("type", labels.DoesNotExistOperator, sets.NewString())
+	svcs, err := k8s.GetAllServices(nsName, *svcRequirement)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting all services at %s: %v", nsName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+
+	apiSvcs := []models.APIService{}
+	for _, svc := range svcs {
+		meta, ok := readableService(c, svc)
+		if !ok {
+			continue
+		}
+		apiSvcs = append(apiSvcs, models.APIService{
+			Namespace: nsName,
+			Name:      svc.Name,
+			SHA:       svc.Spec.Selector["sha"],
+			DeployID:  svc.Spec.Selector["deploy_id"],
+			Metadata:  meta.Redacted(),
+		})
+	}
+	return c.JSON(http.StatusOK, apiSvcs)
+}
+
+func GetAPIService(c echo.Context) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+
+	svc, meta, err := k8s.GetService(nsName, svcName)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting service from kubernetes %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusNotFound, errMsg)
+	}
+	return c.JSON(http.StatusOK, models.APIService{
+		Namespace: nsName,
+		Name:      svcName,
+		SHA:       svc.Spec.Selector["sha"],
+		DeployID:  svc.Spec.Selector["deploy_id"],
+		Metadata:  meta.Redacted(),
+	})
+}
+
+// GetAPIServicePods returns pods selected by the service, including pods of
+// inactive deploys.
+func GetAPIServicePods(c echo.Context) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+
+	_, meta, err := k8s.GetService(nsName, svcName)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting service from kubernetes %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusNotFound, errMsg)
+	}
+
+	pods, err := k8s.GetPods(nsName, k8s.GetServiceLabels(meta))
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting pods of %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+
+	apiPods := []models.APIPod{}
+	for _, pod := range pods {
+		containers := []string{}
+		for _, container := range pod.Spec.Containers {
+			containers = append(containers, container.Name)
+		}
+		apiPods = append(apiPods, models.APIPod{
+			Name:       pod.Name,
+			Phase:      string(pod.Status.Phase),
+			SHA:        pod.Labels["sha"],
+			DeployID:   pod.Labels["deploy_id"],
+			Containers: containers,
+		})
+	}
+	return c.JSON(http.StatusOK, apiPods)
+}
+
+// GetAPIServiceSettings returns settings of the service without secrets.
+// redacted settings can be put back as they are, since empty tokens and
+// values of secret env keep the stored ones.
+func GetAPIServiceSettings(c echo.Context) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+
+	_, meta, err := k8s.GetService(nsName, svcName)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting service from kubernetes %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusNotFound, errMsg)
+	}
+	return c.JSON(http.StatusOK, meta.Redacted())
+}
+
+// PutAPIServiceSettings updates settings of the service by the json body.
+// fields missing in the body are left as they are. values of secret env are
+// not exposed by the api, so secret env keeps the values of listed keys.
+func PutAPIServiceSettings(c echo.Context) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+
+	_, meta, err := k8s.GetService(nsName, svcName)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting service from kubernetes %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusNotFound, errMsg)
+	}
+	form := *meta
+	if err := c.Bind(&form); err != nil {
+		errMsg := fmt.Sprintf("error while parsing settings of %s/%s: %v", nsName, svcName, err)
+		logger.Warning(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+
+	// the service is authorized by its repo, which must not be moved by
+	// its settings
+	form.Namespace = nsName
+	form.Service = svcName
+	form.GithubOrg = meta.GithubOrg
+	form.GithubRepo = meta.GithubRepo
+	form.GitBranch = meta.GitBranch
+	form.SecretEnvironment = nil
+	for _, key := range form.SecretEnvKeys {
+		form.SecretEnvironment = append(form.SecretEnvironment, models.SecretEnv{Key: key})
+	}
+
+	if err := checkProtectionChange(c, nsName, svcName, &form); err != nil {
+		logger.Warning(err)
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	if err := updateServiceSettings(nsName, svcName, &form); err != nil {
+		logger.Warning(err)
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	_, token := currentUser(c)
+	upsertHook(token, &form)
+	return c.JSON(http.StatusOK, form.Redacted())
+}
+
+func PostAPIBuild(c echo.Context) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+
+	req := new(models.APIBuildRequest)
+	if err := c.Bind(req); err != nil || len(req.SHA) == 0 {
+		errMsg := fmt.Sprintf("sha required to build %s/%s: %v", nsName, svcName, err)
+		logger.Warning(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+
+	_, meta, err := k8s.GetService(nsName, svcName)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting service from kubernetes %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusNotFound, errMsg)
+	}
+
+	login, _ := currentUser(c)
+	err = queueBuild(nsName, meta, req.SHA, login, false, func(builder models.Builder) error {
+		return builder.Build(nsName, meta, "", req.SHA, onBuildResult)
+	})
+	if err == models.ErrBuildInProgress {
+		errMsg := fmt.Sprintf("%s is already building", req.SHA)
+		return echo.NewHTTPError(http.StatusConflict, errMsg)
+	} else if err != nil {
+		errMsg := fmt.Sprintf("failed to start build: %v", err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+
+	records, err := k8s.GetBuildRecords(nsName, svcName)
+	if err != nil || len(records) == 0 {
+		errMsg := fmt.Sprintf("error while getting builds %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	return c.JSON(http.StatusAccepted, records[0])
+}
+
+func PostAPICancelBuild(c echo.Context) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+	id := c.Param("id")
+
+	if err := cancelBuild(nsName, svcName, id); err != nil {
+		return err
+	}
+
+	record, err := k8s.GetBuildRecord(nsName, svcName, id)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting build %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	return c.JSON(http.StatusOK, record)
+}
+
+// PostAPIDeploy starts a deploy of the image, and returns the github
+// deployment id to follow it in the history.
+func PostAPIDeploy(c echo.Context) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+
+	req := new(models.APIDeployRequest)
+	if err := c.Bind(req); err != nil || len(req.SHA) == 0 {
+		errMsg := fmt.Sprintf("sha required to deploy %s/%s: %v", nsName, svcName, err)
+		logger.Warning(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+
+	login, token := currentUser(c)
+	deployID, approval, err := startDeploy(token, login, nsName, svcName, req.SHA, req.Image, req.Environment)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusAccepted, models.APIDeployResponse{
+		DeployID: deployID,
+		SHA:      req.SHA,
+		Image:    req.Image,
+		Approval: approval,
+	})
+}
+
+// PostAPIActivate routes the service to the deploy, and returns the service.
+// activations of protected services return the pending approval instead.
+func PostAPIActivate(c echo.Context) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+
+	req := new(models.APIActivateRequest)
+	if err := c.Bind(req); err != nil || len(req.SHA) == 0 || len(req.DeployID) == 0 {
+		errMsg := fmt.Sprintf("sha and deploy_id required to activate %s/%s: %v", nsName, svcName, err)
+		logger.Warning(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+	if _, err := strconv.Atoi(req.DeployID); err != nil {
+		errMsg := fmt.Sprintf("invalid deploy_id %s: %v", req.DeployID, err)
+		logger.Warning(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+
+	login, _ := currentUser(c)
+	approval, err := holdActivate(login, nsName, svcName, req.SHA, req.DeployID)
+	if err != nil {
+		return err
+	} else if approval != nil {
+		return c.JSON(http.StatusAccepted, models.APIDeployResponse{
+			DeployID: approval.DeployID,
+			SHA:      approval.SHA,
+			Approval: approval,
+		})
+	}
+
+	if err := activateService(nsName, svcName, req.SHA, req.DeployID); err != nil {
+		return err
+	}
+	return GetAPIService(c)
+}
+
+func PostAPIScale(c echo.Context) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+
+	req := new(models.APIScaleRequest)
+	if err := c.Bind(req); err != nil || req.Replicas <= 0 {
+		errMsg := fmt.Sprintf("positive replicas required to scale %s/%s: %v", nsName, svcName, err)
+		logger.Warning(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+
+	logger.Info(fmt.Sprintf("scale request. ns:%s, svc:%s, rc:%s, replicas:%d", nsName, svcName, req.RC, req.Replicas))
+	if err := scaleService(nsName, svcName, req.RC, req.Replicas); err != nil {
+		return err
+	}
+	return GetAPIService(c)
+}
+
+func GetAPIPipelines(c echo.Context) error {
+	nsName := c.Param("namespace")
+
+	pipelines, err := k8s.GetPipelines(nsName)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to get pipelines of %s: %v", nsName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	return c.JSON(http.StatusOK, pipelines)
+}
+
+func GetAPIPipeline(c echo.Context) error {
+	view, err := pipelineView(c.Param("namespace"), c.Param("pipeline"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, view)
+}
+
+// PutAPIPipeline creates the pipeline of the json body, or replaces stages of
+// the existing one.
+func PutAPIPipeline(c echo.Context) error {
+	nsName := c.Param("namespace")
+
+	var pipeline models.Pipeline
+	if err := c.Bind(&pipeline); err != nil {
+		errMsg := fmt.Sprintf("error while parsing pipeline: %v", err)
+		logger.Warning(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+	pipeline.Name = c.Param("pipeline")
+	if err := savePipeline(c, nsName, pipeline); err != nil {
+		return err
+	}
+	view, err := pipelineView(nsName, pipeline.Name)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, view)
+}
+
+func DeleteAPIPipeline(c echo.Context) error {
+	nsName := c.Param("namespace")
+	name := c.Param("pipeline")
+
+	if err := k8s.DeletePipeline(nsName, name); err != nil {
+		errMsg := fmt.Sprintf("failed to delete pipeline %s/%s: %v", nsName, name, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusNotFound, errMsg)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func PostAPIPromotion(c echo.Context) error {
+	req := new(models.APIPromoteRequest)
+	if err := c.Bind(req); err != nil || len(req.From) == 0 {
+		errMsg := fmt.Sprintf("stage to promote from required: %v", err)
+		logger.Warning(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+	promotion, err := requestPromotion(c, c.Param("namespace"), c.Param("pipeline"), req.From)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusAccepted, promotion)
+}
+
+func PostAPIApprovePromotion(c echo.Context) error {
+	promotion, err := decidePromotion(c, c.Param("namespace"), c.Param("pipeline"), c.Param("id"), true)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusAccepted, promotion)
+}
+
+func PostAPIRejectPromotion(c echo.Context) error {
+	promotion, err := decidePromotion(c, c.Param("namespace"), c.Param("pipeline"), c.Param("id"), false)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, promotion)
+}
+
+// GetAPIApprovals returns approvals of the service, latest first.
+func GetAPIApprovals(c echo.Context) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+	approvals, err := k8s.GetApprovals(nsName, svcName)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting approvals %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	return c.JSON(http.StatusOK, approvals)
+}
+
+func PostAPIApproveApproval(c echo.Context) error {
+	approval, err := decideApproval(c, c.Param("namespace"), c.Param("service"), c.Param("id"), true)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, approval)
+}
+
+func PostAPIRejectApproval(c echo.Context) error {
+	approval, err := decideApproval(c, c.Param("namespace"), c.Param("service"), c.Param("id"), false)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, approval)
+}
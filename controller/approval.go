@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/kakao/cite/goroutines"
+	"github.com/kakao/cite/models"
+	"github.com/labstack/echo"
+)
+
+// deployService deploys the image in background, or requests an approval of
+// the deploy if the service is protected. requestedBy cannot approve the
+// deploy.
+func deployService(nsName string, meta *models.Metadata, sha, imageName string, deployID int, triggeredBy, requestedBy string) {
+	if !meta.Protection.Enable {
+		go goroutines.NewDeployer().Deploy(meta, sha, imageName, deployID, triggeredBy)
+		return
+	}
+	approval := models.NewApproval(meta, models.APPROVAL_ACTION_DEPLOY, sha, imageName, deployID, triggeredBy, requestedBy)
+	if err := requestApproval(nsName, meta, approval); err != nil {
+		logger.Error(err)
+	}
+}
+
+// requestApproval records the pending approval and notifies approvers
+// through notifications of the service.
+func requestApproval(nsName string, meta *models.Metadata, approval models.Approval) error {
+	if err := k8s.AddApproval(nsName, meta.Service, approval); err != nil {
+		return fmt.Errorf("failed to request approval of %s %s/%s:%s: %v",
+			approval.Action, nsName, meta.Service, approval.SHA, err)
+	}
+	logger.Infof("%s requested approval %s of %s %s/%s:%s",
+		approval.RequestedBy, approval.ID, approval.Action, nsName, meta.Service, approval.SHA)
+	notifyApproval(nsName, meta, approval, models.EVENT_APPROVAL_REQUESTED, fmt.Sprintf(
+		"%s of %s requested by %s needs %d approvals until %s.\n%s/namespaces/%s/services/%s",
+		approval.Action, approval.SHA, approval.RequestedBy, approval.Required,
+		approval.ExpiresAt.Format("2006-01-02 15:04:05"), models.Conf.Cite.Host, nsName, meta.Service))
+	return nil
+}
+
+func notifyApproval(nsName string, meta *models.Metadata, approval models.Approval, event models.EventKind, text string) {
+	noti.SendMessageWithFallback(meta.Notification, meta.Watchcenter, models.Message{
+		Event:     event,
+		Text:      text,
+		State:     event.State(),
+		Namespace: nsName,
+		Service:   meta.Service,
+		SHA:       approval.SHA,
+		DeployID:  approval.DeployID,
+		Image:     approval.Image,
+		CommitURL: commonGitHub.GetCommitURL(meta.GithubOrg, meta.GithubRepo, approval.SHA),
+	})
+}
+
+// holdActivate requests an approval of the activation if the service is
+// protected. nil is returned for services which activate right away.
+func holdActivate(login, nsName, svcName, sha, deployID string) (*models.Approval, error) {
+	_, meta, err := k8s.GetService(nsName, svcName)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to get kubernetes service %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	if !meta.Protection.Enable {
+		return nil, nil
+	}
+	id, err := strconv.Atoi(deployID)
+	if err != nil {
+		errMsg := fmt.Sprintf("invalid deploy_id %s: %v", deployID, err)
+		logger.Warning(errMsg)
+		return nil, echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+	approval := models.NewApproval(meta, models.APPROVAL_ACTION_ACTIVATE, sha, "", id, login, login)
+	if err := requestApproval(nsName, meta, approval); err != nil {
+		logger.Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return &approval, nil
+}
+
+// checkProtectionChange allows only repo admins to change the protection of
+// the service, so that protection is not lifted by the users it holds.
+func checkProtectionChange(c echo.Context, nsName, svcName string, form *models.Metadata) error {
+	_, meta, err := k8s.GetService(nsName, svcName)
+	if err != nil {
+		return fmt.Errorf("error while getting service from kubernetes %s/%s: %v", nsName, svcName, err)
+	}
+	if reflect.DeepEqual(meta.Protection, form.Protection) {
+		return nil
+	}
+	perm, err := repoPermission(c, meta.GithubOrg, meta.GithubRepo)
+	if err != nil {
+		return fmt.Errorf("failed to get permission on %s/%s: %v", meta.GithubOrg, meta.GithubRepo, err)
+	}
+	if perm < models.PERMISSION_ADMIN {
+		return fmt.Errorf("%s permission required to change protection of %s/%s", models.PERMISSION_ADMIN, nsName, svcName)
+	}
+	return nil
+}
+
+func PutApproveApproval(c echo.Context) error {
+	return putDecideApproval(c, true)
+}
+
+func PutRejectApproval(c echo.Context) error {
+	return putDecideApproval(c, false)
+}
+
+func putDecideApproval(c echo.Context, approve bool) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+	approval, err := decideApproval(c, nsName, svcName, c.Param("id"), approve)
+	if err != nil {
+		return err
+	}
+
+	session := getSession(c)
+	switch approval.State {
+	case models.APPROVAL_STATE_PENDING:
+		session.AddFlash(fmt.Sprintf("approved %s of %s. %d more approvals needed",
+			approval.Action, approval.SHA, approval.Required-len(approval.Approvers)))
+	default:
+		session.AddFlash(fmt.Sprintf("%s of %s %s", approval.Action, approval.SHA, approval.State))
+	}
+	saveSession(session, c)
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/namespaces/%s/services/%s", nsName, svcName))
+}
+
+// decideApproval approves or rejects the pending approval as the current
+// user, and resumes the deploy or the activation once approved. the
+// authorization middleware has checked push permission on the repo.
+func decideApproval(c echo.Context, nsName, svcName, id string, approve bool) (models.Approval, error) {
+	_, meta, err := k8s.GetService(nsName, svcName)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting service from kubernetes %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return models.Approval{}, echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+
+	login, _ := currentUser(c)
+	var approval models.Approval
+	if approve {
+		approval, err = k8s.ApproveApproval(nsName, svcName, id, login)
+	} else {
+		approval, err = k8s.RejectApproval(nsName, svcName, id, login)
+	}
+	switch err {
+	case nil:
+	case models.ErrApprovalExpired:
+		notifyApproval(nsName, meta, approval, models.EVENT_APPROVAL_EXPIRED,
+			fmt.Sprintf("%s of %s expired without approval.", approval.Action, approval.SHA))
+		return approval, echo.NewHTTPError(http.StatusGone, err.Error())
+	case models.ErrApprovalSelf:
+		return approval, echo.NewHTTPError(http.StatusForbidden, err.Error())
+	case models.ErrApprovalDecided, models.ErrApprovalDuplicated:
+		return approval, echo.NewHTTPError(http.StatusConflict, err.Error())
+	default:
+		errMsg := fmt.Sprintf("failed to decide approval %s of %s/%s: %v", id, nsName, svcName, err)
+		logger.Error(errMsg)
+		return approval, echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+
+	switch approval.State {
+	case models.APPROVAL_STATE_REJECTED:
+		logger.Infof("%s rejected approval %s of %s/%s", login, id, nsName, svcName)
+		notifyApproval(nsName, meta, approval, models.EVENT_APPROVAL_REJECTED,
+			fmt.Sprintf("%s of %s rejected by %s.", approval.Action, approval.SHA, login))
+		return approval, nil
+	case models.APPROVAL_STATE_PENDING:
+		logger.Infof("%s approved approval %s of %s/%s, %d/%d",
+			login, id, nsName, svcName, len(approval.Approvers), approval.Required)
+		return approval, nil
+	}
+
+	logger.Infof("%s approved approval %s of %s/%s, resuming %s", login, id, nsName, svcName, approval.Action)
+	notifyApproval(nsName, meta, approval, models.EVENT_APPROVAL_APPROVED,
+		fmt.Sprintf("%s of %s approved by %s.", approval.Action, approval.SHA, approverLogins(approval)))
+	switch approval.Action {
+	case models.APPROVAL_ACTION_DEPLOY:
+		go goroutines.NewDeployer().Resume(meta, approval)
+	case models.APPROVAL_ACTION_ACTIVATE:
+		if err := activateService(nsName, svcName, approval.SHA, strconv.Itoa(approval.DeployID)); err != nil {
+			return approval, err
+		}
+		if err := k8s.AddDeployApproval(nsName, svcName, approval.DeployID, approval); err != nil {
+			logger.Warningf("failed to record approval %s of %s/%s: %v", id, nsName, svcName, err)
+		}
+	}
+	return approval, nil
+}
+
+func approverLogins(approval models.Approval) string {
+	logins := []string{}
+	for _, approver := range approval.Approvers {
+		logins = append(logins, approver.Login)
+	}
+	return strings.Join(logins, ", ")
+}
+
+// expireApprovals expires pending approvals of the protected service which
+// outlived their TTL, notifies them and returns how many expired.
+func expireApprovals(nsName string, meta *models.Metadata) (int, error) {
+	expired, err := k8s.ExpireApprovals(nsName, meta.Service)
+	if err != nil {
+		return 0, err
+	}
+	for _, approval := range expired {
+		notifyApproval(nsName, meta, approval, models.EVENT_APPROVAL_EXPIRED,
+			fmt.Sprintf("%s of %s expired without approval.", approval.Action, approval.SHA))
+	}
+	return len(expired), nil
+}
@@ -0,0 +1,265 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kakao/cite/models"
+	"github.com/labstack/echo"
+	k8sApi "k8s.io/kubernetes/pkg/api"
+)
+
+// github lookups of authorization are cached on the server per user, since
+// they would otherwise be made on every request. sessions are not trusted
+// with them, and the TTL keeps revoked permissions from lasting long.
+const PERMISSION_CACHE_TTL = time.Minute
+
+type authzCacheEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+var (
+	authzCache      = make(map[string]authzCacheEntry)
+	authzCacheMutex sync.Mutex
+)
+
+// cachedLookup returns the value cached for the user and the key, or looks
+// it up and caches it for PERMISSION_CACHE_TTL.
+func cachedLookup(login, key string, lookup func() (interface{}, error)) (interface{}, error) {
+	cacheKey := login + "|" + key
+	now := time.Now()
+	authzCacheMutex.Lock()
+	entry, ok := authzCache[cacheKey]
+	authzCacheMutex.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.value, nil
+	}
+
+	value, err := lookup()
+	if err != nil {
+		return nil, err
+	}
+	authzCacheMutex.Lock()
+	defer authzCacheMutex.Unlock()
+	// drop expired entries on the way, so the cache does not grow with users
+	for k, e := range authzCache {
+		if now.After(e.expiresAt) {
+			delete(authzCache, k)
+		}
+	}
+	authzCache[cacheKey] = authzCacheEntry{value: value, expiresAt: now.Add(PERMISSION_CACHE_TTL)}
+	return value, nil
+}
+
+// Authorize requires the permission on the target of the route. services
+// are authorized by the repo permission of the user, and namespaces by the
+// membership of the github org which the namespace is named after.
+func Authorize(required models.Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			nsName, owner, repo, err := authzTarget(c)
+			if err != nil {
+				errMsg := fmt.Sprintf("failed to find authorization target of %s: %v", c.Path(), err)
+				logger.Error(errMsg)
+				return echo.NewHTTPError(http.StatusNotFound, errMsg)
+			}
+
+			var perm models.Permission
+			if len(repo) > 0 {
+				perm, err = repoPermission(c, owner, repo)
+			} else {
+				perm, err = namespacePermission(c, nsName)
+			}
+			if err != nil {
+				errMsg := fmt.Sprintf("failed to get permission on %s: %v", c.Path(), err)
+				logger.Error(errMsg)
+				return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+			}
+			if perm < required {
+				login, _ := currentUser(c)
+				errMsg := fmt.Sprintf("%s permission required, but %s has %s permission",
+					required, login, perm)
+				logger.Warning(errMsg)
+				return echo.NewHTTPError(http.StatusForbidden, errMsg)
+			}
+			return next(c)
+		}
+	}
+}
+
+// authzTarget returns the namespace, and the repo of the service if the
+// route is about a service.
+func authzTarget(c echo.Context) (nsName, owner, repo string, err error) {
+	if org := c.Param("github_org"); len(org) > 0 {
+		return util.NormalizeByHyphen("", org), org, c.Param("github_repo"), nil
+	}
+
+	nsName = c.Param("namespace")
+	if len(nsName) == 0 {
+		nsName = c.Param("nsName")
+	}
+	svcName := c.Param("service")
+	if len(svcName) == 0 {
+		svcName = c.Param("svcName")
+	}
+
+	// build logs are authorized by the service of the build job
+	if jobName := c.Param("job"); len(jobName) > 0 {
+		job, err := k8s.GetBuildJob(nsName, jobName)
+		if err != nil {
+			return "", "", "", err
+		}
+		svcName = job.Labels[models.BUILD_SERVICE_LABEL_KEY]
+		if len(svcName) == 0 {
+			return "", "", "", fmt.Errorf("service of build job %s/%s not found", nsName, jobName)
+		}
+	}
+
+	// delete routes name the resource by type
+	switch c.Param("type") {
+	case "svc":
+		svcName = c.Param("name")
+	case "rc":
+		rc, err := k8s.GetReplicationController(nsName, c.Param("name"))
+		if err != nil {
+			return "", "", "", err
+		}
+		return serviceRepo(nsName, rc.Labels)
+	case "po":
+		pod, err := k8s.GetPod(nsName, c.Param("name"))
+		if err != nil {
+			return "", "", "", err
+		}
+		return serviceRepo(nsName, pod.Labels)
+	}
+
+	if len(svcName) == 0 {
+		return nsName, "", "", nil
+	}
+	_, meta, err := k8s.GetService(nsName, svcName)
+	if err != nil {
+		return "", "", "", err
+	}
+	return nsName, meta.GithubOrg, meta.GithubRepo, nil
+}
+
+// serviceRepo returns the repo of the service owning the labeled resource.
+// resources of no service are authorized by their namespace.
+func serviceRepo(nsName string, labels map[string]string) (string, string, string, error) {
+	svcLabels := map[string]string{
+		"service": labels["service"],
+		"branch":  labels["branch"],
+	}
+	svcs, err := k8s.GetServices(nsName, svcLabels)
+	if err != nil || len(svcs) == 0 {
+		return nsName, "", "", nil
+	}
+	meta, err := models.UnmarshalMetadata(svcs[0].Annotations[models.CITE_K8S_ANNOTATION_KEY])
+	if err != nil {
+		return "", "", "", err
+	}
+	return nsName, meta.GithubOrg, meta.GithubRepo, nil
+}
+
+func namespacePermission(c echo.Context, nsName string) (models.Permission, error) {
+	login, token := currentUser(c)
+	githubClient := models.NewGitHub(token)
+
+	org, err := namespaceOrg(githubClient, login, nsName)
+	if err != nil {
+		return models.PERMISSION_NONE, err
+	}
+	if len(org) == 0 {
+		return models.PERMISSION_NONE, nil
+	}
+
+	return cachedPermission(c, "org:"+org, func() (models.Permission, error) {
+		return githubClient.GetOrgPermission(login, org)
+	})
+}
+
+// namespaceOrg returns the org of the namespace, cached for the user.
+func namespaceOrg(githubClient *models.GitHub, login, nsName string) (string, error) {
+	org, err := cachedLookup(login, "ns:"+nsName, func() (interface{}, error) {
+		return githubClient.GetNamespaceOrg(login, nsName)
+	})
+	if err != nil {
+		return "", err
+	}
+	return org.(string), nil
+}
+
+func repoPermission(c echo.Context, owner, repo string) (models.Permission, error) {
+	_, token := currentUser(c)
+	githubClient := models.NewGitHub(token)
+	return cachedPermission(c, "repo:"+owner+"/"+repo, func() (models.Permission, error) {
+		return githubClient.GetRepoPermission(owner, repo)
+	})
+}
+
+// cachedPermission returns the permission of the current user cached on the
+// server, or looks it up and caches it.
+func cachedPermission(c echo.Context, key string, lookup func() (models.Permission, error)) (models.Permission, error) {
+	login, _ := currentUser(c)
+	perm, err := cachedLookup(login, key, func() (interface{}, error) {
+		return lookup()
+	})
+	if err != nil {
+		return models.PERMISSION_NONE, err
+	}
+	return perm.(models.Permission), nil
+}
+
+// userNamespaces returns namespaces named after the user or orgs of the user,
+// and the org of each namespace.
+func userNamespaces(c echo.Context) ([]k8sApi.Namespace, map[string]string, error) {
+	login, token := currentUser(c)
+
+	githubClient := models.NewGitHub(token)
+	orgs, err := githubClient.ListOrgs()
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting github organizations: %v", err)
+		logger.Error(errMsg)
+		return nil, nil, echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	nsOrgs := map[string]string{
+		util.NormalizeByHyphen("", login): login,
+	}
+	for _, org := range orgs {
+		nsOrgs[util.NormalizeByHyphen("", *org.Login)] = *org.Login
+	}
+
+	nss, err := k8s.GetAllNamespaces()
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting all namespaces: %v", err)
+		logger.Error(errMsg)
+		return nil, nil, echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	userNSs := []k8sApi.Namespace{}
+	for _, ns := range nss {
+		if _, ok := nsOrgs[ns.Name]; ok {
+			userNSs = append(userNSs, ns)
+		}
+	}
+	return userNSs, nsOrgs, nil
+}
+
+// readableService returns the metadata of the service if the user can read
+// its repo. namespaces are authorized by their org, so listings of a
+// namespace leave out services of repos the user cannot read.
+func readableService(c echo.Context, svc k8sApi.Service) (*models.Metadata, bool) {
+	meta, err := models.UnmarshalMetadata(svc.Annotations[models.CITE_K8S_ANNOTATION_KEY])
+	if err != nil {
+		logger.Warningf("failed to unmarshal metadata of %s/%s: %v", svc.Namespace, svc.Name, err)
+		return nil, false
+	}
+	perm, err := repoPermission(c, meta.GithubOrg, meta.GithubRepo)
+	if err != nil {
+		logger.Warningf("failed to get permission on %s/%s: %v", meta.GithubOrg, meta.GithubRepo, err)
+		return nil, false
+	}
+	return meta, perm >= models.PERMISSION_READ
+}
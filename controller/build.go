@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kakao/cite/models"
+	"github.com/labstack/echo"
+)
+
+// onBuildResult notifies build results and deploys built images of auto
+// deploy services. builders call it back, and buildbot results are passed
+// from github status events.
+func onBuildResult(meta *models.Metadata, result models.BuildResult) {
+	record, err := k8s.UpdateBuildRecord(result.Namespace, result.Service, result)
+	if err != nil {
+		logger.Errorf("failed to record build of %s/%s:%s: %v", result.Namespace, result.Service, result.SHA, err)
+	}
+	// canceled builds are neither notified nor deployed
+	if result.State == models.BUILD_STATE_CANCELED || record.State == models.BUILD_STATE_CANCELED {
+		return
+	}
+
+	msg := models.Message{
+		Namespace: result.Namespace,
+		Service:   result.Service,
+		SHA:       result.SHA,
+		Image:     result.Image,
+		Log:       result.Log,
+		CommitURL: commonGitHub.GetCommitURL(meta.GithubOrg, meta.GithubRepo, result.SHA),
+		BuildURL:  result.BuildURL,
+	}
+	switch result.State {
+	case models.BUILD_STATE_PENDING:
+		msg.Event = models.EVENT_BUILD_STARTED
+		msg.Text = fmt.Sprintf("build started: %s/%s/%s:%s", meta.GithubOrg, meta.GithubRepo, meta.GitBranch, result.SHA)
+	case models.BUILD_STATE_SUCCESS:
+		msg.Event = models.EVENT_BUILD_SUCCESS
+		msg.Text = fmt.Sprintf("build success. image name: %s", result.Image)
+	default:
+		msg.Event = models.EVENT_BUILD_FAILURE
+		msg.Text = fmt.Sprintf("build failed.\n* lastlog\n%s", result.Log)
+	}
+	msg.State = msg.Event.State()
+	noti.SendMessageWithFallback(meta.Notification, meta.Watchcenter, msg)
+
+	if result.State == models.BUILD_STATE_SUCCESS && (meta.AutoDeploy || record.Deploy) {
+		triggeredBy := "auto deploy"
+		if record.Deploy {
+			triggeredBy = "deploy trigger"
+		}
+		deployService(result.Namespace, meta, result.SHA, result.Image, -1, triggeredBy, triggeredBy)
+	}
+}
+
+// queueBuild records the build of the service and starts it by start.
+// models.ErrBuildInProgress is returned if the commit is already being built.
+func queueBuild(nsName string, meta *models.Metadata, sha, requestedBy string, deploy bool, start func(builder models.Builder) error) error {
+	builderName := meta.BuilderName()
+	builder, err := models.GetBuilder(builderName)
+	if err != nil {
+		return err
+	}
+	record := models.NewBuildRecord(builderName, sha, requestedBy)
+	record.Deploy = deploy
+	if err := k8s.AddBuildRecord(nsName, meta.Service, record); err != nil {
+		return err
+	}
+	if err := start(builder); err != nil {
+		result := models.BuildResult{
+			Builder:   builderName,
+			Namespace: nsName,
+			Service:   meta.Service,
+			SHA:       sha,
+			State:     models.BUILD_STATE_FAILURE,
+		}
+		if _, recErr := k8s.UpdateBuildRecord(nsName, meta.Service, result); recErr != nil {
+			logger.Errorf("failed to record build of %s/%s:%s: %v", nsName, meta.Service, sha, recErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func GetBuilds(c echo.Context) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+
+	_, meta, err := k8s.GetService(nsName, svcName)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting service from kubernetes %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+
+	records, err := k8s.GetBuildRecords(nsName, svcName)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting builds %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+
+	return c.Render(http.StatusOK, "builds",
+		map[string]interface{}{
+			"nsName":     nsName,
+			"svcName":    svcName,
+			"githubOrg":  meta.GithubOrg,
+			"githubRepo": meta.GithubRepo,
+			"builds":     records,
+		})
+}
+
+func GetBuildsJSON(c echo.Context) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+
+	records, err := k8s.GetBuildRecords(nsName, svcName)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting builds %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	return c.JSON(http.StatusOK, records)
+}
+
+func PutCancelBuild(c echo.Context) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+	id := c.Param("id")
+
+	if err := cancelBuild(nsName, svcName, id); err != nil {
+		return err
+	}
+
+	session := getSession(c)
+	session.AddFlash("build canceled")
+	saveSession(session, c)
+	return c.Redirect(http.StatusFound, c.Request().Referer())
+}
+
+// cancelBuild marks the build record canceled and stops the build.
+func cancelBuild(nsName, svcName, id string) error {
+	_, meta, err := k8s.GetService(nsName, svcName)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting service from kubernetes %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+
+	record, err := k8s.GetBuildRecord(nsName, svcName, id)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting build %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusNotFound, errMsg)
+	}
+	builder, err := models.GetBuilder(record.Builder)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to get builder of %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+
+	// mark the record first, so that results reported while stopping are
+	// ignored
+	if err := k8s.CancelBuildRecord(nsName, svcName, id); err != nil {
+		errMsg := fmt.Sprintf("failed to cancel build %s/%s:%s: %v", nsName, svcName, record.SHA, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+	if err := builder.Cancel(nsName, meta, record); err != nil {
+		errMsg := fmt.Sprintf("failed to stop build %s/%s:%s: %v", nsName, svcName, record.SHA, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	return nil
+}
+
+func GetBuildLog(c echo.Context) error {
+	nsName := c.Param("namespace")
+	jobName := c.Param("job")
+
+	log, err := k8s.GetBuildJobLog(nsName, jobName, 0)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to get log of build job %s/%s: %v", nsName, jobName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	return c.String(http.StatusOK, log)
+}
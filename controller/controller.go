@@ -3,31 +3,42 @@ package controller
 import (
 	"encoding/gob"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/deckarep/golang-set"
 	"github.com/gorilla/schema"
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"github.com/kakao/cite/models"
 	"github.com/labstack/echo"
 	gologging "github.com/op/go-logging"
 )
 
+const (
+	CONTEXT_API_TOKEN = "apiToken"
+)
+
 var (
 	err    error
 	logger = gologging.MustGetLogger("stdout")
 
-	es             = models.NewElastic()
-	k8s            = models.NewKubernetes()
-	util           = models.NewUtil()
-	buildbotClient = models.NewBuildBot()
-	docker         = models.NewDocker()
-	commonGitHub   = models.NewCommonGitHub()
-	GMT, _         = time.LoadLocation("GMT")
-	noti           = models.NewNotifier()
-	watchcenter    = models.NewWatchCenter()
-
-	sessionStore = sessions.NewCookieStore([]byte("1VMo28DykUsIM1L8"))
+	// checked before clients below connect with empty config
+	_ = mustConfig()
+
+	es               = models.NewElastic()
+	k8s              = models.NewKubernetes()
+	util             = models.NewUtil()
+	buildbotClient   = models.NewBuildBot()
+	docker           = models.NewDocker()
+	githubDeliveries = models.NewGitHubDeliveries()
+	commonGitHub     = models.NewCommonGitHub()
+	GMT, _           = time.LoadLocation("GMT")
+	noti             = models.NewNotifier()
+	watchcenter      = models.NewWatchCenter()
+
+	sessionStore = newSessionStore()
 	formDecoder  = schema.NewDecoder()
 )
 
@@ -38,6 +49,32 @@ func init() {
 	}
 
 	gob.Register(mapset.NewSet())
+
+	formDecoder.IgnoreUnknownKeys(true)
+}
+
+// newSessionStore returns the cookie store with keys from the config. cookies
+// carry the user login and token, so the keys must not be public.
+func newSessionStore() *sessions.CookieStore {
+	hashKey := []byte(models.Conf.Cite.SessionHashKey)
+	blockKey := []byte(models.Conf.Cite.SessionBlockKey)
+	if len(hashKey) == 0 || len(blockKey) == 0 {
+		logger.Warning("no session keys configured. sessions are lost on restarts")
+	}
+	if len(hashKey) == 0 {
+		hashKey = securecookie.GenerateRandomKey(64)
+	}
+	if len(blockKey) == 0 {
+		blockKey = securecookie.GenerateRandomKey(32)
+	}
+	return sessions.NewCookieStore(hashKey, blockKey)
+}
+
+func mustConfig() bool {
+	if len(models.ConfigFile) == 0 {
+		logger.Panic("no config file found at conf/cite.yaml or /etc/conf/cite.yaml")
+	}
+	return true
 }
 
 func getSession(c echo.Context) *sessions.Session {
@@ -65,6 +102,28 @@ func destroySession(c echo.Context) error {
 	return saveSession(session, c)
 }
 
+// bindMetadata binds form values into meta. echo's binder doesn't handle
+// nested structs, so fields like "noti.0.driver" or "strategy.type" are
+// decoded by gorilla/schema.
+func bindMetadata(c echo.Context, meta *models.Metadata) error {
+	if err := c.Bind(meta); err != nil {
+		return err
+	}
+
+	params, err := c.FormParams()
+	if err != nil {
+		return err
+	}
+
+	nested := url.Values{}
+	for k, v := range params {
+		if strings.Contains(k, ".") {
+			nested[k] = v
+		}
+	}
+	return formDecoder.Decode(meta, nested)
+}
+
 func AuthAPI(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		session := getSession(c)
@@ -76,6 +135,36 @@ func AuthAPI(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+// AuthToken authenticates api requests by the personal token in the
+// Authorization header, e.g. "Authorization: token cite_...".
+func AuthToken(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		fields := strings.Fields(c.Request().Header.Get(echo.HeaderAuthorization))
+		if len(fields) != 2 || (strings.ToLower(fields[0]) != "token" && strings.ToLower(fields[0]) != "bearer") {
+			return echo.ErrUnauthorized
+		}
+		apiToken, err := k8s.GetAPIToken(fields[1])
+		if err != nil {
+			logger.Warningf("invalid api token from %s: %v", c.RealIP(), err)
+			return echo.ErrUnauthorized
+		}
+		c.Set(CONTEXT_API_TOKEN, apiToken)
+		return next(c)
+	}
+}
+
+// currentUser returns the login and github token of the user, authenticated
+// by either the api token or the session.
+func currentUser(c echo.Context) (string, string) {
+	if apiToken, ok := c.Get(CONTEXT_API_TOKEN).(*models.APIToken); ok {
+		return apiToken.UserLogin, apiToken.GitHubToken
+	}
+	session := getSession(c)
+	login, _ := session.Values["userLogin"].(string)
+	token, _ := session.Values["token"].(string)
+	return login, token
+}
+
 func AuthWeb(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		session := getSession(c)
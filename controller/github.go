@@ -1,26 +1,65 @@
 package controller
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"strings"
 
 	githubClient "github.com/google/go-github/github"
-	"github.com/kakao/cite/goroutines"
 	"github.com/kakao/cite/models"
 	"github.com/labstack/echo"
 )
 
+// PostGithubCallback handles github webhooks. events must be signed by the
+// webhook secret, and each delivery is handled once.
 func PostGithubCallback(c echo.Context) error {
 	githubEvent, ok := c.Request().Header["X-GitHub-Event"]
 	if !ok {
 		githubEvent = []string{"unknown"}
 	}
 	logger.Info("received github event:", githubEvent)
-	var body = clearJSONRepoOrgField(c.Request().Body)
 
+	payload, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while reading github event %v: %v", githubEvent, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+
+	signature := c.Request().Header.Get("X-Hub-Signature-256")
+	if len(signature) == 0 {
+		signature = c.Request().Header.Get("X-Hub-Signature")
+	}
+	if err := models.VerifyWebhookSignature(signature, payload); err != nil {
+		errMsg := fmt.Sprintf("invalid signature of github event %v from %s: %v", githubEvent, c.RealIP(), err)
+		logger.Warning(errMsg)
+		return echo.NewHTTPError(http.StatusUnauthorized, errMsg)
+	}
+
+	deliveryID := c.Request().Header.Get("X-GitHub-Delivery")
+	if len(deliveryID) == 0 {
+		errMsg := fmt.Sprintf("delivery id of github event %v missing", githubEvent)
+		logger.Warning(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+	if !githubDeliveries.Receive(deliveryID) {
+		logger.Warningf("duplicated delivery %s of github event %v. skipping...", deliveryID, githubEvent)
+		return c.String(http.StatusOK, "duplicated delivery skipped")
+	}
+
+	err = handleGithubEvent(c, githubEvent, clearJSONRepoOrgField(bytes.NewReader(payload)))
+	if err != nil {
+		// let github redeliver the event
+		githubDeliveries.Forget(deliveryID)
+	}
+	return err
+}
+
+func handleGithubEvent(c echo.Context, githubEvent []string, body []byte) error {
 	switch githubEvent[0] {
 	case "push":
 		// check if pushed repo/branch is registered to cite
@@ -31,15 +70,48 @@ func PostGithubCallback(c echo.Context) error {
 			return echo.NewHTTPError(http.StatusBadRequest, errMsg)
 		}
 		nsName := util.NormalizeByHyphen("", *event.Repo.Owner.Name)
-		refs := strings.Split(*event.Ref, "/")
-		branch := refs[len(refs)-1]
-		svcLabels := k8s.GetLabels(*event.Repo.Name, branch)
-		svcs, err := k8s.GetServices(nsName, svcLabels)
-		if err != nil || len(svcs) == 0 {
+		// pushed tags are handled by create events
+		if strings.HasPrefix(*event.Ref, "refs/tags/") {
+			return c.String(http.StatusOK, "push event of tag skipped")
+		}
+		branch := strings.TrimPrefix(*event.Ref, "refs/heads/")
+		metas, err := repoServices(nsName, *event.Repo.Name)
+		if err != nil {
+			errMsg := fmt.Sprintf("failed to list services. owner:%s, repo:%s: %v", *event.Repo.Owner.Name, *event.Repo.Name, err)
+			logger.Error(errMsg)
+			return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+		}
+		// services build their branch, and branches of their branch triggers
+		building := []*models.Metadata{}
+		for _, meta := range metas {
+			if meta.Builds(branch) {
+				building = append(building, meta)
+			}
+		}
+		if len(building) == 0 {
 			return echo.NewHTTPError(http.StatusNotFound, "service not found. owner:%s, repo:%s, branch:%s", *event.Repo.Owner.Name, *event.Repo.Name, branch)
 		}
+		if event.Deleted != nil && *event.Deleted {
+			return c.String(http.StatusOK, "push event of deleted branch skipped")
+		}
 
-		return buildbotClient.Proxy(c.Request().Method, c.Request().Header, body)
+		pusher := "push"
+		if event.Pusher != nil && event.Pusher.Name != nil {
+			pusher = *event.Pusher.Name
+		}
+
+		err = buildServices(nsName, building, *event.After, pusher,
+			func(meta *models.Metadata) bool {
+				return meta.Triggered(models.TRIGGER_BRANCH, branch)
+			},
+			func(builder models.Builder, meta *models.Metadata) error {
+				return builder.Push(nsName, meta, *event.Ref, *event.After, c.Request().Header, body, onBuildResult)
+			})
+		if err != nil {
+			logger.Error(err.Error())
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		return c.String(http.StatusOK, "push event received")
 
 	case "status":
 		var event githubClient.StatusEvent
@@ -56,6 +128,11 @@ func PostGithubCallback(c echo.Context) error {
 			return echo.NewHTTPError(http.StatusBadRequest, errMsg)
 		}
 
+		// job builds are reported through the build callback
+		if *event.Context == models.CITE_JOB_GITHUB_CONTEXT {
+			return c.String(http.StatusOK, "job build status skipped")
+		}
+
 		if *event.Context != models.CITE_BUILDBOT_GITHUB_CONTEXT {
 			errMsg := fmt.Sprintf("not a cite build: %s. skipping...", *event.Context)
 			logger.Error(errMsg)
@@ -64,73 +141,60 @@ func PostGithubCallback(c echo.Context) error {
 
 		ownerName := util.NormalizeByHyphen("", *event.Repo.Owner.Login)
 		repoName := *event.Repo.Name
-		branchName := ""
+		// branches headed by the commit
+		branchNames := []string{}
 		for _, branch := range event.Branches {
 			if *branch.Commit.SHA == *event.SHA {
-				branchName = *branch.Name
-				break
+				branchNames = append(branchNames, *branch.Name)
 			}
 		}
 
-		if branchName == "" {
-			branchNames := make([]string, len(event.Branches))
-			for i, b := range event.Branches {
-				branchNames[i] = *b.Name
+		logger.Infof("received state:%s, owner:%s, repo:%s, branches:%v",
+			*event.State, ownerName, repoName, branchNames)
+
+		// the commit is built for services of its branches, and for services
+		// which requested the build, e.g. by tag triggers
+		metas, err := repoServices(ownerName, repoName)
+		if err != nil {
+			errMsg := fmt.Sprintf("failed to list services. owner:%s, repo:%s: %v", ownerName, repoName, err)
+			logger.Error(errMsg)
+			return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+		}
+		building := []*models.Metadata{}
+		for _, meta := range metas {
+			if buildsCommit(meta, branchNames, *event.SHA) {
+				building = append(building, meta)
 			}
-			errMsg := fmt.Sprintf("unknown branch:%v, owner:%s, repo:%s",
-				branchNames, ownerName, repoName)
-			logger.Info(errMsg)
-			return echo.NewHTTPError(http.StatusNotFound, errMsg)
 		}
-
-		logger.Infof("received state:%s, owner:%s, repo:%s, branch:%s",
-			*event.State, ownerName, repoName, branchName)
-
-		svcLabels := k8s.GetLabels(repoName, branchName)
-		svcs, err := k8s.GetServices(ownerName, svcLabels)
-		if err != nil || len(svcs) < 1 {
-			errMsg := fmt.Sprintf("service not found. owner:%s, repo:%s, branch:%s",
-				ownerName, repoName, branchName)
+		if len(building) == 0 {
+			errMsg := fmt.Sprintf("service not found. owner:%s, repo:%s, branches:%v",
+				ownerName, repoName, branchNames)
 			logger.Info(errMsg)
 			return echo.NewHTTPError(http.StatusNotFound, errMsg)
 		}
-		svc := svcs[0]
-		metaStr, ok := svc.Annotations[models.CITE_K8S_ANNOTATION_KEY]
-		if !ok {
-			errMsg := fmt.Sprintf("cite annotation not found. ns:%s, svc:%s",
-				svc.Namespace, svc.Name)
-			logger.Info(errMsg)
-			return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
-		}
 
-		meta, err := models.UnmarshalMetadata(metaStr)
-		if err != nil {
-			logger.Panicf("failed to unmarshal cite annotation. ns:%s, svc:%s, err:%v",
-				svc.Namespace, svc.Name, err)
+		result := models.BuildResult{
+			Builder:  models.BUILDER_BUILDBOT,
+			SHA:      *event.SHA,
+			BuildURL: *event.TargetURL,
 		}
-
 		switch *event.State {
 		case "pending":
-			msg := fmt.Sprintf(`build started: %s/%s/%s:%s
-* buildbot url: %s`, ownerName, repoName, branchName, *event.SHA, *event.TargetURL)
-			noti.SendWithFallback(meta.Notification, meta.Watchcenter, msg)
-			return c.String(http.StatusOK, "status/pending event received")
+			result.State = models.BUILD_STATE_PENDING
 		case "success":
 			imageName, err := buildbotClient.GetImageName(*event.Description)
 			if err != nil {
 				logger.Info(err.Error())
 				return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 			}
-
-			msg := fmt.Sprintf("build success. image name: %s", imageName)
-			noti.SendWithFallback(meta.Notification, meta.Watchcenter, msg)
-
-			if meta.AutoDeploy {
-				deployer := goroutines.NewDeployer()
-				go deployer.Deploy(meta, *event.SHA, imageName, -1)
+			if err := docker.CheckRegistry(imageName); err != nil {
+				errMsg := fmt.Sprintf("rejected build of %s/%s:%s: %v", ownerName, repoName, *event.SHA, err)
+				logger.Warning(errMsg)
+				return echo.NewHTTPError(http.StatusBadRequest, errMsg)
 			}
 
-			return c.String(http.StatusOK, "status/success event received")
+			result.State = models.BUILD_STATE_SUCCESS
+			result.Image = imageName
 		case "error", "failure":
 			logURL, err := buildbotClient.GetLogURL(*event.Description)
 			if err != nil {
@@ -144,18 +208,52 @@ func PostGithubCallback(c echo.Context) error {
 				return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 			}
 
-			msg := fmt.Sprintf(`build failed.
-* buildbot url: %s
-* lastlog
-%s`, *event.TargetURL, logContent)
-			noti.SendWithFallback(meta.Notification, meta.Watchcenter, msg)
-			return c.String(http.StatusOK, "status/failure event received")
+			result.State = models.BUILD_STATE_FAILURE
+			result.LogURL = logURL
+			result.Log = logContent
 		default:
 			errMsg := fmt.Sprintf("unknown status: %v", event.State)
 			logger.Warning(errMsg)
 			return echo.NewHTTPError(http.StatusNotImplemented, errMsg)
 		}
 
+		for _, meta := range building {
+			result.Namespace = meta.Namespace
+			result.Service = meta.Service
+			onBuildResult(meta, result)
+			if result.State == models.BUILD_STATE_PENDING {
+				go buildbotClient.StreamBuildLog(meta.Namespace, meta.Service, *event.SHA, *event.TargetURL)
+			}
+		}
+		return c.String(http.StatusOK, fmt.Sprintf("status/%s event received", *event.State))
+
+	case "create":
+		var event githubClient.CreateEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			errMsg := fmt.Sprintf("error while unmarshalling event:%v, %v", body, err)
+			logger.Error(errMsg)
+			return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+		}
+		if event.RefType == nil || *event.RefType != "tag" {
+			return c.String(http.StatusOK, "create event of non-tag skipped")
+		}
+		return handleTagEvent(c, models.TRIGGER_TAG, *event.Repo.Owner.Login, *event.Repo.Name, *event.Ref, event.Sender)
+
+	case "release":
+		var event githubClient.ReleaseEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			errMsg := fmt.Sprintf("error while unmarshalling event:%v, %v", body, err)
+			logger.Error(errMsg)
+			return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+		}
+		if event.Action == nil || *event.Action != "published" {
+			return c.String(http.StatusOK, "release event skipped")
+		}
+		return handleTagEvent(c, models.TRIGGER_RELEASE, *event.Repo.Owner.Login, *event.Repo.Name, *event.Release.TagName, event.Sender)
+
+	case "pull_request":
+		return handlePullRequestEvent(c, body)
+
 	case "deployment":
 		var event githubClient.Event
 		if err := json.Unmarshal(body, &event); err != nil {
@@ -204,3 +302,47 @@ func clearJSONRepoOrgField(body io.Reader) []byte {
 	b, _ := json.MarshalIndent(o, "", "  ")
 	return b
 }
+
+// buildsCommit reports whether the status of the commit on branches belongs
+// to the service.
+func buildsCommit(meta *models.Metadata, branchNames []string, sha string) bool {
+	for _, branchName := range branchNames {
+		if meta.Builds(branchName) {
+			return true
+		}
+	}
+	records, err := k8s.GetBuildRecords(meta.Namespace, meta.Service)
+	if err != nil {
+		logger.Errorf("failed to get builds of %s/%s: %v", meta.Namespace, meta.Service, err)
+		return false
+	}
+	for _, record := range records {
+		if record.SHA == sha && !record.Finished() {
+			return true
+		}
+	}
+	return false
+}
+
+// handleTagEvent deploys the tag to services triggered by it.
+func handleTagEvent(c echo.Context, triggerType, owner, repo, tag string, sender *githubClient.User) error {
+	sha, err := commonGitHub.GetTagSHA(owner, repo, tag)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to get commit of tag %s on %s/%s: %v", tag, owner, repo, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	requestedBy := triggerType
+	if sender != nil && sender.Login != nil {
+		requestedBy = *sender.Login
+	}
+
+	triggered, err := deployTriggered(util.NormalizeByHyphen("", owner), repo, triggerType, tag, sha, requestedBy)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to deploy %s %s of %s/%s: %v", triggerType, tag, owner, repo, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	logger.Infof("%s %s of %s/%s triggered services: %v", triggerType, tag, owner, repo, triggered)
+	return c.String(http.StatusOK, fmt.Sprintf("%s event received. triggered services: %v", triggerType, triggered))
+}
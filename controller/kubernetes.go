@@ -44,26 +44,28 @@ func GetNewService(c echo.Context) error {
 		form.AutoDeploy = true
 		form.ProbePath = "/"
 		form.Replicas = 2
+		form.DeployStrategy = models.DeployStrategy{
+			Type:         models.DEPLOY_STRATEGY_BLUE_GREEN,
+			MaxSurge:     1,
+			CanaryWeight: 10,
+		}
+		form.Backend = models.Conf.Kubernetes.Backend
+		form.Builder = form.BuilderName()
+		form.Autoscaling = models.Autoscaling{
+			MinReplicas: 2,
+			MaxReplicas: 4,
+			TargetCPU:   80,
+		}
 		form.Environment = fmt.Sprintf(`## this is comment
 ## usage : KEY=VALUE
 CITE_VERSION=%s`, models.Conf.Cite.Version)
-		form.Notification = []models.Notification{}
-		if len(models.Conf.Notification.Watchcenter.API) > 0 {
-			form.Notification = append(form.Notification, models.Notification{
-				Driver: "watchcenter",
-			})
-		}
-		if len(models.Conf.Notification.Slack.ClientID) > 0 && len(models.Conf.Notification.Slack.ClientSecret) > 0 {
-			form.Notification = append(form.Notification, models.Notification{
-				Driver: "slack",
-			})
-		}
 	}
+	form.Notification = fillNotifications(form.Notification)
 
 	if form.GithubOrg != "" && form.GithubRepo != "" && form.GitBranch != "" {
 		nsName := util.NormalizeByHyphen("", form.GithubOrg)
 		svcLabels := k8s.GetLabels(form.GithubRepo, form.GitBranch)
-		svcs, err := k8s.GetServices(nsName, svcLabels)
+		svcs, err := k8s.GetBranchServices(nsName, svcLabels)
 		if err != nil {
 			errMsg := fmt.Sprintf("failed to query services: %v", err)
 			logger.Error(errMsg)
@@ -109,7 +111,7 @@ func PostNewService(c echo.Context) error {
 			})
 	}
 
-	if err := c.Bind(form); err != nil {
+	if err := bindMetadata(c, form); err != nil {
 		errMsg := fmt.Sprintf("error while parsing form %v, %v", form, err)
 		return onError(errMsg)
 	}
@@ -137,6 +139,76 @@ func PostNewService(c echo.Context) error {
 		return onError(errMsg)
 	}
 
+	// validate volumes
+	if err := form.ValidateVolumes(); err != nil {
+		errMsg := fmt.Sprintf("invalid volumes: %v", err)
+		return onError(errMsg)
+	}
+
+	// validate deploy strategy
+	if err := form.DeployStrategy.Validate(form.Replicas); err != nil {
+		errMsg := fmt.Sprintf("invalid deploy strategy: %v", err)
+		return onError(errMsg)
+	}
+
+	// validate deploy triggers
+	if err := form.ValidateTriggers(); err != nil {
+		errMsg := fmt.Sprintf("invalid deploy trigger: %v", err)
+		return onError(errMsg)
+	}
+
+	// validate deploy protection
+	if err := form.ValidateProtection(); err != nil {
+		errMsg := fmt.Sprintf("invalid deploy protection: %v", err)
+		return onError(errMsg)
+	}
+
+	// validate autoscaling
+	if err := form.Autoscaling.Validate(form.Replicas); err != nil {
+		errMsg := fmt.Sprintf("invalid autoscaling: %v", err)
+		return onError(errMsg)
+	}
+
+	// validate notifications
+	if err := form.ValidateNotification(); err != nil {
+		errMsg := fmt.Sprintf("invalid notification: %v", err)
+		return onError(errMsg)
+	}
+
+	// validate secret env
+	if err := form.ValidateSecretEnv(); err != nil {
+		errMsg := fmt.Sprintf("invalid secret env: %v", err)
+		return onError(errMsg)
+	}
+
+	// validate probe
+	if err := form.ValidateProbe(); err != nil {
+		errMsg := fmt.Sprintf("invalid probe: %v", err)
+		return onError(errMsg)
+	}
+
+	// validate resources
+	if err := form.Resources.Validate(); err != nil {
+		errMsg := fmt.Sprintf("invalid resources: %v", err)
+		return onError(errMsg)
+	}
+
+	// validate backend
+	if len(form.Backend) == 0 {
+		form.Backend = models.Conf.Kubernetes.Backend
+	}
+	if err := form.ValidateBackend(); err != nil {
+		errMsg := fmt.Sprintf("invalid backend: %v", err)
+		return onError(errMsg)
+	}
+
+	// validate builder
+	form.Builder = form.BuilderName()
+	if err := form.ValidateBuilder(); err != nil {
+		errMsg := fmt.Sprintf("invalid builder: %v", err)
+		return onError(errMsg)
+	}
+
 	// calculate ports (TODO: make better ports UI)
 	httpPorts, err := util.TCPPortsToList(form.HTTPPort)
 	if err != nil {
@@ -164,6 +236,17 @@ func PostNewService(c echo.Context) error {
 		return onError(errMsg)
 	}
 
+	// new services deploy the repo, so pushing to it is required
+	perm, err := repoPermission(c, form.GithubOrg, form.GithubRepo)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to get permission on %s/%s: %v", form.GithubOrg, form.GithubRepo, err)
+		return onError(errMsg)
+	}
+	if perm < models.PERMISSION_PUSH {
+		errMsg := fmt.Sprintf("push permission on %s/%s required", form.GithubOrg, form.GithubRepo)
+		return onError(errMsg)
+	}
+
 	nsName := util.NormalizeByHyphen("", form.GithubOrg)
 	// check if service already exist
 	if _, _, err := k8s.GetService(nsName, form.Service); err == nil {
@@ -225,6 +308,16 @@ func PostNewService(c echo.Context) error {
 		return onError(errMsg)
 	}
 
+	// upsert secret env before metadata records its keys
+	if err := k8s.UpsertSecretEnv(nsName, form); err != nil {
+		errMsg := fmt.Sprintf("failed to save secret env: %v", err)
+		return onError(errMsg)
+	}
+	if err := k8s.UpsertNotificationTokens(nsName, form); err != nil {
+		errMsg := fmt.Sprintf("failed to save notification tokens: %v", err)
+		return onError(errMsg)
+	}
+
 	// upsert k8s service
 	svcLabels := k8s.GetLabels(form.GithubRepo, form.GitBranch)
 	svcSelector := make(map[string]string)
@@ -305,37 +398,91 @@ func PutScale(c echo.Context) error {
 
 	logger.Info(fmt.Sprintf("scale request. ns:%s, svc:%s, rc:%s, replicas:%d", nsName, svcName, rcName, replicas))
 
-	_, err = k8s.ScaleReplicationController(nsName, rcName, replicas)
+	if err := scaleService(nsName, svcName, rcName, replicas); err != nil {
+		return err
+	}
+	return c.Redirect(http.StatusFound, c.Request().Referer())
+}
+
+// ownsLabels reports whether objects of the labels belong to the service.
+// services of branches do not own objects of previews, which have the same
+// labels and the pull request.
+func ownsLabels(meta *models.Metadata, objLabels map[string]string) bool {
+	for k, v := range k8s.GetServiceLabels(meta) {
+		if objLabels[k] != v {
+			return false
+		}
+	}
+	if _, ok := objLabels[models.PREVIEW_LABEL]; ok && meta.PullRequest <= 0 {
+		return false
+	}
+	return true
+}
+
+// scaleService scales the deployment of the service, or the given RC. the RC
+// selected by the service is scaled if rcName is empty.
+func scaleService(nsName, svcName, rcName string, replicas int) error {
+	svc, meta, err := k8s.GetService(nsName, svcName)
 	if err != nil {
-		errMsg := fmt.Sprintf("failed to scale k8s replication controller %s/%s: %v", nsName, rcName, err)
+		errMsg := fmt.Sprintf("error while getting service from kubernetes %s/%s: %v", nsName, svcName, err)
 		logger.Error(errMsg)
 		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
 	}
 
-	svc, meta, err := k8s.GetService(nsName, svcName)
+	if !meta.Autoscaling.InBounds(replicas) {
+		errMsg := fmt.Sprintf("replicas %d out of autoscaling bounds %d-%d",
+			replicas, meta.Autoscaling.MinReplicas, meta.Autoscaling.MaxReplicas)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+
+	if !meta.UseDeployment() && len(rcName) == 0 {
+		rcs, err := k8s.GetReplicationControllers(nsName, svc.Spec.Selector)
+		if err != nil || len(rcs) < 1 {
+			errMsg := fmt.Sprintf("failed to get kubernetes replication controller %s/%v: %v", nsName, svc.Spec.Selector, err)
+			logger.Error(errMsg)
+			return echo.NewHTTPError(http.StatusNotFound, errMsg)
+		}
+		rcName = rcs[0].Name
+	} else if !meta.UseDeployment() {
+		// routes authorize the service, so the rc must be of the service
+		rc, err := k8s.GetReplicationController(nsName, rcName)
+		if err != nil {
+			errMsg := fmt.Sprintf("failed to get kubernetes replication controller %s/%s: %v", nsName, rcName, err)
+			logger.Error(errMsg)
+			return echo.NewHTTPError(http.StatusNotFound, errMsg)
+		}
+		if !ownsLabels(meta, rc.Labels) {
+			errMsg := fmt.Sprintf("replication controller %s/%s is not of service %s", nsName, rcName, svcName)
+			logger.Warning(errMsg)
+			return echo.NewHTTPError(http.StatusForbidden, errMsg)
+		}
+	}
+
+	if meta.UseDeployment() {
+		_, err = k8s.ScaleDeployment(nsName, svcName, replicas)
+	} else {
+		_, err = k8s.ScaleReplicationController(nsName, rcName, replicas)
+	}
 	if err != nil {
-		errMsg := fmt.Sprintf("error while getting service from kubernetes %s/%s: %v", nsName, svcName, err)
+		errMsg := fmt.Sprintf("failed to scale %s/%s: %v", nsName, rcName, err)
 		logger.Error(errMsg)
 		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
 	}
 	meta.Replicas = replicas
 	svc.Annotations[models.CITE_K8S_ANNOTATION_KEY] = meta.Marshal()
-	svc, err = k8s.UpdateService(nsName, svc)
-	if err != nil {
+	if _, err := k8s.UpdateService(nsName, svc); err != nil {
 		errMsg := fmt.Sprintf("failed to update service metadata %s/%s: %v", nsName, svcName, err)
 		logger.Error(errMsg)
 		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
 	}
-
-	return c.Redirect(http.StatusFound, c.Request().Referer())
+	return nil
 }
 
 func GetNamespaces(c echo.Context) error {
-	nss, err := k8s.GetAllNamespaces()
+	nss, _, err := userNamespaces(c)
 	if err != nil {
-		errMsg := fmt.Sprintf("error while getting all namespaces: %v", err)
-		logger.Error(errMsg)
-		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+		return err
 	}
 
 	return c.Render(http.StatusOK, "namespaces",
@@ -355,10 +502,17 @@ func GetNamespace(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
 	}
 
+	readable := []k8sApi.Service{}
+	for _, svc := range svcs {
+		if _, ok := readableService(c, svc); ok {
+			readable = append(readable, svc)
+		}
+	}
+
 	return c.Render(http.StatusOK, "services",
 		map[string]interface{}{
 			"nsName": nsName,
-			"svcs":   svcs,
+			"svcs":   readable,
 		})
 }
 
@@ -377,7 +531,7 @@ func GetService(c echo.Context) error {
 
 	rcSelector := make(map[string]string)
 	for k, v := range svc.Spec.Selector {
-		if k != "sha" && k != "deploy_id" && k != "loadbalancer" {
+		if k != "sha" && k != "deploy_id" && k != "loadbalancer" && k != "rollout" && k != "canary" {
 			rcSelector[k] = strings.TrimSpace(strings.ToLower(v))
 		}
 	}
@@ -392,14 +546,29 @@ func GetService(c echo.Context) error {
 
 	var (
 		activeRC    k8sApi.ReplicationController
+		canaryRC    k8sApi.ReplicationController
 		inactiveRCs []k8sApi.ReplicationController
 	)
 
+	canary, err := models.GetCanary(svc)
+	if err != nil {
+		logger.Warning(err)
+	}
+
 	deployID, _ := svc.Spec.Selector["deploy_id"]
+	canaryID := ""
+	if canary != nil {
+		deployID = canary.Stable["deploy_id"]
+		canaryID = canary.Canary["deploy_id"]
+	}
 	for _, rc := range rcs {
-		if di, ok := rc.Labels["deploy_id"]; ok && di == deployID {
+		di, ok := rc.Labels["deploy_id"]
+		switch {
+		case ok && di == deployID:
 			activeRC = rc
-		} else {
+		case ok && canaryID != "" && di == canaryID:
+			canaryRC = rc
+		default:
 			inactiveRCs = append(inactiveRCs, rc)
 		}
 	}
@@ -457,12 +626,53 @@ func GetService(c echo.Context) error {
 	data["branches"] = branches
 	data["commits"] = commits
 	data["deployments"] = deployments
+	if builds, err := k8s.GetBuildRecords(nsName, svcName); err != nil {
+		logger.Warning(err)
+	} else {
+		if len(builds) > 4 {
+			builds = builds[:4]
+		}
+		data["builds"] = builds
+	}
+	if meta.Protection.Enable {
+		if approvals, err := k8s.GetApprovals(nsName, svcName); err != nil {
+			logger.Warning(err)
+		} else {
+			pending := []models.Approval{}
+			for _, approval := range approvals {
+				if approval.State == models.APPROVAL_STATE_PENDING && !approval.Expired() {
+					pending = append(pending, approval)
+				}
+			}
+			data["approvals"] = pending
+		}
+	}
 	data["sha"] = svc.Spec.Selector["sha"]
 
 	data["svc"] = svc
 	if activeRC.Name != "" {
 		data["rc"] = activeRC
 	}
+	if meta.Autoscaling.Enable {
+		if hpa, err := k8s.GetHPA(nsName, svcName); err == nil {
+			data["hpa"] = hpa
+		}
+	}
+	if meta.UseDeployment() {
+		if deployment, err := k8s.GetDeployment(nsName, svcName); err == nil {
+			revisions, err := k8s.GetDeploymentRevisions(deployment)
+			if err != nil {
+				logger.Warning(err)
+			}
+			data["deployment"] = deployment
+			data["revision"] = deployment.Annotations[models.DEPLOYMENT_REVISION_ANNOTATION_KEY]
+			data["revisions"] = revisions
+		}
+	}
+	if canary != nil && canaryRC.Name != "" {
+		data["canary"] = canary
+		data["canaryRC"] = canaryRC
+	}
 	data["inactiveRCs"] = inactiveRCs
 
 	return c.Render(http.StatusOK, "service", data)
@@ -548,6 +758,47 @@ func GetGitHubDeployments(c echo.Context) error {
 		})
 }
 
+func GetDeployHistory(c echo.Context) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+
+	_, meta, err := k8s.GetService(nsName, svcName)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting service from kubernetes %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+
+	records, err := k8s.GetDeployRecords(nsName, svcName)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting deploy history %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+
+	return c.Render(http.StatusOK, "history",
+		map[string]interface{}{
+			"nsName":     nsName,
+			"svcName":    svcName,
+			"githubOrg":  meta.GithubOrg,
+			"githubRepo": meta.GithubRepo,
+			"records":    records,
+		})
+}
+
+func GetDeployHistoryJSON(c echo.Context) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+
+	records, err := k8s.GetDeployRecords(nsName, svcName)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting deploy history %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	return c.JSON(http.StatusOK, records)
+}
+
 func GetServiceSettings(c echo.Context) error {
 	nsName := c.Param("namespace")
 	svcName := c.Param("service")
@@ -563,6 +814,7 @@ func GetServiceSettings(c echo.Context) error {
 	form = meta
 	form.Namespace = nsName
 	form.Service = svcName
+	form.Notification = fillNotifications(form.Notification)
 
 	return c.Render(http.StatusOK, "settings",
 		map[string]interface{}{
@@ -590,18 +842,44 @@ func PostServiceSettings(c echo.Context) error {
 			})
 	}
 
-	if err := c.Bind(form); err != nil {
+	if err := bindMetadata(c, form); err != nil {
 		errMsg := fmt.Sprintf("error while parsing form %v, %v", form, err)
 		return onError(errMsg)
 	}
 
+	if err := checkProtectionChange(c, nsName, svcName, form); err != nil {
+		return onError(err.Error())
+	}
+	if err := updateServiceSettings(nsName, svcName, form); err != nil {
+		return onError(err.Error())
+	}
+	_, token := currentUser(c)
+	upsertHook(token, form)
+
+	return c.Redirect(http.StatusFound,
+		fmt.Sprintf("/namespaces/%s/services/%s", nsName, svcName))
+}
+
+// upsertHook updates github hooks of the service, so that hooks created
+// before webhook secrets get signed. hooks need admin permission on the repo,
+// so failures are only logged.
+func upsertHook(token string, meta *models.Metadata) {
+	githubClient := models.NewGitHub(token)
+	if err := githubClient.UpsertHook(meta.GithubOrg, meta.GithubRepo); err != nil {
+		logger.Warningf("failed to update github hook on %s/%s: %v", meta.GithubOrg, meta.GithubRepo, err)
+	}
+}
+
+// updateServiceSettings validates the posted metadata and applies it to the
+// service.
+func updateServiceSettings(nsName, svcName string, form *models.Metadata) error {
+	var err error
 	// TODO: remove this. backward compatibility : fill watchcenter
 	for _, noti := range form.Notification {
 		if noti.Driver == "watchcenter" && len(noti.Endpoint) > 0 {
 			form.Watchcenter, err = strconv.Atoi(noti.Endpoint)
 			if err != nil {
-				errMsg := fmt.Sprintf("error while decoding watchcenter id %v: %v", noti.Endpoint, err)
-				return onError(errMsg)
+				return fmt.Errorf("error while decoding watchcenter id %v: %v", noti.Endpoint, err)
 			}
 		}
 	}
@@ -614,25 +892,88 @@ func PostServiceSettings(c echo.Context) error {
 
 	// validate number of replicas
 	if form.Replicas <= 0 || form.Replicas > models.Conf.Kubernetes.MaxPods {
-		errMsg := fmt.Sprintf("invalid replicas : %d", form.Replicas)
-		return onError(errMsg)
+		return fmt.Errorf("invalid replicas : %d", form.Replicas)
+	}
+
+	// validate volumes
+	if err := form.ValidateVolumes(); err != nil {
+		return fmt.Errorf("invalid volumes: %v", err)
+	}
+
+	// validate deploy strategy
+	if err := form.DeployStrategy.Validate(form.Replicas); err != nil {
+		return fmt.Errorf("invalid deploy strategy: %v", err)
+	}
+
+	// validate deploy triggers
+	if err := form.ValidateTriggers(); err != nil {
+		return fmt.Errorf("invalid deploy trigger: %v", err)
 	}
 
-	svc, _, err := k8s.GetService(nsName, svcName)
+	// validate deploy protection
+	if err := form.ValidateProtection(); err != nil {
+		return fmt.Errorf("invalid deploy protection: %v", err)
+	}
+
+	// validate autoscaling
+	if err := form.Autoscaling.Validate(form.Replicas); err != nil {
+		return fmt.Errorf("invalid autoscaling: %v", err)
+	}
+
+	// validate notifications
+	if err := form.ValidateNotification(); err != nil {
+		return fmt.Errorf("invalid notification: %v", err)
+	}
+
+	// validate secret env
+	if err := form.ValidateSecretEnv(); err != nil {
+		return fmt.Errorf("invalid secret env: %v", err)
+	}
+
+	// validate probe
+	if err := form.ValidateProbe(); err != nil {
+		return fmt.Errorf("invalid probe: %v", err)
+	}
+
+	// validate resources
+	if err := form.Resources.Validate(); err != nil {
+		return fmt.Errorf("invalid resources: %v", err)
+	}
+
+	svc, meta, err := k8s.GetService(nsName, svcName)
 	if err != nil {
-		errMsg := fmt.Sprintf("error while getting service from kubernetes %s/%s: %v", nsName, svcName, err)
-		return onError(errMsg)
+		return fmt.Errorf("error while getting service from kubernetes %s/%s: %v", nsName, svcName, err)
+	}
+
+	// backend and the pull request of previews are fixed once the service is
+	// created
+	form.Backend = meta.Backend
+	form.PullRequest = meta.PullRequest
+	if err := form.ValidateBackend(); err != nil {
+		return fmt.Errorf("invalid backend: %v", err)
+	}
+
+	// validate builder
+	form.Builder = form.BuilderName()
+	if err := form.ValidateBuilder(); err != nil {
+		return fmt.Errorf("invalid builder: %v", err)
+	}
+
+	// upsert secret env before metadata records its keys
+	if err := k8s.UpsertSecretEnv(nsName, form); err != nil {
+		return fmt.Errorf("failed to save secret env: %v", err)
+	}
+	form.KeepLegacyTokens(meta)
+	if err := k8s.UpsertNotificationTokens(nsName, form); err != nil {
+		return fmt.Errorf("failed to save notification tokens: %v", err)
 	}
 
 	svc.Annotations[models.CITE_K8S_ANNOTATION_KEY] = form.Marshal()
 
 	if _, err := k8s.UpdateService(nsName, svc); err != nil {
-		errMsg := fmt.Sprintf("error while update service metadata %v, %v", form.Marshal(), err)
-		return onError(errMsg)
+		return fmt.Errorf("error while update service metadata %v, %v", form.Marshal(), err)
 	}
-
-	return c.Redirect(http.StatusFound,
-		fmt.Sprintf("/namespaces/%s/services/%s", nsName, svcName))
+	return nil
 }
 
 func PostBuild(c echo.Context) error {
@@ -647,14 +988,20 @@ func PostBuild(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
 	}
 
-	err = buildbotClient.Build(nsName, meta.GithubRepo, meta.GitBranch, sha)
-	if err != nil {
-		errMsg := fmt.Sprintf("failed to send event to buildbot: %v", err)
+	session := getSession(c)
+	err = queueBuild(nsName, meta, sha, session.Values["userLogin"].(string), false, func(builder models.Builder) error {
+		return builder.Build(nsName, meta, "", sha, onBuildResult)
+	})
+	if err == models.ErrBuildInProgress {
+		session.AddFlash(fmt.Sprintf("%s is already building", sha))
+		saveSession(session, c)
+		return c.Redirect(http.StatusFound, c.Request().Referer())
+	} else if err != nil {
+		errMsg := fmt.Sprintf("failed to start build: %v", err)
 		logger.Error(errMsg)
 		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
 	}
 
-	session := getSession(c)
 	session.AddFlash("build started")
 	saveSession(session, c)
 	return c.Redirect(http.StatusFound, c.Request().Referer())
@@ -667,37 +1014,66 @@ func PostDeploy(c echo.Context) error {
 	svcName := c.Param("service")
 	sha := c.Param("sha")
 	imageName := c.QueryParam("imageName")
+
+	deployID, approval, err := startDeploy(token, session.Values["userLogin"].(string), nsName, svcName, sha, imageName, "")
+	if err != nil {
+		return err
+	}
+	if approval != nil {
+		session.AddFlash(fmt.Sprintf("deploy of %s is waiting for %d approvals", sha, approval.Required))
+		saveSession(session, c)
+		return c.Redirect(http.StatusFound, fmt.Sprintf("/namespaces/%s/services/%s", nsName, svcName))
+	}
+	return c.Redirect(http.StatusFound, es.GetDeployLogURL(deployID, "now-1h", "now"))
+}
+
+// startDeploy creates a github deployment of the service and deploys the
+// image in background. the github deployment is in the environment, the
+// default of github if empty. the github deployment id is returned. deploys
+// of protected services wait for approvers instead, and the pending approval
+// is returned.
+func startDeploy(token, userLogin, nsName, svcName, sha, imageName, environment string) (int, *models.Approval, error) {
 	if imageName == "" {
 		errMsg := "imageName required."
 		logger.Error(errMsg)
-		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+		return 0, nil, echo.NewHTTPError(http.StatusInternalServerError, errMsg)
 	}
 
 	_, meta, err := k8s.GetService(nsName, svcName)
 	if err != nil {
 		errMsg := fmt.Sprintf("error while getting service from kubernetes %s/%s: %v", nsName, svcName, err)
 		logger.Error(errMsg)
-		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+		return 0, nil, echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+
+	// the github deployment is created by the deployer once approved
+	if meta.Protection.Enable {
+		approval := models.NewApproval(meta, models.APPROVAL_ACTION_DEPLOY, sha, imageName, 0, userLogin, userLogin)
+		if err := requestApproval(nsName, meta, approval); err != nil {
+			logger.Error(err)
+			return 0, nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		return 0, &approval, nil
 	}
 
 	githubClient := models.NewGitHub(token)
 	deployID, err := githubClient.CreateDeployment(
 		meta.GithubOrg,
 		meta.GithubRepo,
-		meta.GitBranch,
+		sha,
+		environment,
 		"manual deploy")
 	if err != nil {
 		errMsg := fmt.Sprintf(
-			"error while create deployments to github:%s/%s/%s: %v",
-			meta.GithubOrg, meta.GithubRepo, meta.GitBranch, err)
+			"error while create deployments to github:%s/%s:%s: %v",
+			meta.GithubOrg, meta.GithubRepo, sha, err)
 		logger.Error(errMsg)
-		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+		return 0, nil, echo.NewHTTPError(http.StatusInternalServerError, errMsg)
 	}
 
 	deployer := goroutines.NewDeployer()
-	go deployer.Deploy(meta, sha, imageName, deployID)
-
-	return c.Redirect(http.StatusFound, es.GetDeployLogURL(deployID, "now-1h", "now"))
+	go deployer.Deploy(meta, sha, imageName, deployID, userLogin)
+	return deployID, nil, nil
 }
 
 func PutActivate(c echo.Context) error {
@@ -706,12 +1082,73 @@ func PutActivate(c echo.Context) error {
 	sha := c.Param("sha")
 	deployID := c.Param("deploy_id")
 
-	svc, _, err := k8s.GetService(nsName, svcName)
+	login, _ := currentUser(c)
+	approval, err := holdActivate(login, nsName, svcName, sha, deployID)
+	if err != nil {
+		return err
+	} else if approval != nil {
+		session := getSession(c)
+		session.AddFlash(fmt.Sprintf("activation of %s is waiting for %d approvals", sha, approval.Required))
+		saveSession(session, c)
+		return c.Redirect(http.StatusFound, c.Request().Referer())
+	}
+
+	if err := activateService(nsName, svcName, sha, deployID); err != nil {
+		return err
+	}
+	return c.Redirect(http.StatusFound, c.Request().Referer())
+}
+
+// activateService routes the service to the pods deployed with the given sha
+// and deploy_id.
+func activateService(nsName, svcName, sha, deployID string) error {
+	svc, meta, err := k8s.GetService(nsName, svcName)
 	if err != nil {
 		errMsg := fmt.Sprintf("failed to get kubernetes service %s/%s: %v", nsName, svcName, err)
 		logger.Error(errMsg)
 		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
 	}
+
+	if meta.UseDeployment() {
+		if err := activateRevision(nsName, svcName, sha, deployID); err != nil {
+			errMsg := fmt.Sprintf("failed to activate revision %s/%s:%s: %v", nsName, svcName, sha, err)
+			logger.Error(errMsg)
+			return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+		}
+		if err := k8s.SyncHPA(nsName, meta, models.DeploymentRef(svcName)); err != nil {
+			logger.Error("failed to update autoscaler:", err)
+		}
+		return nil
+	}
+
+	// RCs replaced by rolling update are left with zero replicas
+	rcSelector := k8s.GetServiceLabels(meta)
+	rcSelector["sha"] = sha
+	rcSelector["deploy_id"] = deployID
+	rcs, err := k8s.GetReplicationControllers(nsName, rcSelector)
+	if err != nil || len(rcs) < 1 {
+		errMsg := fmt.Sprintf("failed to get kubernetes replication controller %s/%v: %v", nsName, rcSelector, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	if rc := rcs[0]; rc.Spec.Replicas == 0 {
+		scaledRC, err := k8s.ScaleReplicationController(nsName, rc.Name, meta.Replicas)
+		if err != nil {
+			errMsg := fmt.Sprintf("failed to scale k8s replication controller %s/%s: %v", nsName, rc.Name, err)
+			logger.Error(errMsg)
+			return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+		}
+		if err := k8s.WaitForRC(scaledRC, nil); err != nil {
+			errMsg := fmt.Sprintf("error while waiting for pods of %s/%s: %v", nsName, rc.Name, err)
+			logger.Error(errMsg)
+			return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+		}
+	}
+
+	canary, _ := models.GetCanary(svc)
+	delete(svc.Spec.Selector, "rollout")
+	delete(svc.Spec.Selector, "canary")
+	models.SetCanary(svc, nil)
 	svc.Spec.Selector["sha"] = sha
 	svc.Spec.Selector["deploy_id"] = deployID
 	svc, err = k8s.UpdateService(nsName, svc)
@@ -720,6 +1157,126 @@ func PutActivate(c echo.Context) error {
 		logger.Error(errMsg)
 		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
 	}
+	if canary != nil {
+		finishCanary(nsName, meta, canary, deployID)
+	}
+
+	// move autoscaler to the activated RC
+	if err := k8s.SyncHPA(nsName, meta, models.ReplicationControllerRef(rcs[0].Name)); err != nil {
+		logger.Error("failed to update autoscaler:", err)
+	}
+	return nil
+}
+
+// finishCanary finishes the deploy of the canary cleared by activation of
+// deployID. the canary succeeds if it is the activated deploy, and fails
+// otherwise.
+func finishCanary(nsName string, meta *models.Metadata, canary *models.Canary, deployID string) {
+	canaryID, err := strconv.Atoi(canary.Canary["deploy_id"])
+	if err != nil {
+		return
+	}
+	result, state, errMsg := models.DEPLOY_RESULT_SUCCESS, "success", ""
+	if canary.Canary["deploy_id"] != deployID {
+		result, state = models.DEPLOY_RESULT_FAILURE, "failure"
+		errMsg = fmt.Sprintf("canary replaced by activation of deploy %s", deployID)
+	}
+	commonGitHub.CreateDeploymentStatus(meta.GithubOrg, meta.GithubRepo, canaryID, state)
+	if err := k8s.FinishDeployRecord(nsName, meta.Service, canaryID, result, errMsg); err != nil {
+		logger.Errorf("failed to update deploy record %d of %s/%s: %v", canaryID, nsName, meta.Service, err)
+	}
+}
+
+// activateRevision rolls the service deployment back to the revision which
+// deployed the given sha and deploy_id.
+func activateRevision(nsName, svcName, sha, deployID string) error {
+	deployment, err := k8s.GetDeployment(nsName, svcName)
+	if err != nil {
+		return err
+	}
+	rss, err := k8s.GetDeploymentRevisions(deployment)
+	if err != nil {
+		return err
+	}
+	for _, rs := range rss {
+		if rs.Spec.Template.Labels["sha"] == sha && rs.Spec.Template.Labels["deploy_id"] == deployID {
+			return k8s.RollbackDeployment(nsName, svcName, models.DeploymentRevision(rs))
+		}
+	}
+	return fmt.Errorf("revision not found")
+}
+
+func PutPromoteCanary(c echo.Context) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+
+	svc, meta, err := k8s.GetService(nsName, svcName)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to get kubernetes service %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	if canary, err := models.GetCanary(svc); err != nil || canary == nil {
+		errMsg := fmt.Sprintf("no canary in progress on %s/%s", nsName, svcName)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
 
+	deployer := goroutines.NewDeployer()
+	go deployer.PromoteCanary(nsName, meta)
+
+	session := getSession(c)
+	session.AddFlash("canary promotion started")
+	saveSession(session, c)
 	return c.Redirect(http.StatusFound, c.Request().Referer())
 }
+
+func PutAbortCanary(c echo.Context) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+
+	_, meta, err := k8s.GetService(nsName, svcName)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to get kubernetes service %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+
+	deployer := goroutines.NewDeployer()
+	if err := deployer.AbortCanary(nsName, meta); err != nil {
+		errMsg := fmt.Sprintf("failed to abort canary on %s/%s: %v", nsName, svcName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+
+	return c.Redirect(http.StatusFound, c.Request().Referer())
+}
+
+// fillNotifications appends a disabled notification for each available
+// driver which is not configured yet, so that it can be selected on the form.
+func fillNotifications(nms []models.Notification) []models.Notification {
+	drivers := []string{}
+	if len(models.Conf.Notification.Watchcenter.API) > 0 {
+		drivers = append(drivers, "watchcenter")
+	}
+	if len(models.Conf.Notification.Slack.ClientID) > 0 && len(models.Conf.Notification.Slack.ClientSecret) > 0 {
+		drivers = append(drivers, "slack")
+	}
+	drivers = append(drivers, "webhook")
+	if len(models.Conf.Notification.SMTP.Host) > 0 {
+		drivers = append(drivers, "smtp")
+	}
+
+	configured := make(map[string]bool)
+	for _, nm := range nms {
+		configured[nm.Driver] = true
+	}
+	for _, driver := range drivers {
+		if !configured[driver] {
+			nms = append(nms, models.Notification{
+				Driver: driver,
+			})
+		}
+	}
+	return nms
+}
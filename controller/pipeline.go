@@ -0,0 +1,408 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kakao/cite/models"
+	"github.com/labstack/echo"
+)
+
+func GetPipelines(c echo.Context) error {
+	nsName := c.Param("namespace")
+
+	pipelines, err := k8s.GetPipelines(nsName)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to get pipelines of %s: %v", nsName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	return c.Render(http.StatusOK, "pipelines",
+		map[string]interface{}{
+			"nsName":    nsName,
+			"pipelines": pipelines,
+		})
+}
+
+func PostPipeline(c echo.Context) error {
+	nsName := c.Param("namespace")
+
+	params, err := c.FormParams()
+	if err != nil {
+		errMsg := fmt.Sprintf("error while parsing form: %v", err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+	var pipeline models.Pipeline
+	if err := formDecoder.Decode(&pipeline, params); err != nil {
+		errMsg := fmt.Sprintf("error while parsing form %v: %v", params, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+	if err := savePipeline(c, nsName, pipeline); err != nil {
+		session := getSession(c)
+		session.AddFlash(err.Error())
+		saveSession(session, c)
+		return c.Redirect(http.StatusFound, fmt.Sprintf("/namespaces/%s/pipelines", nsName))
+	}
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/namespaces/%s/pipelines/%s", nsName, pipeline.Name))
+}
+
+func GetPipeline(c echo.Context) error {
+	nsName := c.Param("namespace")
+
+	view, err := pipelineView(nsName, c.Param("pipeline"))
+	if err != nil {
+		return err
+	}
+	return c.Render(http.StatusOK, "pipeline",
+		map[string]interface{}{
+			"nsName":    nsName,
+			"pipeline":  view,
+			"lastStage": len(view.Stages) - 1,
+		})
+}
+
+func DeletePipeline(c echo.Context) error {
+	nsName := c.Param("namespace")
+	name := c.Param("pipeline")
+
+	if err := k8s.DeletePipeline(nsName, name); err != nil {
+		errMsg := fmt.Sprintf("failed to delete pipeline %s/%s: %v", nsName, name, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/namespaces/%s/pipelines", nsName))
+}
+
+func PostPromotion(c echo.Context) error {
+	nsName := c.Param("namespace")
+	name := c.Param("pipeline")
+
+	if _, err := requestPromotion(c, nsName, name, c.Param("stage")); err != nil {
+		return err
+	}
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/namespaces/%s/pipelines/%s", nsName, name))
+}
+
+func PutApprovePromotion(c echo.Context) error {
+	return putDecidePromotion(c, true)
+}
+
+func PutRejectPromotion(c echo.Context) error {
+	return putDecidePromotion(c, false)
+}
+
+func putDecidePromotion(c echo.Context, approve bool) error {
+	nsName := c.Param("namespace")
+	name := c.Param("pipeline")
+
+	if _, err := decidePromotion(c, nsName, name, c.Param("id"), approve); err != nil {
+		return err
+	}
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/namespaces/%s/pipelines/%s", nsName, name))
+}
+
+// stageService returns the metadata of the service of the stage. services of
+// stages on other clusters are got from the cite of the cluster, without
+// secrets.
+func stageService(stage models.PipelineStage) (*models.Metadata, error) {
+	if len(stage.Cluster) == 0 {
+		_, meta, err := k8s.GetService(stage.Namespace, stage.Service)
+		return meta, err
+	}
+	cluster, err := models.GetCluster(stage.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	svc, err := cluster.GetService(stage.Namespace, stage.Service)
+	if err != nil {
+		return nil, err
+	}
+	if svc.Metadata == nil {
+		return nil, fmt.Errorf("no metadata of %s", stage.Target())
+	}
+	return svc.Metadata, nil
+}
+
+// stageActiveDeploy returns the active deploy of the service of the stage.
+func stageActiveDeploy(stage models.PipelineStage) (*models.DeployRecord, error) {
+	if len(stage.Cluster) == 0 {
+		return k8s.GetActiveDeployRecord(stage.Namespace, stage.Service)
+	}
+	cluster, err := models.GetCluster(stage.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	return cluster.GetActiveDeployRecord(stage.Namespace, stage.Service)
+}
+
+// savePipeline validates the pipeline and creates or updates it. stages must
+// be existing services of the same repo, which the user can read, since the
+// pipeline shows deploys of its stages to readers of its namespace.
+func savePipeline(c echo.Context, nsName string, pipeline models.Pipeline) error {
+	if err := pipeline.Validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid pipeline: %v", err))
+	}
+	var repo string
+	for _, stage := range pipeline.Stages {
+		meta, err := stageService(stage)
+		if err != nil {
+			errMsg := fmt.Sprintf("invalid stage %s: failed to get service %s: %v", stage.Name, stage.Target(), err)
+			return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+		}
+		stageRepo := meta.GithubOrg + "/" + meta.GithubRepo
+		if len(repo) > 0 && stageRepo != repo {
+			errMsg := fmt.Sprintf("invalid stage %s: service of %s, but earlier stages are of %s", stage.Name, stageRepo, repo)
+			return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+		}
+		repo = stageRepo
+
+		perm, err := repoPermission(c, meta.GithubOrg, meta.GithubRepo)
+		if err != nil {
+			errMsg := fmt.Sprintf("failed to get permission on %s: %v", stageRepo, err)
+			logger.Error(errMsg)
+			return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+		}
+		if perm < models.PERMISSION_READ {
+			login, _ := currentUser(c)
+			errMsg := fmt.Sprintf("read permission on %s required to add stage %s, but %s has %s permission",
+				stageRepo, stage.Name, login, perm)
+			logger.Warning(errMsg)
+			return echo.NewHTTPError(http.StatusForbidden, errMsg)
+		}
+	}
+
+	login, _ := currentUser(c)
+	pipeline.CreatedBy = login
+	pipeline.CreatedAt = time.Now()
+	if err := k8s.UpsertPipeline(nsName, pipeline); err != nil {
+		errMsg := fmt.Sprintf("failed to save pipeline %s/%s: %v", nsName, pipeline.Name, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	return nil
+}
+
+// pipelineView returns the pipeline with deploys active on its stages.
+func pipelineView(nsName, name string) (*models.APIPipeline, error) {
+	pipeline, err := k8s.GetPipeline(nsName, name)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to get pipeline %s/%s: %v", nsName, name, err)
+		logger.Error(errMsg)
+		return nil, echo.NewHTTPError(http.StatusNotFound, errMsg)
+	}
+	view := &models.APIPipeline{
+		Pipeline: pipeline,
+		Active:   make([]*models.DeployRecord, len(pipeline.Stages)),
+	}
+	for i, stage := range pipeline.Stages {
+		// stages never deployed have no active deploy
+		view.Active[i], _ = stageActiveDeploy(stage)
+	}
+	view.Promotions, err = k8s.GetPromotions(nsName, name)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to get promotions of %s/%s: %v", nsName, name, err)
+		logger.Error(errMsg)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	return view, nil
+}
+
+// stageIndex returns the index of the stage named stageName.
+func stageIndex(pipeline models.Pipeline, stageName string) (int, error) {
+	for i, stage := range pipeline.Stages {
+		if stage.Name == stageName {
+			return i, nil
+		}
+	}
+	errMsg := fmt.Sprintf("stage %s not found in pipeline %s", stageName, pipeline.Name)
+	return -1, echo.NewHTTPError(http.StatusNotFound, errMsg)
+}
+
+// requirePush requires push permission on the service of the stage. stages
+// may be in other namespaces or clusters than the pipeline, so routes can't
+// authorize them.
+func requirePush(c echo.Context, stage models.PipelineStage) (*models.Metadata, error) {
+	meta, err := stageService(stage)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to get service %s of stage %s: %v", stage.Target(), stage.Name, err)
+		logger.Error(errMsg)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	perm, err := repoPermission(c, meta.GithubOrg, meta.GithubRepo)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to get permission on %s/%s: %v", meta.GithubOrg, meta.GithubRepo, err)
+		logger.Error(errMsg)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	if perm < models.PERMISSION_PUSH {
+		login, _ := currentUser(c)
+		errMsg := fmt.Sprintf("push permission on %s/%s required to promote to stage %s, but %s has %s permission",
+			meta.GithubOrg, meta.GithubRepo, stage.Name, login, perm)
+		logger.Warning(errMsg)
+		return nil, echo.NewHTTPError(http.StatusForbidden, errMsg)
+	}
+	return meta, nil
+}
+
+// requestPromotion promotes the active deploy of the stage to the next stage.
+// promotions to stages requiring approval wait for it, and others are
+// deployed right away.
+func requestPromotion(c echo.Context, nsName, name, from string) (models.Promotion, error) {
+	pipeline, err := k8s.GetPipeline(nsName, name)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to get pipeline %s/%s: %v", nsName, name, err)
+		logger.Error(errMsg)
+		return models.Promotion{}, echo.NewHTTPError(http.StatusNotFound, errMsg)
+	}
+	fromIdx, err := stageIndex(pipeline, from)
+	if err != nil {
+		return models.Promotion{}, err
+	}
+	if fromIdx == len(pipeline.Stages)-1 {
+		errMsg := fmt.Sprintf("stage %s is the last stage of pipeline %s", from, name)
+		return models.Promotion{}, echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+	if _, err := requirePush(c, pipeline.Stages[fromIdx+1]); err != nil {
+		return models.Promotion{}, err
+	}
+
+	fromStage := pipeline.Stages[fromIdx]
+	record, err := stageActiveDeploy(fromStage)
+	if err != nil {
+		errMsg := fmt.Sprintf("nothing to promote from stage %s: %v", from, err)
+		logger.Info(errMsg)
+		return models.Promotion{}, echo.NewHTTPError(http.StatusConflict, errMsg)
+	}
+
+	login, _ := currentUser(c)
+	promotion := models.NewPromotion(fromIdx, *record, login)
+	if err := k8s.AddPromotion(nsName, name, promotion); err != nil {
+		errMsg := fmt.Sprintf("failed to record promotion of %s/%s: %v", nsName, name, err)
+		logger.Error(errMsg)
+		return models.Promotion{}, echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	logger.Infof("%s requested promotion %s of %s/%s:%s from %s", login, promotion.ID, nsName, name, promotion.SHA, from)
+
+	if pipeline.Stages[promotion.To].Approval {
+		return promotion, nil
+	}
+	return decidePromotion(c, nsName, name, promotion.ID, true)
+}
+
+// decidePromotion approves the waiting promotion and deploys it, or rejects
+// it. deciders need push permission on the service of the target stage, and
+// requesters cannot approve their own promotions to stages requiring
+// approval.
+func decidePromotion(c echo.Context, nsName, name, id string, approve bool) (models.Promotion, error) {
+	pipeline, err := k8s.GetPipeline(nsName, name)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to get pipeline %s/%s: %v", nsName, name, err)
+		logger.Error(errMsg)
+		return models.Promotion{}, echo.NewHTTPError(http.StatusNotFound, errMsg)
+	}
+	promotion, err := k8s.GetPromotion(nsName, name, id)
+	if err != nil {
+		logger.Error(err.Error())
+		return models.Promotion{}, echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	// stages may have been removed since the request
+	if promotion.To >= len(pipeline.Stages) {
+		errMsg := fmt.Sprintf("stage of promotion %s no longer exists in pipeline %s", id, name)
+		return models.Promotion{}, echo.NewHTTPError(http.StatusConflict, errMsg)
+	}
+	toStage := pipeline.Stages[promotion.To]
+	meta, err := requirePush(c, toStage)
+	if err != nil {
+		return models.Promotion{}, err
+	}
+
+	login, token := currentUser(c)
+	if approve && toStage.Approval && promotion.RequestedBy == login {
+		logger.Warningf("%s tried to approve own promotion %s of %s/%s", login, id, nsName, name)
+		return models.Promotion{}, echo.NewHTTPError(http.StatusForbidden, models.ErrPromotionSelf.Error())
+	}
+
+	state := models.PROMOTION_STATE_REJECTED
+	if approve {
+		state = models.PROMOTION_STATE_PROMOTED
+	}
+	promotion, err = k8s.DecidePromotion(nsName, name, id, state, login)
+	if err == models.ErrPromotionDecided {
+		return models.Promotion{}, echo.NewHTTPError(http.StatusConflict, err.Error())
+	} else if err != nil {
+		errMsg := fmt.Sprintf("failed to decide promotion %s of %s/%s: %v", id, nsName, name, err)
+		logger.Error(errMsg)
+		return models.Promotion{}, echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	logger.Infof("%s %s promotion %s of %s/%s", login, state, id, nsName, name)
+	if !approve {
+		return promotion, nil
+	}
+
+	fromStage := pipeline.Stages[promotion.From]
+	if len(toStage.Cluster) > 0 {
+		return promoteToCluster(nsName, name, promotion, toStage)
+	}
+
+	// the github deployment is of the promoted commit in the environment of
+	// the stage
+	deployID, err := models.NewGitHub(token).CreateDeployment(
+		meta.GithubOrg,
+		meta.GithubRepo,
+		promotion.SHA,
+		toStage.GitHubEnvironment(),
+		fmt.Sprintf("promoted from %s", fromStage.Name))
+	if err != nil {
+		errMsg := fmt.Sprintf("error while create deployments to github:%s/%s@%s: %v",
+			meta.GithubOrg, meta.GithubRepo, promotion.SHA, err)
+		logger.Error(errMsg)
+		if finishErr := k8s.FinishPromotion(nsName, name, id, 0, errMsg); finishErr != nil {
+			logger.Errorf("failed to record promotion %s of %s/%s: %v", id, nsName, name, finishErr)
+		}
+		return models.Promotion{}, echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	if err := k8s.FinishPromotion(nsName, name, id, deployID, ""); err != nil {
+		logger.Errorf("failed to record promotion %s of %s/%s: %v", id, nsName, name, err)
+	}
+	promotion.DeployID = deployID
+
+	deployService(toStage.Namespace, meta, promotion.SHA, promotion.Image, deployID,
+		fmt.Sprintf("%s (promoted from %s)", login, fromStage.Name), login)
+	return promotion, nil
+}
+
+// promoteToCluster deploys the promotion through the cite of the cluster of
+// the stage, which creates the github deployment in the environment of the
+// stage. protected services on the cluster wait for its approvers, and the
+// promotion has no deploy id then.
+func promoteToCluster(nsName, name string, promotion models.Promotion, toStage models.PipelineStage) (models.Promotion, error) {
+	cluster, err := models.GetCluster(toStage.Cluster)
+	var res *models.APIDeployResponse
+	if err == nil {
+		res, err = cluster.Deploy(toStage.Namespace, toStage.Service, models.APIDeployRequest{
+			SHA:         promotion.SHA,
+			Image:       promotion.Image,
+			Environment: toStage.GitHubEnvironment(),
+		})
+	}
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to deploy %s@%s: %v", toStage.Target(), promotion.SHA, err)
+		logger.Error(errMsg)
+		if finishErr := k8s.FinishPromotion(nsName, name, promotion.ID, 0, errMsg); finishErr != nil {
+			logger.Errorf("failed to record promotion %s of %s/%s: %v", promotion.ID, nsName, name, finishErr)
+		}
+		return models.Promotion{}, echo.NewHTTPError(http.StatusBadGateway, errMsg)
+	}
+	if res.Approval != nil {
+		logger.Infof("promotion %s of %s/%s is waiting for %d approvals on %s",
+			promotion.ID, nsName, name, res.Approval.Required, toStage.Target())
+	}
+	if err := k8s.FinishPromotion(nsName, name, promotion.ID, res.DeployID, ""); err != nil {
+		logger.Errorf("failed to record promotion %s of %s/%s: %v", promotion.ID, nsName, name, err)
+	}
+	promotion.DeployID = res.DeployID
+	return promotion, nil
+}
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kakao/cite/models"
+	"github.com/labstack/echo"
+)
+
+const (
+	POD_LOG_DEFAULT_TAIL_LINES = 100
+)
+
+func GetPodLog(c echo.Context) error {
+	nsName := c.Param("namespace")
+	podName := c.Param("pod")
+
+	pod, err := k8s.GetPod(nsName, podName)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting pod from kubernetes %s/%s: %v", nsName, podName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusNotFound, errMsg)
+	}
+
+	return c.Render(http.StatusOK, "pod_log",
+		map[string]interface{}{
+			"nsName":    nsName,
+			"pod":       pod,
+			"tailLines": POD_LOG_DEFAULT_TAIL_LINES,
+		})
+}
+
+// GetPodLogStream writes logs of the pod as plain text. followed logs are
+// flushed as they come, until the client goes away.
+func GetPodLogStream(c echo.Context) error {
+	nsName := c.Param("namespace")
+	podName := c.Param("pod")
+
+	opts, err := podLogOptions(c)
+	if err != nil {
+		errMsg := fmt.Sprintf("invalid log options of pod %s/%s: %v", nsName, podName, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, echo.MIMETextPlainCharsetUTF8)
+	res.Header().Set("Cache-Control", "no-cache")
+	res.WriteHeader(http.StatusOK)
+
+	w := &flushWriter{res}
+	if err := k8s.StreamPodLogs(c.Request().Context(), nsName, podName, opts, w); err != nil {
+		// the status is already sent, so the error goes to the log
+		logger.Errorf("failed to get logs of pod %s/%s: %v", nsName, podName, err)
+		fmt.Fprintf(w, "\nfailed to get logs: %v\n", err)
+	}
+	return nil
+}
+
+// podLogOptions reads container, follow, previous, since (e.g. 10m) and tail
+// query params.
+func podLogOptions(c echo.Context) (models.PodLogOptions, error) {
+	opts := models.PodLogOptions{
+		Container: c.QueryParam("container"),
+		Follow:    c.QueryParam("follow") == "true",
+		Previous:  c.QueryParam("previous") == "true",
+		TailLines: POD_LOG_DEFAULT_TAIL_LINES,
+	}
+	if since := c.QueryParam("since"); len(since) > 0 {
+		d, err := time.ParseDuration(since)
+		if err != nil {
+			return opts, fmt.Errorf("invalid since %s: %v", since, err)
+		}
+		opts.SinceSeconds = int64(d.Seconds())
+	}
+	if tail := c.QueryParam("tail"); len(tail) > 0 {
+		tailLines, err := strconv.ParseInt(tail, 10, 64)
+		if err != nil {
+			return opts, fmt.Errorf("invalid tail %s: %v", tail, err)
+		}
+		opts.TailLines = tailLines
+	}
+	return opts, nil
+}
+
+type flushWriter struct {
+	res *echo.Response
+}
+
+func (this *flushWriter) Write(p []byte) (int, error) {
+	n, err := this.res.Write(p)
+	this.res.Flush()
+	return n, err
+}
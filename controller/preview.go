@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	githubClient "github.com/google/go-github/github"
+	"github.com/kakao/cite/models"
+	"github.com/labstack/echo"
+)
+
+// handlePullRequestEvent manages previews of pull requests. services tracking
+// the base branch get a preview per pull request, which deploys the head
+// branch until the pull request is closed or the preview expires.
+func handlePullRequestEvent(c echo.Context, body []byte) error {
+	var event githubClient.PullRequestEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		errMsg := fmt.Sprintf("error while unmarshalling event:%v, %v", body, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+	if event.Action == nil || event.Number == nil || event.PullRequest == nil ||
+		event.PullRequest.Head == nil || event.PullRequest.Base == nil ||
+		event.Repo == nil || event.Repo.Owner == nil {
+		errMsg := "incomplete pull_request event. skipping..."
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+
+	ownerName := *event.Repo.Owner.Login
+	repoName := *event.Repo.Name
+	nsName := util.NormalizeByHyphen("", ownerName)
+	number := *event.Number
+	head := event.PullRequest.Head
+
+	switch *event.Action {
+	case "opened", "reopened", "synchronize":
+		// images of forks are not built on cite
+		if head.Repo == nil || head.Repo.FullName == nil || *head.Repo.FullName != *event.Repo.FullName {
+			return c.String(http.StatusOK, "pull request from fork skipped")
+		}
+
+		svcs, err := k8s.GetBranchServices(nsName, k8s.GetLabels(repoName, *event.PullRequest.Base.Ref))
+		if err != nil {
+			errMsg := fmt.Sprintf("failed to list services. owner:%s, repo:%s, branch:%s: %v",
+				ownerName, repoName, *event.PullRequest.Base.Ref, err)
+			logger.Error(errMsg)
+			return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+		}
+
+		requestedBy := "pull_request"
+		if event.Sender != nil && event.Sender.Login != nil {
+			requestedBy = *event.Sender.Login
+		}
+
+		var created []string
+		for _, svc := range svcs {
+			if _, ok := svc.Labels[models.PREVIEW_LABEL]; ok {
+				continue
+			}
+			base, err := models.UnmarshalMetadata(svc.Annotations[models.CITE_K8S_ANNOTATION_KEY])
+			if err != nil {
+				logger.Errorf("failed to unmarshal cite annotation. ns:%s, svc:%s, err:%v", svc.Namespace, svc.Name, err)
+				continue
+			}
+
+			meta, isNew, err := k8s.UpsertPreviewService(nsName, base, number, *head.Ref)
+			if err == models.ErrPreviewLimit {
+				logger.Infof("preview of %s/%s for #%d skipped: %v", nsName, svc.Name, number, err)
+				commentPullRequest(ownerName, repoName, number, fmt.Sprintf(
+					"preview of `%s` was not created, since namespace `%s` has %d previews already.",
+					svc.Name, nsName, models.PreviewMaxPerNamespace()))
+				continue
+			} else if err != nil {
+				errMsg := fmt.Sprintf("failed to upsert preview of %s/%s for #%d: %v", nsName, svc.Name, number, err)
+				logger.Error(errMsg)
+				return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+			}
+
+			// pushes to the head branch are built by the push event, since
+			// previews track the head branch
+			if !isNew {
+				continue
+			}
+			err = queueBuild(nsName, meta, *head.SHA, requestedBy, false, func(builder models.Builder) error {
+				return builder.Build(nsName, meta, "", *head.SHA, onBuildResult)
+			})
+			if err != nil && err != models.ErrBuildInProgress {
+				logger.Errorf("failed to build preview %s/%s:%s: %v", nsName, meta.Service, *head.SHA, err)
+			}
+			created = append(created, fmt.Sprintf("* `%s`: %s (%s/namespaces/%s/services/%s)",
+				meta.Service, models.PreviewURL(nsName, meta.Service),
+				models.Conf.Cite.Host, nsName, meta.Service))
+		}
+
+		if len(created) > 0 {
+			commentPullRequest(ownerName, repoName, number, fmt.Sprintf(
+				"previews of this pull request are deploying. they are deleted when this pull request is closed, or %v after the last push.\n\n%s",
+				models.PreviewTTL(), strings.Join(created, "\n")))
+		}
+		return c.String(http.StatusOK, fmt.Sprintf("pull_request/%s event received", *event.Action))
+
+	case "closed":
+		deleted, err := k8s.DeletePreviewServices(nsName, repoName, number)
+		if err != nil {
+			errMsg := fmt.Sprintf("failed to delete previews of %s/%s#%d: %v", ownerName, repoName, number, err)
+			logger.Error(errMsg)
+			return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+		}
+		if len(deleted) > 0 {
+			logger.Infof("deleted previews of %s/%s#%d: %v", ownerName, repoName, number, deleted)
+			commentPullRequest(ownerName, repoName, number, fmt.Sprintf(
+				"previews `%s` are deleted.", strings.Join(deleted, "`, `")))
+		}
+		return c.String(http.StatusOK, "pull_request/closed event received")
+
+	default:
+		return c.String(http.StatusOK, fmt.Sprintf("pull_request/%s event skipped", *event.Action))
+	}
+}
+
+func commentPullRequest(owner, repo string, number int, body string) {
+	if err := commonGitHub.CreateComment(owner, repo, number, body); err != nil {
+		logger.Warningf("failed to comment on %s/%s#%d: %v", owner, repo, number, err)
+	}
+}
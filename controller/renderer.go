@@ -21,11 +21,13 @@ type AceRenderer struct{}
 
 var aceFuncMap = template.FuncMap{
 	"deref":                    deref,
+	"eventKinds":               eventKinds,
 	"getDomain":                getDomain,
 	"getEndpoints":             getEndpoints,
 	"getImageName":             getImageName,
 	"getVIP":                   getVIP,
 	"getPods":                  getPods,
+	"getResourceUsage":         getResourceUsage,
 	"githubDeploymentStatuses": githubDeploymentStatuses,
 	"githubStatuses":           githubStatuses,
 	"groupByRepoName":          groupByRepoName,
@@ -121,6 +123,15 @@ func getPods(nsName string, podSelector map[string]string) []k8sApi.Pod {
 	return pods
 }
 
+func getResourceUsage(nsName string) *models.ResourceUsage {
+	usage, err := k8s.GetResourceUsage(nsName)
+	if err != nil {
+		logger.Error(err)
+		return &models.ResourceUsage{}
+	}
+	return usage
+}
+
 func githubStatuses(owner, repo, ref string) []github.RepoStatus {
 	allStatuses, err := commonGitHub.ListStatuses(owner, repo, ref)
 	if err != nil {
@@ -184,6 +195,10 @@ func listWatchcenterGroups(username, email string) []models.WatchCenterGroup {
 	return gs
 }
 
+func eventKinds() []models.EventKind {
+	return models.EventKinds
+}
+
 func normalizeByHyphen(in string) string {
 	return util.NormalizeByHyphen("", in)
 }
@@ -197,6 +212,8 @@ func printTime(in interface{}) string {
 			return ""
 		}
 		return fmt.Sprintf("%s (%s)", t.Local().Format(time.RFC1123), humanize.Time(t.Time))
+	case time.Time:
+		return fmt.Sprintf("%s (%s)", t.Local().Format(time.RFC1123), humanize.Time(t))
 	case *time.Time:
 		if t == nil {
 			return ""
@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kakao/cite/models"
+	"github.com/labstack/echo"
+)
+
+const (
+	STREAM_KEEPALIVE_INTERVAL = 30 * time.Second
+)
+
+// GetServiceStream streams deploy progress and build logs of the service as
+// server-sent events until the client goes away.
+func GetServiceStream(c echo.Context) error {
+	nsName := c.Param("namespace")
+	svcName := c.Param("service")
+
+	events, unsubscribe := models.NewLogStream().Subscribe(nsName, svcName)
+	defer unsubscribe()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	keepalive := time.NewTicker(STREAM_KEEPALIVE_INTERVAL)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-keepalive.C:
+			fmt.Fprint(res, ": keepalive\n\n")
+			res.Flush()
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				logger.Errorf("failed to marshal stream event of %s/%s: %v", nsName, svcName, err)
+				continue
+			}
+			fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event.Type, data)
+			res.Flush()
+		}
+	}
+}
@@ -439,7 +439,7 @@ func GetKubernetesService(c echo.Context) error {
 	}
 
 	svcLabels := k8s.GetLabels(repo, branch)
-	svcs, err := k8s.GetServices(owner, svcLabels)
+	svcs, err := k8s.GetBranchServices(owner, svcLabels)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/kakao/cite/models"
+)
+
+// repoServices returns metadata of services of the repo, including previews.
+// services with broken metadata are skipped.
+func repoServices(nsName, repoName string) ([]*models.Metadata, error) {
+	svcs, err := k8s.GetServices(nsName, map[string]string{
+		"service": util.NormalizeByHyphen("", repoName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	metas := []*models.Metadata{}
+	for _, svc := range svcs {
+		meta, err := models.UnmarshalMetadata(svc.Annotations[models.CITE_K8S_ANNOTATION_KEY])
+		if err != nil {
+			logger.Errorf("failed to unmarshal cite annotation. ns:%s, svc:%s, err:%v", svc.Namespace, svc.Name, err)
+			continue
+		}
+		meta.Namespace = svc.Namespace
+		meta.Service = svc.Name
+		if err := k8s.LoadNotificationTokens(nsName, meta); err != nil {
+			logger.Warningf("failed to load notification tokens. ns:%s, svc:%s: %v", nsName, svc.Name, err)
+		}
+		metas = append(metas, meta)
+	}
+	return metas, nil
+}
+
+// buildServices builds the commit for each service, and marks builds of
+// services for which deploy returns true to be deployed on success. buildbot
+// reports results by status events of the commit, which reach every service
+// building it, so buildbot builds once for all services while other builders
+// build per service.
+func buildServices(nsName string, metas []*models.Metadata, sha, requestedBy string, deploy func(meta *models.Metadata) bool, start func(builder models.Builder, meta *models.Metadata) error) error {
+	built := make(map[string]bool)
+	for _, meta := range metas {
+		meta := meta
+		builderName := meta.BuilderName()
+		err := queueBuild(nsName, meta, sha, requestedBy, deploy(meta), func(builder models.Builder) error {
+			if builderName == models.BUILDER_BUILDBOT && built[builderName] {
+				return nil
+			}
+			return start(builder, meta)
+		})
+		if err == models.ErrBuildInProgress {
+			logger.Infof("%s/%s:%s is already building. skipping...", nsName, meta.Service, sha)
+		} else if err != nil {
+			return fmt.Errorf("failed to build %s/%s:%s: %v", nsName, meta.Service, sha, err)
+		}
+		built[builderName] = true
+	}
+	return nil
+}
+
+// deployTriggered deploys the commit to services of the repo triggered by the
+// tag or the release, and returns names of triggered services. commits
+// already built for a service are deployed right away, others are built and
+// deployed on success.
+func deployTriggered(nsName, repoName, triggerType, ref, sha, requestedBy string) ([]string, error) {
+	metas, err := repoServices(nsName, repoName)
+	if err != nil {
+		return nil, err
+	}
+
+	triggered := []string{}
+	toBuild := []*models.Metadata{}
+	for _, meta := range metas {
+		if !meta.Triggered(triggerType, ref) {
+			continue
+		}
+		triggered = append(triggered, meta.Service)
+
+		records, err := k8s.GetBuildRecords(nsName, meta.Service)
+		if err != nil {
+			return triggered, fmt.Errorf("failed to get builds of %s/%s: %v", nsName, meta.Service, err)
+		}
+		imageName := ""
+		for _, record := range records {
+			if record.SHA == sha && record.State == models.BUILD_STATE_SUCCESS && len(record.Image) > 0 {
+				imageName = record.Image
+				break
+			}
+		}
+		if len(imageName) == 0 {
+			toBuild = append(toBuild, meta)
+			continue
+		}
+		logger.Infof("deploy %s/%s:%s triggered by %s %s", nsName, meta.Service, sha, triggerType, ref)
+		deployService(nsName, meta, sha, imageName, -1, fmt.Sprintf("%s %s", triggerType, ref), requestedBy)
+	}
+
+	err = buildServices(nsName, toBuild, sha, requestedBy,
+		func(meta *models.Metadata) bool { return true },
+		func(builder models.Builder, meta *models.Metadata) error {
+			return builder.Build(nsName, meta, "refs/tags/"+ref, sha, onBuildResult)
+		})
+	return triggered, err
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -80,11 +81,66 @@ func GetDeployLog(c echo.Context) error {
 }
 
 func GetProfileSettings(c echo.Context) error {
+	return renderProfileSettings(c, "")
+}
+
+// renderProfileSettings renders api tokens of the user. the plain token is
+// given only right after it is created, as only its hash is kept.
+func renderProfileSettings(c echo.Context, newToken string) error {
 	session := getSession(c)
 	token := session.Values["token"].(string)
+	userLogin := session.Values["userLogin"].(string)
+
+	apiTokens, err := k8s.ListAPITokens(userLogin)
+	if err != nil {
+		errMsg := fmt.Sprintf("error while getting api tokens of %s: %v", userLogin, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
 
 	return c.Render(http.StatusOK, "profile_settings",
 		map[string]interface{}{
-			"token": token,
+			"token":     token,
+			"citeHost":  models.Conf.Cite.Host,
+			"apiTokens": apiTokens,
+			"newToken":  newToken,
 		})
 }
+
+func PostAPIToken(c echo.Context) error {
+	session := getSession(c)
+	token := session.Values["token"].(string)
+	userLogin := session.Values["userLogin"].(string)
+
+	name := strings.TrimSpace(c.FormValue("name"))
+	if len(name) == 0 {
+		session.AddFlash("token name required")
+		saveSession(session, c)
+		return c.Redirect(http.StatusFound, "/settings/profile")
+	}
+
+	newToken, _, err := k8s.CreateAPIToken(userLogin, token, name)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to create api token of %s: %v", userLogin, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusInternalServerError, errMsg)
+	}
+	logger.Infof("api token %s created by %s", name, userLogin)
+	return renderProfileSettings(c, newToken)
+}
+
+func DeleteAPIToken(c echo.Context) error {
+	session := getSession(c)
+	userLogin := session.Values["userLogin"].(string)
+	id := c.Param("id")
+
+	if err := k8s.DeleteAPIToken(userLogin, id); err != nil {
+		errMsg := fmt.Sprintf("failed to delete api token %s of %s: %v", id, userLogin, err)
+		logger.Error(errMsg)
+		return echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+
+	session.AddFlash("api token deleted")
+	saveSession(session, c)
+	return c.Redirect(http.StatusFound, "/settings/profile")
+}
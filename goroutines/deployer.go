@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/kakao/cite/models"
+	gologging "github.com/op/go-logging"
+	k8sApi "k8s.io/kubernetes/pkg/api"
 )
 
 type Deployer struct {
@@ -17,6 +20,20 @@ type Deployer struct {
 	wc     *models.WatchCenter
 }
 
+// deployment holds the state of a single Deploy call.
+type deployment struct {
+	meta           *models.Metadata
+	nsName         string
+	sha            string
+	imageName      string
+	deployID       int
+	rcGenerateName string
+	baseLabels     map[string]string
+	rcLabels       map[string]string
+	rcSelector     map[string]string
+	fluentLogger   *gologging.Logger
+}
+
 var (
 	deployerOnce sync.Once
 	deployerInst *Deployer
@@ -36,31 +53,88 @@ func NewDeployer() *Deployer {
 	return deployerInst
 }
 
-func (this *Deployer) Deploy(meta *models.Metadata, sha string, imageName string, deployID int) {
+// Deploy deploys the image to the service. triggeredBy is recorded in the
+// deploy history.
+func (this *Deployer) Deploy(meta *models.Metadata, sha string, imageName string, deployID int, triggeredBy string) {
+	this.deploy(meta, sha, imageName, deployID, triggeredBy, nil)
+}
+
+// Resume deploys the approved deploy of a protected service, recording the
+// approval in the deploy history.
+func (this *Deployer) Resume(meta *models.Metadata, approval models.Approval) {
+	this.deploy(meta, approval.SHA, approval.Image, approval.DeployID, approval.TriggeredBy, []models.Approval{approval})
+}
+
+func (this *Deployer) deploy(meta *models.Metadata, sha string, imageName string, deployID int, triggeredBy string, approvals []models.Approval) {
 	var (
 		msg string
 		err error
 	)
 
 	if deployID <= 0 {
-		deployID, err = this.github.CreateDeployment(meta.GithubOrg, meta.GithubRepo, meta.GitBranch, "cite CI")
+		deployID, err = this.github.CreateDeployment(meta.GithubOrg, meta.GithubRepo, sha, "", "cite CI")
 		if err != nil {
 			errMsg := fmt.Sprintf(
-				"error while create deployments to github:%s/%s/%s: %v",
-				meta.GithubOrg, meta.GithubRepo, meta.GitBranch, err)
+				"error while create deployments to github:%s/%s:%s: %v",
+				meta.GithubOrg, meta.GithubRepo, sha, err)
 			logger.Error(errMsg)
-			this.noti.SendWithFallback(meta.Notification, meta.Watchcenter, msg)
+			this.noti.SendMessageWithFallback(meta.Notification, meta.Watchcenter, models.Message{
+				Event:   models.EVENT_DEPLOY_FAILURE,
+				Text:    errMsg,
+				State:   models.EVENT_DEPLOY_FAILURE.State(),
+				Service: meta.Service,
+				SHA:     sha,
+				Image:   imageName,
+			})
 			return
 		}
 	}
 
 	this.github.CreateDeploymentStatus(meta.GithubOrg, meta.GithubRepo, deployID, "pending")
+	nsName := this.util.NormalizeByHyphen("", meta.GithubOrg)
+	err = this.k8s.AddDeployRecord(nsName, meta.Service, models.DeployRecord{
+		DeployID:    deployID,
+		SHA:         sha,
+		Image:       imageName,
+		TriggeredBy: triggeredBy,
+		Strategy:    meta.DeployStrategy.Type,
+		StartedAt:   time.Now(),
+		Result:      models.DEPLOY_RESULT_RUNNING,
+		Approvals:   approvals,
+	})
+	if err != nil {
+		logger.Error("failed to add deploy record:", err)
+	}
+
+	notify := func(event models.EventKind, text string) {
+		this.noti.SendMessageWithFallback(meta.Notification, meta.Watchcenter, models.Message{
+			Event:     event,
+			Text:      text,
+			State:     event.State(),
+			Namespace: nsName,
+			Service:   meta.Service,
+			SHA:       sha,
+			DeployID:  deployID,
+			Image:     imageName,
+			CommitURL: this.github.GetCommitURL(meta.GithubOrg, meta.GithubRepo, sha),
+		})
+	}
+
 	deploymentState := "failure"
+	result := models.DEPLOY_RESULT_FAILURE
 	defer func() {
+		// canary deploys stay pending until promoted or aborted
+		if deploymentState == "pending" {
+			return
+		}
 		this.github.CreateDeploymentStatus(meta.GithubOrg, meta.GithubRepo, deployID, deploymentState)
+		errMsg := ""
+		if result != models.DEPLOY_RESULT_SUCCESS {
+			errMsg = msg
+		}
+		this.finishDeployRecord(nsName, meta.Service, deployID, result, errMsg)
 	}()
 
-	nsName := this.util.NormalizeByHyphen("", meta.GithubOrg)
 	fluentLogger := models.NewFluentLogger("cite-core.deploy", map[string]interface{}{
 		"namespace": nsName,
 		"service":   meta.Service,
@@ -71,8 +145,14 @@ func (this *Deployer) Deploy(meta *models.Metadata, sha string, imageName string
 
 	if len(imageName) == 0 {
 		msg = fmt.Sprintf(`invalid docker image name: "%s"`, imageName)
-		this.noti.SendWithFallback(meta.Notification, meta.Watchcenter, msg)
-		fluentLogger.Info(msg)
+		notify(models.EVENT_DEPLOY_FAILURE, msg)
+		fluentLogger.Error(msg)
+		return
+	}
+	if err := this.docker.CheckRegistry(imageName); err != nil {
+		msg = fmt.Sprintf("invalid docker image: %v", err)
+		notify(models.EVENT_DEPLOY_FAILURE, msg)
+		fluentLogger.Error(msg)
 		return
 	}
 	logger.Debug("imageName:", imageName)
@@ -82,10 +162,10 @@ func (this *Deployer) Deploy(meta *models.Metadata, sha string, imageName string
 		meta.GithubRepo,
 		meta.GitBranch,
 		sha)
-	this.noti.SendWithFallback(meta.Notification, meta.Watchcenter, msg)
+	notify(models.EVENT_DEPLOY_STARTED, msg)
 	fluentLogger.Info(msg)
 
-	baseLabels := this.k8s.GetLabels(meta.GithubRepo, meta.GitBranch)
+	baseLabels := this.k8s.GetServiceLabels(meta)
 
 	rcGenerateName := this.util.Normalize("-", meta.GithubRepo, meta.GitBranch, sha)
 	if len(rcGenerateName) >= 58 {
@@ -106,24 +186,56 @@ func (this *Deployer) Deploy(meta *models.Metadata, sha string, imageName string
 	rcSelector["sha"] = sha
 	rcSelector["deploy_id"] = strconv.Itoa(deployID)
 
-	// upsert k8s replication controller
-	if err := this.k8s.UpsertReplicationController(
-		nsName,
-		rcGenerateName,
-		imageName,
-		rcLabels,
-		rcSelector,
-		meta.EnvironmentMap(),
-		meta.Replicas,
-		meta.ContainerPorts,
-		meta.ProbePath,
-		deployID,
-		fluentLogger,
-	); err != nil {
+	d := &deployment{
+		meta:           meta,
+		nsName:         nsName,
+		sha:            sha,
+		imageName:      imageName,
+		deployID:       deployID,
+		rcGenerateName: rcGenerateName,
+		baseLabels:     baseLabels,
+		rcLabels:       rcLabels,
+		rcSelector:     rcSelector,
+		fluentLogger:   fluentLogger,
+	}
+
+	// remember the active selector to roll back to
+	var prevSelector map[string]string
+	if svc, _, err := this.k8s.GetService(nsName, meta.Service); err == nil {
+		if _, ok := svc.Spec.Selector["deploy_id"]; ok {
+			prevSelector = make(map[string]string)
+			for k, v := range svc.Spec.Selector {
+				prevSelector[k] = v
+			}
+		}
+	}
+
+	// upsert k8s replication controller or deployment
+	var canary *models.Canary
+	if meta.UseDeployment() {
+		err = this.deployDeployment(d)
+	} else {
+		switch meta.DeployStrategy.Type {
+		case models.DEPLOY_STRATEGY_ROLLING:
+			err = this.deployRolling(d)
+		case models.DEPLOY_STRATEGY_CANARY:
+			canary, err = this.deployCanary(d)
+		default:
+			err = this.deployBlueGreen(d)
+		}
+	}
+	if err != nil {
 		logger.Error("error on upsert k8s ReplicationController :", err)
 		msg = fmt.Sprintf("deploy failed: %v", err)
-		this.noti.SendWithFallback(meta.Notification, meta.Watchcenter, msg)
+		notify(models.EVENT_DEPLOY_FAILURE, msg)
+		fluentLogger.Error(msg)
+		return
+	}
+	if canary != nil {
+		msg = fmt.Sprintf("canary started: %d%% of traffic goes to %s. promote or abort it on cite.", canary.Weight, sha)
+		notify(models.EVENT_CANARY_STARTED, msg)
 		fluentLogger.Info(msg)
+		deploymentState = "pending"
 		return
 	}
 
@@ -136,8 +248,10 @@ func (this *Deployer) Deploy(meta *models.Metadata, sha string, imageName string
 	for k, v := range baseLabels {
 		svcSelector[k] = v
 	}
-	svcSelector["sha"] = sha
-	svcSelector["deploy_id"] = strconv.Itoa(deployID)
+	if !meta.UseDeployment() {
+		svcSelector["sha"] = sha
+		svcSelector["deploy_id"] = strconv.Itoa(deployID)
+	}
 
 	// upsert k8s service
 	_, err = this.k8s.UpsertService(
@@ -151,8 +265,29 @@ func (this *Deployer) Deploy(meta *models.Metadata, sha string, imageName string
 	if err != nil {
 		logger.Error("error on upsert k8s Service :", err)
 		msg = fmt.Sprintf("deploy failed: %v", err)
-		this.noti.SendWithFallback(meta.Notification, meta.Watchcenter, msg)
-		fluentLogger.Info(msg)
+		notify(models.EVENT_DEPLOY_FAILURE, msg)
+		fluentLogger.Error(msg)
+		return
+	}
+
+	this.syncHPA(d)
+
+	// watch new pods for a while and roll back if they turn unhealthy
+	if err := this.watch(d); err != nil {
+		msg = fmt.Sprintf("deploy failed after activation: %v", err)
+		logger.Error(msg)
+		fluentLogger.Error(msg)
+		if rbErr := this.rollback(d, prevSelector); rbErr != nil {
+			msg = fmt.Sprintf("%s. rollback failed: %v", msg, rbErr)
+		} else if meta.UseDeployment() {
+			msg = fmt.Sprintf("%s. rolled back to previous revision", msg)
+			result = models.DEPLOY_RESULT_ROLLED_BACK
+		} else {
+			msg = fmt.Sprintf("%s. rolled back to %s", msg, prevSelector["sha"])
+			result = models.DEPLOY_RESULT_ROLLED_BACK
+		}
+		notify(models.EVENT_DEPLOY_FAILURE, msg)
+		fluentLogger.Error(msg)
 		return
 	}
 
@@ -166,8 +301,467 @@ func (this *Deployer) Deploy(meta *models.Metadata, sha string, imageName string
 	// msg = fmt.Sprintf(`deploy success: https://%s`, lbMeta["domain"])
 	msg = fmt.Sprintf(`deploy success`)
 	logger.Debug(msg)
-	this.noti.SendWithFallback(meta.Notification, meta.Watchcenter, msg)
+	notify(models.EVENT_DEPLOY_SUCCESS, msg)
 	fluentLogger.Info(msg)
 
 	deploymentState = "success"
+	result = models.DEPLOY_RESULT_SUCCESS
+}
+
+func (this *Deployer) upsertRC(d *deployment, replicas int) (*k8sApi.ReplicationController, error) {
+	return this.k8s.UpsertReplicationController(
+		d.nsName,
+		d.rcGenerateName,
+		d.imageName,
+		d.rcLabels,
+		d.rcSelector,
+		d.meta.EnvironmentMap(),
+		d.meta.SecretEnvKeys,
+		d.meta.Service,
+		d.meta.Volumes,
+		d.meta.Resources,
+		replicas,
+		d.meta.ContainerPorts,
+		d.meta.HTTPProbePort(),
+		d.meta.ProbePath,
+		d.meta.Probe,
+		d.deployID,
+		d.fluentLogger,
+	)
+}
+
+// deployBlueGreen starts all pods of the new RC at once.
+// the service selector is switched by Deploy afterwards.
+func (this *Deployer) deployBlueGreen(d *deployment) error {
+	_, err := this.upsertRC(d, d.meta.Replicas)
+	return err
+}
+
+// deployRolling replaces the active RC step by step. during the rollout the
+// service selects pods of both RCs by a shared "rollout" label, which is set
+// on the new RC's pod template and patched onto the running pods of the
+// active RC.
+func (this *Deployer) deployRolling(d *deployment) error {
+	strategy := d.meta.DeployStrategy
+	replicas := d.meta.Replicas
+
+	svc, prevRC, err := this.getActiveRC(d)
+	if err != nil {
+		return err
+	}
+	if prevRC == nil {
+		d.fluentLogger.Info("active replication controller not found. fall back to blue-green deploy")
+		return this.deployBlueGreen(d)
+	}
+
+	prevSelector := make(map[string]string)
+	for k, v := range svc.Spec.Selector {
+		prevSelector[k] = v
+	}
+	prevReplicas := int(prevRC.Spec.Replicas)
+
+	// keep the autoscaler from scaling the previous RC up during rollout
+	if err := this.k8s.DeleteHPA(d.nsName, d.meta.Service); err != nil {
+		return err
+	}
+
+	rolloutID := strconv.Itoa(d.deployID)
+	if err := this.k8s.LabelPods(d.nsName, prevRC.Spec.Selector, "rollout", rolloutID); err != nil {
+		return err
+	}
+	d.rcLabels["rollout"] = rolloutID
+
+	var newRC *k8sApi.ReplicationController
+	rollback := func(cause error) error {
+		d.fluentLogger.Error(fmt.Sprintf("rolling update failed. rolling back to %s: %v", prevRC.Name, cause))
+		if svc, _, err := this.k8s.GetService(d.nsName, d.meta.Service); err != nil {
+			logger.Error("failed to get service while rolling back:", err)
+		} else {
+			svc.Spec.Selector = prevSelector
+			if _, err := this.k8s.UpdateService(d.nsName, svc); err != nil {
+				logger.Error("failed to restore service selector:", err)
+			}
+		}
+		if _, err := this.k8s.ScaleReplicationController(d.nsName, prevRC.Name, prevReplicas); err != nil {
+			logger.Error("failed to restore replicas of previous RC:", err)
+		}
+		if err := this.k8s.SyncHPA(d.nsName, d.meta, models.ReplicationControllerRef(prevRC.Name)); err != nil {
+			logger.Error("failed to restore autoscaler of previous RC:", err)
+		}
+		if newRC != nil {
+			if err := this.k8s.DeleteReplicationController(d.nsName, newRC.Name); err != nil {
+				logger.Error("failed to delete new RC:", err)
+			}
+		}
+		return cause
+	}
+
+	oldReplicas := prevReplicas
+	newReplicas := 0
+	for newReplicas < replicas || oldReplicas > 0 {
+		// scale down the previous RC as long as enough pods remain available
+		down := newReplicas + oldReplicas - (replicas - strategy.MaxUnavailable)
+		if down > oldReplicas {
+			down = oldReplicas
+		}
+		if down > 0 {
+			oldReplicas -= down
+			d.fluentLogger.Info(fmt.Sprintf("scale down %s to %d", prevRC.Name, oldReplicas))
+			if _, err := this.k8s.ScaleReplicationController(d.nsName, prevRC.Name, oldReplicas); err != nil {
+				return rollback(fmt.Errorf("failed to scale down %s: %v", prevRC.Name, err))
+			}
+		}
+
+		// scale up the new RC as long as surge limit allows
+		up := replicas + strategy.MaxSurge - (newReplicas + oldReplicas)
+		if up > replicas-newReplicas {
+			up = replicas - newReplicas
+		}
+		if up > 0 {
+			newReplicas += up
+			if newRC == nil {
+				newRC, err = this.upsertRC(d, newReplicas)
+				if err != nil {
+					return rollback(err)
+				}
+
+				rolloutSelector := make(map[string]string)
+				for k, v := range d.baseLabels {
+					rolloutSelector[k] = v
+				}
+				rolloutSelector["rollout"] = rolloutID
+				svc.Spec.Selector = rolloutSelector
+				if svc, err = this.k8s.UpdateService(d.nsName, svc); err != nil {
+					return rollback(fmt.Errorf("failed to update service selector: %v", err))
+				}
+			} else {
+				d.fluentLogger.Info(fmt.Sprintf("scale up %s to %d", newRC.Name, newReplicas))
+				newRC, err = this.k8s.ScaleReplicationController(d.nsName, newRC.Name, newReplicas)
+				if err != nil {
+					return rollback(fmt.Errorf("failed to scale up %s: %v", d.rcGenerateName, err))
+				}
+				if err := this.k8s.WaitForRC(newRC, d.fluentLogger); err != nil {
+					return rollback(fmt.Errorf("error while waiting for pods: %v", err))
+				}
+			}
+		}
+
+		if down <= 0 && up <= 0 {
+			return rollback(fmt.Errorf("rolling update stalled. new:%d, old:%d", newReplicas, oldReplicas))
+		}
+	}
+
+	return nil
+}
+
+// deployDeployment rolls out a new revision of the service deployment. the
+// deployment controller replaces the pods, so the service selects them by
+// base labels only. a failed rollout is rolled back to the previous revision.
+func (this *Deployer) deployDeployment(d *deployment) error {
+	_, err := this.k8s.GetDeployment(d.nsName, d.meta.Service)
+	hasPrevRevision := err == nil
+
+	changeCause := fmt.Sprintf("deploy %s (deploy_id %d)", d.sha, d.deployID)
+	k8sDeployment, err := this.k8s.UpsertDeployment(d.nsName, d.meta, d.imageName, d.rcLabels, changeCause)
+	if err != nil {
+		return err
+	}
+
+	if err := this.k8s.WaitForDeployment(k8sDeployment, d.rcSelector, d.fluentLogger); err != nil {
+		if hasPrevRevision {
+			if rbErr := this.k8s.RollbackDeployment(d.nsName, k8sDeployment.Name, 0); rbErr != nil {
+				logger.Error("failed to roll back deployment:", rbErr)
+			}
+		}
+		return fmt.Errorf("error while waiting for pods: %v", err)
+	}
+	return nil
+}
+
+// deployCanary starts the new RC next to the active one and lets the
+// service select both of them by a shared "canary" label. the canary is
+// nil if there was no active RC and blue-green deploy was done instead.
+func (this *Deployer) deployCanary(d *deployment) (*models.Canary, error) {
+	svc, stableRC, err := this.getActiveRC(d)
+	if err != nil {
+		return nil, err
+	}
+	// services select no deploy_id during canaries, so canaries are checked
+	// before falling back
+	if canary, err := models.GetCanary(svc); err != nil || canary != nil {
+		return nil, fmt.Errorf("another canary is in progress. promote or abort it first")
+	}
+	if stableRC == nil {
+		d.fluentLogger.Info("active replication controller not found. fall back to blue-green deploy")
+		return nil, this.deployBlueGreen(d)
+	}
+
+	canaryID := strconv.Itoa(d.deployID)
+	stableReplicas := int(stableRC.Spec.Replicas)
+	canaryReplicas := models.CanaryReplicas(stableReplicas, d.meta.DeployStrategy.CanaryWeight)
+
+	// label pods recreated by the stable RC as well
+	stableRC.Spec.Template.Labels["canary"] = canaryID
+	if _, err := this.k8s.UpdateReplicationController(d.nsName, stableRC); err != nil {
+		return nil, fmt.Errorf("failed to label stable RC %s: %v", stableRC.Name, err)
+	}
+	if err := this.k8s.LabelPods(d.nsName, stableRC.Spec.Selector, "canary", canaryID); err != nil {
+		return nil, err
+	}
+	d.rcLabels["canary"] = canaryID
+
+	canaryRC, err := this.upsertRC(d, canaryReplicas)
+	if err != nil {
+		return nil, err
+	}
+
+	canary := &models.Canary{
+		Stable: svc.Spec.Selector,
+		Canary: d.rcSelector,
+		Weight: canaryReplicas * 100 / (stableReplicas + canaryReplicas),
+	}
+	canarySelector := make(map[string]string)
+	for k, v := range d.baseLabels {
+		canarySelector[k] = v
+	}
+	canarySelector["canary"] = canaryID
+
+	err = models.SetCanary(svc, canary)
+	if err == nil {
+		svc.Spec.Selector = canarySelector
+		_, err = this.k8s.UpdateService(d.nsName, svc)
+	}
+	if err != nil {
+		if deleteErr := this.k8s.DeleteReplicationController(d.nsName, canaryRC.Name); deleteErr != nil {
+			logger.Error("failed to delete canary RC:", deleteErr)
+		}
+		return nil, fmt.Errorf("failed to update service selector: %v", err)
+	}
+	return canary, nil
+}
+
+// PromoteCanary scales the canary RC up to the service replicas and points
+// the service to it. the stable RC is left as an inactive RC.
+func (this *Deployer) PromoteCanary(nsName string, meta *models.Metadata) {
+	var msg string
+	err := func() error {
+		svc, canary, canaryRC, err := this.getCanary(nsName, meta)
+		if err != nil {
+			return err
+		}
+
+		canaryRC, err = this.k8s.ScaleReplicationController(nsName, canaryRC.Name, meta.Replicas)
+		if err != nil {
+			return fmt.Errorf("failed to scale canary RC %s: %v", canaryRC.Name, err)
+		}
+		if err := this.k8s.WaitForRC(canaryRC, nil); err != nil {
+			return fmt.Errorf("error while waiting for canary pods: %v", err)
+		}
+
+		// refresh service which may have been changed while waiting for pods
+		svc, _, err = this.k8s.GetService(nsName, svc.Name)
+		if err != nil {
+			return err
+		}
+		svc.Spec.Selector = canary.Canary
+		models.SetCanary(svc, nil)
+		if _, err := this.k8s.UpdateService(nsName, svc); err != nil {
+			return fmt.Errorf("failed to update service selector: %v", err)
+		}
+		if err := this.k8s.SyncHPA(nsName, meta, models.ReplicationControllerRef(canaryRC.Name)); err != nil {
+			logger.Error("failed to move autoscaler to canary RC:", err)
+		}
+
+		if deployID, err := strconv.Atoi(canary.Canary["deploy_id"]); err == nil {
+			this.github.CreateDeploymentStatus(meta.GithubOrg, meta.GithubRepo, deployID, "success")
+			this.finishDeployRecord(nsName, meta.Service, deployID, models.DEPLOY_RESULT_SUCCESS, "")
+		}
+		msg = fmt.Sprintf("canary promoted: %s/%s/%s:%s", meta.GithubOrg, meta.GithubRepo, meta.GitBranch, canary.Canary["sha"])
+		return nil
+	}()
+	event := models.EVENT_DEPLOY_SUCCESS
+	if err != nil {
+		logger.Error("error while promoting canary:", err)
+		msg = fmt.Sprintf("canary promotion failed: %v", err)
+		event = models.EVENT_DEPLOY_FAILURE
+	}
+	this.noti.SendMessageWithFallback(meta.Notification, meta.Watchcenter, models.Message{
+		Event:     event,
+		Text:      msg,
+		State:     event.State(),
+		Namespace: nsName,
+		Service:   meta.Service,
+	})
+}
+
+// AbortCanary points the service back to the stable RC and deletes the
+// canary RC.
+func (this *Deployer) AbortCanary(nsName string, meta *models.Metadata) error {
+	svc, canary, canaryRC, err := this.getCanary(nsName, meta)
+	if err != nil {
+		return err
+	}
+
+	svc.Spec.Selector = canary.Stable
+	models.SetCanary(svc, nil)
+	if _, err := this.k8s.UpdateService(nsName, svc); err != nil {
+		return fmt.Errorf("failed to update service selector: %v", err)
+	}
+	if err := this.k8s.DeleteReplicationController(nsName, canaryRC.Name); err != nil {
+		return fmt.Errorf("failed to delete canary RC %s: %v", canaryRC.Name, err)
+	}
+
+	if deployID, err := strconv.Atoi(canary.Canary["deploy_id"]); err == nil {
+		this.github.CreateDeploymentStatus(meta.GithubOrg, meta.GithubRepo, deployID, "failure")
+		this.finishDeployRecord(nsName, meta.Service, deployID, models.DEPLOY_RESULT_FAILURE, "canary aborted")
+	}
+	msg := fmt.Sprintf("canary aborted: %s/%s/%s:%s", meta.GithubOrg, meta.GithubRepo, meta.GitBranch, canary.Canary["sha"])
+	this.noti.SendMessageWithFallback(meta.Notification, meta.Watchcenter, models.Message{
+		Event:     models.EVENT_DEPLOY_FAILURE,
+		Text:      msg,
+		State:     models.EVENT_DEPLOY_FAILURE.State(),
+		Namespace: nsName,
+		Service:   meta.Service,
+		SHA:       canary.Canary["sha"],
+	})
+	return nil
+}
+
+func (this *Deployer) finishDeployRecord(nsName, svcName string, deployID int, result, errMsg string) {
+	if err := this.k8s.FinishDeployRecord(nsName, svcName, deployID, result, errMsg); err != nil {
+		logger.Error("failed to update deploy record:", err)
+	}
+}
+
+func (this *Deployer) getCanary(nsName string, meta *models.Metadata) (*k8sApi.Service, *models.Canary, *k8sApi.ReplicationController, error) {
+	svc, _, err := this.k8s.GetService(nsName, meta.Service)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	canary, err := models.GetCanary(svc)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if canary == nil {
+		return nil, nil, nil, fmt.Errorf("no canary in progress on %s/%s", nsName, meta.Service)
+	}
+	rcs, err := this.k8s.GetReplicationControllers(nsName, canary.Canary)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(rcs) < 1 {
+		return nil, nil, nil, fmt.Errorf("canary replication controller not found: %v", canary.Canary)
+	}
+	return svc, canary, &rcs[0], nil
+}
+
+// watch checks pods of the new RC during the configured watch period.
+func (this *Deployer) watch(d *deployment) error {
+	period := time.Duration(models.Conf.Kubernetes.WatchPeriod) * time.Second
+	if period <= 0 {
+		return nil
+	}
+	if d.meta.UseDeployment() {
+		d.fluentLogger.Info(fmt.Sprintf("watching pods of deployment %s for %v", d.meta.Service, period))
+		return this.k8s.WatchPods(d.nsName, d.rcSelector, d.meta.Replicas, period, models.Conf.Kubernetes.MaxRestarts, d.fluentLogger)
+	}
+
+	rcs, err := this.k8s.GetReplicationControllers(d.nsName, d.rcSelector)
+	if err != nil {
+		return err
+	}
+	if len(rcs) < 1 {
+		return fmt.Errorf("replication controller not found: %v", d.rcSelector)
+	}
+	rc := rcs[0]
+	d.fluentLogger.Info(fmt.Sprintf("watching pods of %s for %v", rc.Name, period))
+	return this.k8s.WatchPods(d.nsName, rc.Spec.Selector, int(rc.Spec.Replicas), period, models.Conf.Kubernetes.MaxRestarts, d.fluentLogger)
+}
+
+// rollback points the service back to the previous RC, scaling it up first
+// if it was scaled down by rolling update. deployments are rolled back to
+// their previous revision.
+func (this *Deployer) rollback(d *deployment, prevSelector map[string]string) error {
+	if d.meta.UseDeployment() {
+		return this.k8s.RollbackDeployment(d.nsName, d.meta.Service, 0)
+	}
+	if prevSelector == nil {
+		return fmt.Errorf("no previous deploy to roll back to")
+	}
+
+	rcs, err := this.k8s.GetReplicationControllers(d.nsName, prevSelector)
+	if err != nil {
+		return err
+	}
+	if len(rcs) < 1 {
+		return fmt.Errorf("previous replication controller not found: %v", prevSelector)
+	}
+	if prevRC := rcs[0]; prevRC.Spec.Replicas == 0 {
+		scaledRC, err := this.k8s.ScaleReplicationController(d.nsName, prevRC.Name, d.meta.Replicas)
+		if err != nil {
+			return fmt.Errorf("failed to scale %s: %v", prevRC.Name, err)
+		}
+		if err := this.k8s.WaitForRC(scaledRC, d.fluentLogger); err != nil {
+			return fmt.Errorf("error while waiting for pods of %s: %v", prevRC.Name, err)
+		}
+	}
+
+	svc, _, err := this.k8s.GetService(d.nsName, d.meta.Service)
+	if err != nil {
+		return err
+	}
+	svc.Spec.Selector = prevSelector
+	if _, err := this.k8s.UpdateService(d.nsName, svc); err != nil {
+		return fmt.Errorf("failed to restore service selector: %v", err)
+	}
+	if err := this.k8s.SyncHPA(d.nsName, d.meta, models.ReplicationControllerRef(rcs[0].Name)); err != nil {
+		logger.Error("failed to move autoscaler to previous RC:", err)
+	}
+	return nil
+}
+
+// syncHPA points the autoscaler to the activated RC or deployment.
+func (this *Deployer) syncHPA(d *deployment) {
+	target := models.DeploymentRef(d.meta.Service)
+	if !d.meta.UseDeployment() {
+		rcs, err := this.k8s.GetReplicationControllers(d.nsName, d.rcSelector)
+		if err != nil || len(rcs) < 1 {
+			logger.Error("failed to find activated RC for autoscaler:", err)
+			return
+		}
+		target = models.ReplicationControllerRef(rcs[0].Name)
+	}
+	if err := this.k8s.SyncHPA(d.nsName, d.meta, target); err != nil {
+		msg := fmt.Sprintf("failed to update autoscaler: %v", err)
+		logger.Error(msg)
+		d.fluentLogger.Info(msg)
+	}
+}
+
+// getActiveRC returns the service and the replication controller its
+// selector points to. the RC is nil if nothing is deployed yet.
+func (this *Deployer) getActiveRC(d *deployment) (*k8sApi.Service, *k8sApi.ReplicationController, error) {
+	svc, _, err := this.k8s.GetService(d.nsName, d.meta.Service)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deployID, ok := svc.Spec.Selector["deploy_id"]
+	if !ok {
+		return svc, nil, nil
+	}
+
+	rcSelector := make(map[string]string)
+	for k, v := range d.baseLabels {
+		rcSelector[k] = v
+	}
+	rcSelector["deploy_id"] = deployID
+	rcs, err := this.k8s.GetReplicationControllers(d.nsName, rcSelector)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rcs) == 0 {
+		return svc, nil, nil
+	}
+	return svc, &rcs[0], nil
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/kakao/cite/controller"
 	"github.com/kakao/cite/models"
@@ -27,7 +28,12 @@ func main() {
 		Format: "${time_rfc3339} ${remote_ip} ${method} ${status} ${latency_human} ${path}\n",
 	}))
 	e.Use(middleware.Recover())
-	e.Use(middleware.Gzip())
+	e.Use(middleware.GzipWithConfig(middleware.GzipConfig{
+		// gzip buffers streamed responses
+		Skipper: func(c echo.Context) bool {
+			return strings.HasSuffix(c.Path(), "/stream")
+		},
+	}))
 	e.Use()
 	// static resources
 	e.Static("/static", "static")
@@ -36,6 +42,11 @@ func main() {
 	// set ace renderer
 	e.Renderer = controller.AceRenderer{}
 
+	// authorization per namespace and service
+	read := controller.Authorize(models.PERMISSION_READ)
+	push := controller.Authorize(models.PERMISSION_PUSH)
+	admin := controller.Authorize(models.PERMISSION_ADMIN)
+
 	// routes
 	api := e.Group("/v1")
 	{
@@ -46,12 +57,44 @@ func main() {
 		api.GET("/notification/slack", controller.GetSlackOAuthToken)
 	}
 
+	// json api authenticated by personal api tokens
+	apiV2 := e.Group("/v2")
+	{
+		apiV2.Use(controller.AuthToken)
+		apiV2.GET("/namespaces", controller.GetAPINamespaces)
+		apiV2.GET("/namespaces/:namespace/services", controller.GetAPIServices, read)
+		apiV2.GET("/namespaces/:namespace/services/:service", controller.GetAPIService, read)
+		apiV2.GET("/namespaces/:namespace/services/:service/pods", controller.GetAPIServicePods, read)
+		apiV2.GET("/namespaces/:namespace/pods/:pod/log/stream", controller.GetPodLogStream, read)
+		apiV2.GET("/namespaces/:namespace/services/:service/settings", controller.GetAPIServiceSettings, push)
+		apiV2.PUT("/namespaces/:namespace/services/:service/settings", controller.PutAPIServiceSettings, push)
+		apiV2.GET("/namespaces/:namespace/services/:service/builds", controller.GetBuildsJSON, read)
+		apiV2.POST("/namespaces/:namespace/services/:service/builds", controller.PostAPIBuild, push)
+		apiV2.POST("/namespaces/:namespace/services/:service/builds/:id/cancel", controller.PostAPICancelBuild, push)
+		apiV2.GET("/namespaces/:namespace/services/:service/history", controller.GetDeployHistoryJSON, read)
+		apiV2.POST("/namespaces/:namespace/services/:service/deploys", controller.PostAPIDeploy, push)
+		apiV2.POST("/namespaces/:namespace/services/:service/activate", controller.PostAPIActivate, push)
+		apiV2.GET("/namespaces/:namespace/services/:service/approvals", controller.GetAPIApprovals, read)
+		apiV2.POST("/namespaces/:namespace/services/:service/approvals/:id/approve", controller.PostAPIApproveApproval, push)
+		apiV2.POST("/namespaces/:namespace/services/:service/approvals/:id/reject", controller.PostAPIRejectApproval, push)
+		apiV2.POST("/namespaces/:namespace/services/:service/scale", controller.PostAPIScale, push)
+		apiV2.GET("/namespaces/:namespace/pipelines", controller.GetAPIPipelines, read)
+		apiV2.GET("/namespaces/:namespace/pipelines/:pipeline", controller.GetAPIPipeline, read)
+		apiV2.PUT("/namespaces/:namespace/pipelines/:pipeline", controller.PutAPIPipeline, admin)
+		apiV2.DELETE("/namespaces/:namespace/pipelines/:pipeline", controller.DeleteAPIPipeline, admin)
+		apiV2.POST("/namespaces/:namespace/pipelines/:pipeline/promotions", controller.PostAPIPromotion, read)
+		apiV2.POST("/namespaces/:namespace/pipelines/:pipeline/promotions/:id/approve", controller.PostAPIApprovePromotion, read)
+		apiV2.POST("/namespaces/:namespace/pipelines/:pipeline/promotions/:id/reject", controller.PostAPIRejectPromotion, read)
+	}
+
 	ajax := e.Group("ajax")
 	{
 		ajax.Use(controller.AuthAPI)
 		ajax.GET("/github/repos", controller.GetGithubRepos)
 		ajax.GET("/github/branches", controller.GetGithubBranches)
 		ajax.GET("/docker/tags", controller.GetDockerTags)
+		ajax.GET("/namespaces/:namespace/services/:service/history", controller.GetDeployHistoryJSON, read)
+		ajax.GET("/namespaces/:namespace/services/:service/builds", controller.GetBuildsJSON, read)
 	}
 
 	webPublic := e.Group("")
@@ -66,23 +109,43 @@ func main() {
 		web.Use(controller.AuthWeb)
 		web.GET("/", controller.GetIndex)
 		web.GET("/settings/profile", controller.GetProfileSettings)
-		web.GET("/deploy_log/:github_org/:github_repo/:deploy_id", controller.GetDeployLog)
+		web.POST("/settings/tokens", controller.PostAPIToken)
+		web.GET("/settings/tokens/:id/delete", controller.DeleteAPIToken) // TODO: change method to DELETE
+		web.GET("/deploy_log/:github_org/:github_repo/:deploy_id", controller.GetDeployLog, read)
 		web.GET("/new", controller.GetNewService)
 		web.POST("/new", controller.PostNewService)
-		web.GET("/delete/:type/:nsName/:name", controller.DeleteService)          // TODO: change method to DELETE
-		web.GET("/scale/:nsName/:svcName/:rcName/:replicas", controller.PutScale) // TODO: change method to PUT
+		web.GET("/delete/:type/:nsName/:name", controller.DeleteService, admin)         // TODO: change method to DELETE
+		web.GET("/scale/:nsName/:svcName/:rcName/:replicas", controller.PutScale, push) // TODO: change method to PUT
 		web.GET("/namespaces", controller.GetNamespaces)
-		web.GET("/namespaces/:namespace", controller.GetNamespace)
-		web.GET("/namespaces/:namespace/services/:service", controller.GetService)
-		web.GET("/namespaces/:namespace/services/:service/settings", controller.GetServiceSettings)
-		web.POST("/namespaces/:namespace/services/:service/settings", controller.PostServiceSettings)
-		web.GET("/namespaces/:namespace/services/:service/build/:sha", controller.PostBuild)                 // TODO: change method to POST
-		web.GET("/namespaces/:namespace/services/:service/deploy/:sha", controller.PostDeploy)               // TODO: change method to POST
-		web.GET("/namespaces/:namespace/services/:service/activate/:sha/:deploy_id", controller.PutActivate) // TODO: change method to PUT
+		web.GET("/namespaces/:namespace", controller.GetNamespace, read)
+		web.GET("/namespaces/:namespace/builds/:job/log", controller.GetBuildLog, read)
+		web.GET("/namespaces/:namespace/pods/:pod/log", controller.GetPodLog, read)
+		web.GET("/namespaces/:namespace/pods/:pod/log/stream", controller.GetPodLogStream, read)
+		web.GET("/namespaces/:namespace/pipelines", controller.GetPipelines, read)
+		web.POST("/namespaces/:namespace/pipelines", controller.PostPipeline, admin)
+		web.GET("/namespaces/:namespace/pipelines/:pipeline", controller.GetPipeline, read)
+		web.GET("/namespaces/:namespace/pipelines/:pipeline/delete", controller.DeletePipeline, admin)                     // TODO: change method to DELETE
+		web.GET("/namespaces/:namespace/pipelines/:pipeline/promote/:stage", controller.PostPromotion, read)               // TODO: change method to POST
+		web.GET("/namespaces/:namespace/pipelines/:pipeline/promotions/:id/approve", controller.PutApprovePromotion, read) // TODO: change method to PUT
+		web.GET("/namespaces/:namespace/pipelines/:pipeline/promotions/:id/reject", controller.PutRejectPromotion, read)   // TODO: change method to PUT
+		web.GET("/namespaces/:namespace/services/:service", controller.GetService, read)
+		web.GET("/namespaces/:namespace/services/:service/settings", controller.GetServiceSettings, push)
+		web.POST("/namespaces/:namespace/services/:service/settings", controller.PostServiceSettings, push)
+		web.GET("/namespaces/:namespace/services/:service/build/:sha", controller.PostBuild, push)                     // TODO: change method to POST
+		web.GET("/namespaces/:namespace/services/:service/builds/:id/cancel", controller.PutCancelBuild, push)         // TODO: change method to PUT
+		web.GET("/namespaces/:namespace/services/:service/deploy/:sha", controller.PostDeploy, push)                   // TODO: change method to POST
+		web.GET("/namespaces/:namespace/services/:service/activate/:sha/:deploy_id", controller.PutActivate, push)     // TODO: change method to PUT
+		web.GET("/namespaces/:namespace/services/:service/canary/promote", controller.PutPromoteCanary, push)          // TODO: change method to PUT
+		web.GET("/namespaces/:namespace/services/:service/canary/abort", controller.PutAbortCanary, push)              // TODO: change method to PUT
+		web.GET("/namespaces/:namespace/services/:service/approvals/:id/approve", controller.PutApproveApproval, push) // TODO: change method to PUT
+		web.GET("/namespaces/:namespace/services/:service/approvals/:id/reject", controller.PutRejectApproval, push)   // TODO: change method to PUT
 
 		// github
-		web.GET("/namespaces/:namespace/services/:service/commits", controller.GetGitHubCommits)
-		web.GET("/namespaces/:namespace/services/:service/deployments", controller.GetGitHubDeployments)
+		web.GET("/namespaces/:namespace/services/:service/commits", controller.GetGitHubCommits, read)
+		web.GET("/namespaces/:namespace/services/:service/deployments", controller.GetGitHubDeployments, read)
+		web.GET("/namespaces/:namespace/services/:service/history", controller.GetDeployHistory, read)
+		web.GET("/namespaces/:namespace/services/:service/builds", controller.GetBuilds, read)
+		web.GET("/namespaces/:namespace/services/:service/stream", controller.GetServiceStream, read)
 	}
 
 	test := e.Group("/test")
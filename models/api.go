@@ -0,0 +1,96 @@
+package models
+
+// types of the json api at /v2, shared by the server and the cli.
+
+// APINamespace is a namespace and the github org or user owning it.
+type APINamespace struct {
+	Name string `json:"name"`
+	Org  string `json:"org"`
+}
+
+// APIService is a service with its settings. sha and deploy_id are of the
+// active deploy.
+type APIService struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	SHA       string    `json:"sha,omitempty"`
+	DeployID  string    `json:"deploy_id,omitempty"`
+	Metadata  *Metadata `json:"metadata"`
+}
+
+// Redacted returns a copy of the metadata without secrets, for users with
+// read permission. notification tokens and values of secret env are dropped.
+func (this *Metadata) Redacted() *Metadata {
+	meta := *this
+	meta.Notification = make([]Notification, len(this.Notification))
+	for i, nm := range this.Notification {
+		nm.Token = ""
+		nm.LegacyToken = ""
+		meta.Notification[i] = nm
+	}
+	meta.SecretEnvironment = make([]SecretEnv, len(this.SecretEnvironment))
+	for i, env := range this.SecretEnvironment {
+		meta.SecretEnvironment[i] = SecretEnv{Key: env.Key}
+	}
+	return &meta
+}
+
+// APIPod is a pod serving a service.
+type APIPod struct {
+	Name       string   `json:"name"`
+	Phase      string   `json:"phase"`
+	SHA        string   `json:"sha"`
+	DeployID   string   `json:"deploy_id"`
+	Containers []string `json:"containers"`
+}
+
+type APIBuildRequest struct {
+	SHA string `json:"sha"`
+}
+
+// APIDeployRequest is a deploy of the image. Environment is the github
+// deployment environment of the deploy, the default of github if empty.
+type APIDeployRequest struct {
+	SHA         string `json:"sha"`
+	Image       string `json:"image"`
+	Environment string `json:"environment,omitempty"`
+}
+
+// APIDeployResponse is the started deploy. deploys and activations of
+// protected services wait for Approval instead, and have no deploy id until
+// approved.
+type APIDeployResponse struct {
+	DeployID int       `json:"deploy_id"`
+	SHA      string    `json:"sha"`
+	Image    string    `json:"image"`
+	Approval *Approval `json:"approval,omitempty"`
+}
+
+type APIActivateRequest struct {
+	SHA      string `json:"sha"`
+	DeployID string `json:"deploy_id"`
+}
+
+type APIScaleRequest struct {
+	Replicas int    `json:"replicas"`
+	RC       string `json:"rc"`
+}
+
+// APIPipeline is a pipeline with deploys active on its stages, nil for stages
+// never deployed, and its recent promotions.
+type APIPipeline struct {
+	Pipeline
+	Active     []*DeployRecord `json:"active"`
+	Promotions []Promotion     `json:"promotions"`
+}
+
+// APIPromoteRequest promotes the active deploy of the From stage to the next
+// stage.
+type APIPromoteRequest struct {
+	From string `json:"from"`
+}
+
+// APIError is the body of failed api responses.
+type APIError struct {
+	Message string `json:"message"`
+}
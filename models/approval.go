@@ -0,0 +1,257 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	APPROVAL_CONFIGMAP   = "cite-approvals"
+	APPROVAL_LIMIT       = 50
+	APPROVAL_DEFAULT_TTL = 24 * time.Hour
+
+	APPROVAL_ACTION_DEPLOY   = "deploy"
+	APPROVAL_ACTION_ACTIVATE = "activate"
+
+	APPROVAL_STATE_PENDING  = "pending"
+	APPROVAL_STATE_APPROVED = "approved"
+	APPROVAL_STATE_REJECTED = "rejected"
+	APPROVAL_STATE_EXPIRED  = "expired"
+)
+
+var (
+	ErrApprovalDecided    = fmt.Errorf("approval already decided")
+	ErrApprovalExpired    = fmt.Errorf("approval expired")
+	ErrApprovalSelf       = fmt.Errorf("requester cannot approve own request")
+	ErrApprovalDuplicated = fmt.Errorf("already approved by the user")
+)
+
+// Protection holds deploys and activations of the service until Approvers
+// users with push permission on the repo, other than the requester, approve
+// them. requests not approved within TTL expire.
+type Protection struct {
+	Enable    bool   `json:"enable" schema:"enable"`
+	Approvers int    `json:"approvers" schema:"approvers"`
+	TTL       string `json:"ttl,omitempty" schema:"ttl"`
+}
+
+func (this *Protection) Validate() error {
+	if !this.Enable {
+		return nil
+	}
+	if this.Approvers <= 0 {
+		return fmt.Errorf("protected services need at least 1 approver")
+	}
+	if len(this.TTL) > 0 {
+		ttl, err := time.ParseDuration(this.TTL)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid approval ttl %s", this.TTL)
+		}
+	}
+	return nil
+}
+
+// ApprovalTTL returns how long approvals of the service stay pending.
+func (this *Protection) ApprovalTTL() time.Duration {
+	ttl, err := time.ParseDuration(this.TTL)
+	if err != nil || ttl <= 0 {
+		return APPROVAL_DEFAULT_TTL
+	}
+	return ttl
+}
+
+func (this *Metadata) ValidateProtection() error {
+	return this.Protection.Validate()
+}
+
+// Approval is a deploy or an activation of a protected service waiting for
+// approvers. DeployID is the github deployment to activate, or of the deploy
+// if it was created before the approval was requested. approvals are kept
+// per service, latest first, and copied into the deploy history once
+// approved.
+type Approval struct {
+	ID          string     `json:"id"`
+	Action      string     `json:"action"`
+	SHA         string     `json:"sha"`
+	Image       string     `json:"image,omitempty"`
+	DeployID    int        `json:"deploy_id,omitempty"`
+	TriggeredBy string     `json:"triggered_by"`
+	RequestedBy string     `json:"requested_by"`
+	RequestedAt time.Time  `json:"requested_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	Required    int        `json:"required"`
+	Approvers   []Approver `json:"approvers"`
+	State       string     `json:"state"`
+	RejectedBy  string     `json:"rejected_by,omitempty"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+}
+
+type Approver struct {
+	Login      string    `json:"login"`
+	ApprovedAt time.Time `json:"approved_at"`
+}
+
+// NewApproval returns a pending approval of the action by the protection of
+// the service. requestedBy is the user asking for it, and triggeredBy is
+// recorded in the deploy history once approved.
+func NewApproval(meta *Metadata, action, sha, imageName string, deployID int, triggeredBy, requestedBy string) Approval {
+	now := time.Now()
+	return Approval{
+		ID:          strconv.FormatInt(now.UnixNano(), 36),
+		Action:      action,
+		SHA:         sha,
+		Image:       imageName,
+		DeployID:    deployID,
+		TriggeredBy: triggeredBy,
+		RequestedBy: requestedBy,
+		RequestedAt: now,
+		ExpiresAt:   now.Add(meta.Protection.ApprovalTTL()),
+		Required:    meta.Protection.Approvers,
+		Approvers:   []Approver{},
+		State:       APPROVAL_STATE_PENDING,
+	}
+}
+
+func (this *Approval) Expired() bool {
+	return this.State == APPROVAL_STATE_PENDING && time.Now().After(this.ExpiresAt)
+}
+
+// GetApprovals returns approvals of the service, latest first.
+func (this *Kubernetes) GetApprovals(nsName, svcName string) ([]Approval, error) {
+	approvals := []Approval{}
+	err := this.getRecords(nsName, APPROVAL_CONFIGMAP, svcName, &approvals)
+	return approvals, err
+}
+
+func (this *Kubernetes) GetApproval(nsName, svcName, id string) (Approval, error) {
+	approvals, err := this.GetApprovals(nsName, svcName)
+	if err != nil {
+		return Approval{}, err
+	}
+	for _, approval := range approvals {
+		if approval.ID == id {
+			return approval, nil
+		}
+	}
+	return Approval{}, fmt.Errorf("approval not found. ns:%s, svc:%s, id:%s", nsName, svcName, id)
+}
+
+// AddApproval prepends the approval to approvals of the service and drops
+// records beyond APPROVAL_LIMIT.
+func (this *Kubernetes) AddApproval(nsName, svcName string, approval Approval) error {
+	approvals := []Approval{}
+	return this.updateRecords(nsName, APPROVAL_CONFIGMAP, svcName, &approvals, func() error {
+		approvals = append([]Approval{approval}, approvals...)
+		if len(approvals) > APPROVAL_LIMIT {
+			approvals = approvals[:APPROVAL_LIMIT]
+		}
+		return nil
+	})
+}
+
+// ApproveApproval adds the login to approvers of the pending approval, and
+// returns the updated approval. the approval is approved once it has as many
+// approvers as required. ErrApprovalExpired is returned if the approval
+// outlived its TTL, which expires it.
+func (this *Kubernetes) ApproveApproval(nsName, svcName, id, login string) (Approval, error) {
+	var approved Approval
+	expired := false
+	approvals := []Approval{}
+	err := this.updateRecords(nsName, APPROVAL_CONFIGMAP, svcName, &approvals, func() error {
+		expired = false
+		for i := range approvals {
+			approval := &approvals[i]
+			if approval.ID != id {
+				continue
+			}
+			if approval.State != APPROVAL_STATE_PENDING {
+				return ErrApprovalDecided
+			}
+			now := time.Now()
+			if approval.Expired() {
+				approval.State = APPROVAL_STATE_EXPIRED
+				approval.DecidedAt = &now
+				approved = *approval
+				expired = true
+				return nil
+			}
+			if approval.RequestedBy == login {
+				return ErrApprovalSelf
+			}
+			for _, approver := range approval.Approvers {
+				if approver.Login == login {
+					return ErrApprovalDuplicated
+				}
+			}
+			approval.Approvers = append(approval.Approvers, Approver{Login: login, ApprovedAt: now})
+			if len(approval.Approvers) >= approval.Required {
+				approval.State = APPROVAL_STATE_APPROVED
+				approval.DecidedAt = &now
+			}
+			approved = *approval
+			return nil
+		}
+		return fmt.Errorf("approval not found. ns:%s, svc:%s, id:%s", nsName, svcName, id)
+	})
+	if err == nil && expired {
+		err = ErrApprovalExpired
+	}
+	return approved, err
+}
+
+// RejectApproval rejects the pending approval.
+func (this *Kubernetes) RejectApproval(nsName, svcName, id, login string) (Approval, error) {
+	var rejected Approval
+	approvals := []Approval{}
+	err := this.updateRecords(nsName, APPROVAL_CONFIGMAP, svcName, &approvals, func() error {
+		for i := range approvals {
+			if approvals[i].ID != id {
+				continue
+			}
+			if approvals[i].State != APPROVAL_STATE_PENDING {
+				return ErrApprovalDecided
+			}
+			now := time.Now()
+			approvals[i].State = APPROVAL_STATE_REJECTED
+			approvals[i].RejectedBy = login
+			approvals[i].DecidedAt = &now
+			rejected = approvals[i]
+			return nil
+		}
+		return fmt.Errorf("approval not found. ns:%s, svc:%s, id:%s", nsName, svcName, id)
+	})
+	return rejected, err
+}
+
+// ExpireApprovals expires pending approvals of the service which outlived
+// their TTL, and returns them.
+func (this *Kubernetes) ExpireApprovals(nsName, svcName string) ([]Approval, error) {
+	approvals, err := this.GetApprovals(nsName, svcName)
+	if err != nil {
+		return nil, err
+	}
+	hasExpired := false
+	for i := range approvals {
+		hasExpired = hasExpired || approvals[i].Expired()
+	}
+	if !hasExpired {
+		return nil, nil
+	}
+
+	var expired []Approval
+	err = this.updateRecords(nsName, APPROVAL_CONFIGMAP, svcName, &approvals, func() error {
+		expired = nil
+		now := time.Now()
+		for i := range approvals {
+			if !approvals[i].Expired() {
+				continue
+			}
+			approvals[i].State = APPROVAL_STATE_EXPIRED
+			approvals[i].DecidedAt = &now
+			expired = append(expired, approvals[i])
+		}
+		return nil
+	})
+	return expired, err
+}
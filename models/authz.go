@@ -0,0 +1,99 @@
+package models
+
+import (
+	"net/http"
+
+	"github.com/google/go-github/github"
+)
+
+// Permission is the level of access of a user on a namespace or a service.
+// levels are ordered, so a higher level grants lower ones.
+type Permission int
+
+const (
+	PERMISSION_NONE Permission = iota
+	PERMISSION_READ
+	PERMISSION_PUSH
+	PERMISSION_ADMIN
+)
+
+func (this Permission) String() string {
+	switch this {
+	case PERMISSION_READ:
+		return "read"
+	case PERMISSION_PUSH:
+		return "push"
+	case PERMISSION_ADMIN:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// GetNamespaceOrg returns the github org, or the user's own account, whose
+// namespace is nsName. empty string is returned if the user belongs to none.
+func (this *GitHub) GetNamespaceOrg(login, nsName string) (string, error) {
+	if this.util.NormalizeByHyphen("", login) == nsName {
+		return login, nil
+	}
+	orgs, err := this.ListOrgs()
+	if err != nil {
+		return "", err
+	}
+	for _, org := range orgs {
+		if this.util.NormalizeByHyphen("", *org.Login) == nsName {
+			return *org.Login, nil
+		}
+	}
+	return "", nil
+}
+
+// GetOrgPermission returns the permission of the user on the org. members
+// read, and admins of the org or the owner of the account administer.
+func (this *GitHub) GetOrgPermission(login, org string) (Permission, error) {
+	if login == org {
+		return PERMISSION_ADMIN, nil
+	}
+	membership, _, err := this.client.Organizations.GetOrgMembership("", org)
+	if isNotFound(err) {
+		return PERMISSION_NONE, nil
+	} else if err != nil {
+		return PERMISSION_NONE, err
+	}
+	if membership.State != nil && *membership.State != "active" {
+		return PERMISSION_NONE, nil
+	}
+	if membership.Role != nil && *membership.Role == "admin" {
+		return PERMISSION_ADMIN, nil
+	}
+	return PERMISSION_READ, nil
+}
+
+// GetRepoPermission returns the permission of the user on the repo.
+func (this *GitHub) GetRepoPermission(owner, repo string) (Permission, error) {
+	r, _, err := this.client.Repositories.Get(owner, repo)
+	if isNotFound(err) {
+		return PERMISSION_NONE, nil
+	} else if err != nil {
+		return PERMISSION_NONE, err
+	}
+	if r.Permissions == nil {
+		return PERMISSION_NONE, nil
+	}
+	perms := *r.Permissions
+	switch {
+	case perms["admin"]:
+		return PERMISSION_ADMIN, nil
+	case perms["push"]:
+		return PERMISSION_PUSH, nil
+	case perms["pull"]:
+		return PERMISSION_READ, nil
+	default:
+		return PERMISSION_NONE, nil
+	}
+}
+
+func isNotFound(err error) bool {
+	errResp, ok := err.(*github.ErrorResponse)
+	return ok && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound
+}
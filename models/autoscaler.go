@@ -0,0 +1,76 @@
+package models
+
+import (
+	"fmt"
+
+	"k8s.io/kubernetes/pkg/api"
+	k8sErrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/apis/autoscaling"
+)
+
+// ReplicationControllerRef returns the autoscaler target of an RC.
+func ReplicationControllerRef(name string) autoscaling.CrossVersionObjectReference {
+	return autoscaling.CrossVersionObjectReference{
+		Kind:       "ReplicationController",
+		Name:       name,
+		APIVersion: "v1",
+	}
+}
+
+// DeploymentRef returns the autoscaler target of a deployment.
+func DeploymentRef(name string) autoscaling.CrossVersionObjectReference {
+	return autoscaling.CrossVersionObjectReference{
+		Kind:       "Deployment",
+		Name:       name,
+		APIVersion: "extensions/v1beta1",
+	}
+}
+
+func (this *Kubernetes) GetHPA(nsName, name string) (*autoscaling.HorizontalPodAutoscaler, error) {
+	return this.client.Autoscaling().HorizontalPodAutoscalers(nsName).Get(name)
+}
+
+// SyncHPA points the autoscaler of the service to the target, or deletes it
+// if autoscaling is disabled.
+func (this *Kubernetes) SyncHPA(nsName string, meta *Metadata, target autoscaling.CrossVersionObjectReference) error {
+	if !meta.Autoscaling.Enable {
+		return this.DeleteHPA(nsName, meta.Service)
+	}
+
+	logger.Info(fmt.Sprintf("upsert horizontal pod autoscaler. ns:%s, hpa:%s, target:%s/%s", nsName, meta.Service, target.Kind, target.Name))
+	minReplicas := int32(meta.Autoscaling.MinReplicas)
+	targetCPU := int32(meta.Autoscaling.TargetCPU)
+	spec := autoscaling.HorizontalPodAutoscalerSpec{
+		ScaleTargetRef:                 target,
+		MinReplicas:                    &minReplicas,
+		MaxReplicas:                    int32(meta.Autoscaling.MaxReplicas),
+		TargetCPUUtilizationPercentage: &targetCPU,
+	}
+
+	hpai := this.client.Autoscaling().HorizontalPodAutoscalers(nsName)
+	hpa, err := hpai.Get(meta.Service)
+	if k8sErrors.IsNotFound(err) {
+		_, err = hpai.Create(&autoscaling.HorizontalPodAutoscaler{
+			ObjectMeta: api.ObjectMeta{
+				Name:   meta.Service,
+				Labels: this.GetServiceLabels(meta),
+			},
+			Spec: spec,
+		})
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	hpa.Spec = spec
+	_, err = hpai.Update(hpa)
+	return err
+}
+
+func (this *Kubernetes) DeleteHPA(nsName, name string) error {
+	err := this.client.Autoscaling().HorizontalPodAutoscalers(nsName).Delete(name, nil)
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
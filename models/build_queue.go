@@ -0,0 +1,174 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	BUILD_HISTORY_CONFIGMAP = "cite-build-history"
+	BUILD_HISTORY_LIMIT     = 100
+)
+
+// ErrBuildInProgress is returned when the commit is already being built for
+// the service.
+var ErrBuildInProgress = errors.New("build in progress")
+
+// BuildRecord is a single build of a service. records are kept in a
+// configmap per namespace like deploy records, so cite tracks builds without
+// relying on github commit statuses.
+type BuildRecord struct {
+	ID          string     `json:"id"`
+	SHA         string     `json:"sha"`
+	Builder     string     `json:"builder"`
+	RequestedBy string     `json:"requested_by"`
+	State       string     `json:"state"`
+	Image       string     `json:"image,omitempty"`
+	BuildID     string     `json:"build_id,omitempty"`
+	BuildURL    string     `json:"build_url,omitempty"`
+	LogURL      string     `json:"log_url,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	// Deploy is set on builds of deploy triggers, which are deployed on
+	// success even if auto deploy is off.
+	Deploy bool `json:"deploy,omitempty"`
+}
+
+// NewBuildRecord returns a pending build record of the commit.
+func NewBuildRecord(builder, sha, requestedBy string) BuildRecord {
+	now := time.Now()
+	return BuildRecord{
+		ID:          strconv.FormatInt(now.UnixNano(), 36),
+		SHA:         sha,
+		Builder:     builder,
+		RequestedBy: requestedBy,
+		State:       BUILD_STATE_PENDING,
+		StartedAt:   now,
+	}
+}
+
+func (this BuildRecord) Finished() bool {
+	return this.State != BUILD_STATE_PENDING
+}
+
+// Duration returns the elapsed time of the build, until now if it is still
+// pending.
+func (this BuildRecord) Duration() time.Duration {
+	end := time.Now()
+	if this.FinishedAt != nil {
+		end = *this.FinishedAt
+	}
+	return end.Sub(this.StartedAt) / time.Second * time.Second
+}
+
+// GetBuildRecords returns build records of the service, latest first.
+func (this *Kubernetes) GetBuildRecords(nsName, svcName string) ([]BuildRecord, error) {
+	records := []BuildRecord{}
+	err := this.getRecords(nsName, BUILD_HISTORY_CONFIGMAP, svcName, &records)
+	return records, err
+}
+
+// GetBuildRecord returns the build record of the id.
+func (this *Kubernetes) GetBuildRecord(nsName, svcName, id string) (BuildRecord, error) {
+	records, err := this.GetBuildRecords(nsName, svcName)
+	if err != nil {
+		return BuildRecord{}, err
+	}
+	for _, record := range records {
+		if record.ID == id {
+			return record, nil
+		}
+	}
+	return BuildRecord{}, fmt.Errorf("build record not found. ns:%s, svc:%s, id:%s", nsName, svcName, id)
+}
+
+// AddBuildRecord queues the build of the service. ErrBuildInProgress is
+// returned if the commit is already being built.
+func (this *Kubernetes) AddBuildRecord(nsName, svcName string, record BuildRecord) error {
+	records := []BuildRecord{}
+	return this.updateRecords(nsName, BUILD_HISTORY_CONFIGMAP, svcName, &records, func() error {
+		for _, r := range records {
+			if r.SHA == record.SHA && !r.Finished() {
+				return ErrBuildInProgress
+			}
+		}
+		records = append([]BuildRecord{record}, records...)
+		if len(records) > BUILD_HISTORY_LIMIT {
+			records = records[:BUILD_HISTORY_LIMIT]
+		}
+		return nil
+	})
+}
+
+// UpdateBuildRecord applies the build result to the latest build of the
+// commit and returns the updated record. builds started outside of cite,
+// e.g. rebuilds on buildbot, are added as new records. results of canceled
+// builds are ignored.
+func (this *Kubernetes) UpdateBuildRecord(nsName, svcName string, result BuildResult) (BuildRecord, error) {
+	var updated BuildRecord
+	records := []BuildRecord{}
+	err := this.updateRecords(nsName, BUILD_HISTORY_CONFIGMAP, svcName, &records, func() error {
+		idx := -1
+		for i := range records {
+			if records[i].SHA == result.SHA {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 || (records[idx].Finished() && result.State == BUILD_STATE_PENDING) {
+			records = append([]BuildRecord{NewBuildRecord(result.Builder, result.SHA, result.Builder)}, records...)
+			if len(records) > BUILD_HISTORY_LIMIT {
+				records = records[:BUILD_HISTORY_LIMIT]
+			}
+			idx = 0
+		}
+
+		record := &records[idx]
+		if record.State == BUILD_STATE_CANCELED {
+			updated = *record
+			return nil
+		}
+		record.State = result.State
+		if len(result.Image) > 0 {
+			record.Image = result.Image
+		}
+		if len(result.BuildID) > 0 {
+			record.BuildID = result.BuildID
+		}
+		if len(result.BuildURL) > 0 {
+			record.BuildURL = result.BuildURL
+		}
+		if len(result.LogURL) > 0 {
+			record.LogURL = result.LogURL
+		}
+		if record.Finished() && record.FinishedAt == nil {
+			now := time.Now()
+			record.FinishedAt = &now
+		}
+		updated = *record
+		return nil
+	})
+	return updated, err
+}
+
+// CancelBuildRecord marks the pending build as canceled.
+func (this *Kubernetes) CancelBuildRecord(nsName, svcName, id string) error {
+	records := []BuildRecord{}
+	return this.updateRecords(nsName, BUILD_HISTORY_CONFIGMAP, svcName, &records, func() error {
+		for i := range records {
+			if records[i].ID != id {
+				continue
+			}
+			if records[i].Finished() {
+				return fmt.Errorf("build %s is already %s", id, records[i].State)
+			}
+			now := time.Now()
+			records[i].State = BUILD_STATE_CANCELED
+			records[i].FinishedAt = &now
+			return nil
+		}
+		return fmt.Errorf("build record not found. ns:%s, svc:%s, id:%s", nsName, svcName, id)
+	})
+}
@@ -14,10 +14,19 @@ import (
 	"time"
 )
 
+const (
+	BUILDBOT_LOG_POLL_INTERVAL  = 2 * time.Second
+	BUILDBOT_LOG_STREAM_TIMEOUT = time.Hour
+)
+
 type BuildBot struct {
 	github         *GitHub
 	imageNameRegex *regexp.Regexp
 	logURLRegex    *regexp.Regexp
+	buildURLRegex  *regexp.Regexp
+
+	streaming      map[string]bool
+	streamingMutex sync.Mutex
 }
 
 type BuildbotChangeHook struct {
@@ -53,6 +62,8 @@ func NewBuildBot() *BuildBot {
 			github:         NewCommonGitHub(),
 			imageNameRegex: regexp.MustCompile("imageName:(.+)"),
 			logURLRegex:    regexp.MustCompile("logURL:(.+)"),
+			buildURLRegex:  regexp.MustCompile("#/?builders/([0-9]+)/builds/([0-9]+)"),
+			streaming:      make(map[string]bool),
 		}
 	})
 	return buildbotInst
@@ -77,28 +88,40 @@ func (this *BuildBot) GetLogContent(url string) (string, error) {
 
 	logs := make([]string, len(logChunks.LogChunks))
 	for i, lc := range logChunks.LogChunks {
-		lcr := bufio.NewScanner(strings.NewReader(lc.Content))
-		var lcs []string
-		for lcr.Scan() {
-			lc := lcr.Text()
-			prefix := ""
-			switch lc[0] {
-			case 'h':
-				prefix = "header"
-			case 'o':
-				prefix = "stdout"
-			case 'e':
-				prefix = "stderr"
-			case 'i':
-				prefix = "input"
-			}
-			lcs = append(lcs, fmt.Sprintf("%s: %s", prefix, lc[1:len(lc)]))
-		}
-		logs[i] = strings.Join(lcs, "\n")
+		logs[i] = strings.Join(formatLogChunk(lc.Content), "\n")
 	}
 	return strings.Join(logs, "\n"), nil
 }
 
+// formatLogChunk returns lines of the log chunk, prefixed by their stream
+// instead of buildbot's single letter.
+func formatLogChunk(content string) []string {
+	lcr := bufio.NewScanner(strings.NewReader(content))
+	var lcs []string
+	for lcr.Scan() {
+		lc := lcr.Text()
+		if len(lc) == 0 {
+			continue
+		}
+		lcs = append(lcs, fmt.Sprintf("%s: %s", logStreamName(lc[0]), lc[1:len(lc)]))
+	}
+	return lcs
+}
+
+func logStreamName(c byte) string {
+	switch c {
+	case 'h':
+		return "header"
+	case 'o':
+		return "stdout"
+	case 'e':
+		return "stderr"
+	case 'i':
+		return "input"
+	}
+	return ""
+}
+
 func (b *BuildBot) GetImageName(description string) (string, error) {
 	imageNameSubmatch := b.imageNameRegex.FindStringSubmatch(description)
 	if len(imageNameSubmatch) < 2 {
@@ -169,3 +192,164 @@ func (b *BuildBot) Proxy(method string, header http.Header, body []byte) error {
 	}
 	return nil
 }
+
+// buildAPIURL returns the data api url of the build of the buildbot web
+// url, e.g. http://buildbot/#builders/1/builds/2.
+func (b *BuildBot) buildAPIURL(buildURL string) (string, error) {
+	buildURLSubmatch := b.buildURLRegex.FindStringSubmatch(buildURL)
+	if len(buildURLSubmatch) < 3 {
+		return "", fmt.Errorf("build url parse failed on %s", buildURL)
+	}
+	return fmt.Sprintf("%s/builders/%s/builds/%s", b.apiURL(buildURL), buildURLSubmatch[1], buildURLSubmatch[2]), nil
+}
+
+func (b *BuildBot) apiURL(buildURL string) string {
+	host := strings.TrimRight(strings.SplitN(buildURL, "#", 2)[0], "/")
+	if len(Conf.Buildbot.Host) > 0 {
+		host = strings.TrimRight(Conf.Buildbot.Host, "/")
+	}
+	return host + "/api/v2"
+}
+
+func (b *BuildBot) getJSON(url string, v interface{}) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 399 {
+		return fmt.Errorf("buildbot error. url:%s, status:%v, body:%s", url, resp.Status, body)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// StopBuild stops the build of the buildbot web url through the data api.
+func (b *BuildBot) StopBuild(buildURL, reason string) error {
+	url, err := b.buildAPIURL(buildURL)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"id":      1,
+		"jsonrpc": "2.0",
+		"method":  "stop",
+		"params": map[string]string{
+			"reason": reason,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to stop build %s: %v", buildURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 399 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("buildbot error. status:%v, body:%s", resp.Status, respBody)
+	}
+	return nil
+}
+
+// StreamBuildLog polls log chunks of the build until it completes, and
+// publishes new lines to the log stream of the service. a build is streamed
+// once however many times it is reported.
+func (b *BuildBot) StreamBuildLog(nsName, svcName, sha, buildURL string) {
+	b.streamingMutex.Lock()
+	if b.streaming[buildURL] {
+		b.streamingMutex.Unlock()
+		return
+	}
+	b.streaming[buildURL] = true
+	b.streamingMutex.Unlock()
+	defer func() {
+		b.streamingMutex.Lock()
+		delete(b.streaming, buildURL)
+		b.streamingMutex.Unlock()
+	}()
+
+	buildAPIURL, err := b.buildAPIURL(buildURL)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	apiURL := b.apiURL(buildURL)
+
+	// lines sent per log id
+	sent := make(map[int]int)
+	deadline := time.Now().Add(BUILDBOT_LOG_STREAM_TIMEOUT)
+	for time.Now().Before(deadline) {
+		// fetch the build state first, so that lines written until the
+		// build completes are streamed
+		var builds struct {
+			Builds []struct {
+				Complete bool `json:"complete"`
+			} `json:"builds"`
+		}
+		if err := b.getJSON(buildAPIURL, &builds); err != nil {
+			logger.Errorf("failed to get build %s: %v", buildURL, err)
+			return
+		}
+		complete := len(builds.Builds) > 0 && builds.Builds[0].Complete
+
+		var steps struct {
+			Steps []struct {
+				StepID int `json:"stepid"`
+			} `json:"steps"`
+		}
+		if err := b.getJSON(buildAPIURL+"/steps", &steps); err != nil {
+			logger.Errorf("failed to get steps of build %s: %v", buildURL, err)
+			return
+		}
+		for _, step := range steps.Steps {
+			var logs struct {
+				Logs []struct {
+					LogID int `json:"logid"`
+				} `json:"logs"`
+			}
+			if err := b.getJSON(fmt.Sprintf("%s/steps/%d/logs", apiURL, step.StepID), &logs); err != nil {
+				logger.Errorf("failed to get logs of build %s: %v", buildURL, err)
+				continue
+			}
+			for _, l := range logs.Logs {
+				var logChunks LogChunks
+				url := fmt.Sprintf("%s/logs/%d/contents?offset=%d", apiURL, l.LogID, sent[l.LogID])
+				if err := b.getJSON(url, &logChunks); err != nil {
+					logger.Errorf("failed to get log contents of build %s: %v", buildURL, err)
+					continue
+				}
+				for _, lc := range logChunks.LogChunks {
+					for i, line := range strings.Split(strings.TrimSuffix(lc.Content, "\n"), "\n") {
+						if lc.FirstLine+i < sent[l.LogID] || len(line) == 0 {
+							continue
+						}
+						b.publishLogLine(nsName, svcName, sha, line)
+						sent[l.LogID] = lc.FirstLine + i + 1
+					}
+				}
+			}
+		}
+
+		if complete {
+			return
+		}
+		time.Sleep(BUILDBOT_LOG_POLL_INTERVAL)
+	}
+}
+
+func (b *BuildBot) publishLogLine(nsName, svcName, sha, line string) {
+	NewLogStream().Publish(StreamEvent{
+		Type:      STREAM_EVENT_BUILD,
+		Namespace: nsName,
+		Service:   svcName,
+		SHA:       sha,
+		Level:     logStreamName(line[0]),
+		Message:   line[1:],
+	})
+}
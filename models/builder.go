@@ -0,0 +1,95 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+const (
+	BUILDER_BUILDBOT = "buildbot"
+	BUILDER_JOB      = "job"
+
+	BUILD_STATE_PENDING  = "pending"
+	BUILD_STATE_SUCCESS  = "success"
+	BUILD_STATE_FAILURE  = "failure"
+	BUILD_STATE_CANCELED = "canceled"
+)
+
+// BuildResult is the state of a build reported by builders.
+type BuildResult struct {
+	Builder   string `json:"builder"`
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	SHA       string `json:"sha"`
+	State     string `json:"state"`
+	Image     string `json:"image,omitempty"`
+	BuildID   string `json:"build_id,omitempty"`
+	BuildURL  string `json:"build_url,omitempty"`
+	LogURL    string `json:"log_url,omitempty"`
+	Log       string `json:"log,omitempty"`
+}
+
+// BuildCallback receives build results of a service.
+type BuildCallback func(meta *Metadata, result BuildResult)
+
+// Builder builds docker images of commits. builders register themselves by
+// RegisterBuilder.
+type Builder interface {
+	// Build starts to build the commit of the service. ref is the git ref
+	// the commit is fetched by, or empty for the branch of the service. the
+	// result is reported through the callback, or through github commit
+	// status in case of buildbot.
+	Build(nsName string, meta *Metadata, ref, sha string, callback BuildCallback) error
+	// Push handles a github push event of the ref of the service.
+	Push(nsName string, meta *Metadata, ref, sha string, header http.Header, body []byte, callback BuildCallback) error
+	// Cancel stops the pending build of the record.
+	Cancel(nsName string, meta *Metadata, record BuildRecord) error
+}
+
+var (
+	builders      = make(map[string]Builder)
+	buildersMutex sync.RWMutex
+)
+
+func RegisterBuilder(name string, builder Builder) {
+	buildersMutex.Lock()
+	defer buildersMutex.Unlock()
+	builders[name] = builder
+}
+
+func GetBuilder(name string) (Builder, error) {
+	buildersMutex.RLock()
+	defer buildersMutex.RUnlock()
+	builder, ok := builders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown builder %s", name)
+	}
+	return builder, nil
+}
+
+// BuildRef returns the git ref to fetch commits built by the ref, which is
+// the branch of the service if ref is empty.
+func (this *Metadata) BuildRef(ref string) string {
+	if len(ref) > 0 {
+		return ref
+	}
+	return "refs/heads/" + this.GitBranch
+}
+
+// BuilderName returns the builder of the service. services created before
+// builders were selectable use the default builder.
+func (this *Metadata) BuilderName() string {
+	if len(this.Builder) > 0 {
+		return this.Builder
+	}
+	if len(Conf.Builder.Default) > 0 {
+		return Conf.Builder.Default
+	}
+	return BUILDER_BUILDBOT
+}
+
+func (this *Metadata) ValidateBuilder() error {
+	_, err := GetBuilder(this.BuilderName())
+	return err
+}
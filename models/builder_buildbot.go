@@ -0,0 +1,35 @@
+package models
+
+import (
+	"net/http"
+	"strings"
+)
+
+// buildbotBuilder sends changes to buildbot. buildbot reports results
+// through github commit status, which is handled by the github callback.
+type buildbotBuilder struct{}
+
+func init() {
+	RegisterBuilder(BUILDER_BUILDBOT, buildbotBuilder{})
+}
+
+func (this buildbotBuilder) Build(nsName string, meta *Metadata, ref, sha string, callback BuildCallback) error {
+	branch := meta.GitBranch
+	if strings.HasPrefix(ref, "refs/heads/") {
+		branch = strings.TrimPrefix(ref, "refs/heads/")
+	}
+	return NewBuildBot().Build(nsName, meta.GithubRepo, branch, sha)
+}
+
+func (this buildbotBuilder) Push(nsName string, meta *Metadata, ref, sha string, header http.Header, body []byte, callback BuildCallback) error {
+	return NewBuildBot().Proxy(http.MethodPost, header, body)
+}
+
+// Cancel stops the build on buildbot. builds which buildbot has not reported
+// yet can not be stopped, and their results are ignored once canceled.
+func (this buildbotBuilder) Cancel(nsName string, meta *Metadata, record BuildRecord) error {
+	if len(record.BuildURL) == 0 {
+		return nil
+	}
+	return NewBuildBot().StopBuild(record.BuildURL, "canceled on cite")
+}
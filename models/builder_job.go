@@ -0,0 +1,367 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/batch"
+	"k8s.io/kubernetes/pkg/util/wait"
+)
+
+const (
+	BUILD_JOB_LABEL_KEY     = "cite-build-job"
+	BUILD_SERVICE_LABEL_KEY = "cite-build-service"
+	BUILD_JOB_LOG_LINES     = 50
+)
+
+// jobBuilder builds images in the cluster by running a kubernetes job of
+// kaniko per commit. the job is watched by cite, and the result is reported
+// through the callback.
+type jobBuilder struct{}
+
+func init() {
+	RegisterBuilder(BUILDER_JOB, jobBuilder{})
+}
+
+func (this jobBuilder) Build(nsName string, meta *Metadata, ref, sha string, callback BuildCallback) error {
+	k8s := NewKubernetes()
+	job, imageName, err := k8s.CreateBuildJob(nsName, meta, ref, sha)
+	if err != nil {
+		return err
+	}
+
+	result := BuildResult{
+		Builder:   BUILDER_JOB,
+		Namespace: nsName,
+		Service:   meta.Service,
+		SHA:       sha,
+		State:     BUILD_STATE_PENDING,
+		Image:     imageName,
+		BuildID:   job.Name,
+		BuildURL:  BuildJobLogURL(nsName, job.Name),
+		LogURL:    BuildJobLogURL(nsName, job.Name),
+	}
+	this.report(meta, result, callback)
+
+	go this.watch(nsName, meta, result, callback)
+	return nil
+}
+
+var (
+	watchedBuildJobs      = make(map[string]bool)
+	watchedBuildJobsMutex sync.Mutex
+)
+
+// watch waits for the build job and reports its result. jobs already watched
+// by this process are skipped, so that reconciles do not report twice.
+func (this jobBuilder) watch(nsName string, meta *Metadata, result BuildResult, callback BuildCallback) {
+	key := nsName + "/" + result.BuildID
+	watchedBuildJobsMutex.Lock()
+	if watchedBuildJobs[key] {
+		watchedBuildJobsMutex.Unlock()
+		return
+	}
+	watchedBuildJobs[key] = true
+	watchedBuildJobsMutex.Unlock()
+	defer func() {
+		watchedBuildJobsMutex.Lock()
+		delete(watchedBuildJobs, key)
+		watchedBuildJobsMutex.Unlock()
+	}()
+
+	k8s := NewKubernetes()
+	job, err := k8s.GetBuildJob(nsName, result.BuildID)
+	found := err == nil
+	if found {
+		err = k8s.WaitForBuildJob(job)
+	}
+	if err == errBuildJobStopped {
+		logger.Infof("build job %s/%s canceled", nsName, result.BuildID)
+		result.State = BUILD_STATE_CANCELED
+	} else if err != nil {
+		logger.Errorf("build job %s/%s failed: %v", nsName, result.BuildID, err)
+		result.State = BUILD_STATE_FAILURE
+		result.Log, _ = k8s.GetBuildJobLog(nsName, result.BuildID, BUILD_JOB_LOG_LINES)
+		if found {
+			if err := k8s.StopBuildJob(nsName, result.BuildID); err != nil {
+				logger.Errorf("failed to stop build job %s/%s: %v", nsName, result.BuildID, err)
+			}
+		}
+	} else {
+		result.State = BUILD_STATE_SUCCESS
+	}
+	this.report(meta, result, callback)
+}
+
+// ReconcileBuildJobs watches again pending job builds of the service, whose
+// watchers were lost by restarts of cite. builds which never got a job are
+// failed once they outlive the job timeout. it returns how many builds are
+// reconciled.
+func ReconcileBuildJobs(nsName string, meta *Metadata, callback BuildCallback) (int, error) {
+	k8s := NewKubernetes()
+	records, err := k8s.GetBuildRecords(nsName, meta.Service)
+	if err != nil {
+		return 0, err
+	}
+	timeout := time.Duration(Conf.Builder.Job.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = k8s.pollTimeout
+	}
+
+	reconciled := 0
+	for _, record := range records {
+		if record.Builder != BUILDER_JOB || record.Finished() {
+			continue
+		}
+		result := BuildResult{
+			Builder:   BUILDER_JOB,
+			Namespace: nsName,
+			Service:   meta.Service,
+			SHA:       record.SHA,
+			State:     BUILD_STATE_PENDING,
+			Image:     record.Image,
+			BuildID:   record.BuildID,
+			BuildURL:  record.BuildURL,
+			LogURL:    record.LogURL,
+		}
+		if len(record.BuildID) == 0 {
+			if time.Since(record.StartedAt) < timeout {
+				continue
+			}
+			result.State = BUILD_STATE_FAILURE
+			result.Log = "build job was not created"
+			if callback != nil {
+				callback(meta, result)
+			}
+		} else {
+			go jobBuilder{}.watch(nsName, meta, result, callback)
+		}
+		reconciled++
+	}
+	return reconciled, nil
+}
+
+func (this jobBuilder) Push(nsName string, meta *Metadata, ref, sha string, header http.Header, body []byte, callback BuildCallback) error {
+	return this.Build(nsName, meta, ref, sha, callback)
+}
+
+// Cancel stops the build job. the job is left for its log, and the watcher of
+// the job reports the cancel.
+func (this jobBuilder) Cancel(nsName string, meta *Metadata, record BuildRecord) error {
+	if len(record.BuildID) == 0 {
+		return nil
+	}
+	return NewKubernetes().StopBuildJob(nsName, record.BuildID)
+}
+
+// report updates github commit status, which keeps deploy buttons on the
+// commit list working, and calls back.
+func (this jobBuilder) report(meta *Metadata, result BuildResult, callback BuildCallback) {
+	// github has no canceled state
+	state := result.State
+	if state == BUILD_STATE_CANCELED {
+		state = "error"
+	}
+	NewCommonGitHub().CreateBuildStatus(meta.GithubOrg, meta.GithubRepo, result.SHA,
+		state, CITE_JOB_GITHUB_CONTEXT, "imageName:"+result.Image, result.LogURL)
+	if callback != nil {
+		callback(meta, result)
+	}
+}
+
+// BuildJobLogURL returns the cite url showing the log of the build job.
+func BuildJobLogURL(nsName, jobName string) string {
+	return fmt.Sprintf("%s%s/namespaces/%s/builds/%s/log",
+		Conf.Cite.Host, Conf.Cite.ListenPort, nsName, jobName)
+}
+
+// BuildJobImageName returns the image name pushed by the build job.
+func BuildJobImageName(nsName string, meta *Metadata, sha string) string {
+	util := NewUtil()
+	tag := util.NormalizeGitBranch("-", meta.GitBranch, sha)
+	return fmt.Sprintf("%s/%s/%s:%s", Conf.Builder.Job.Registry, nsName, util.NormalizeByHyphen("", meta.GithubRepo), tag)
+}
+
+// CreateBuildJob creates a kaniko job building the commit of the service.
+// the commit is fetched by the ref, since commits of tags and of branch
+// triggers may not be reachable from the branch of the service.
+func (this *Kubernetes) CreateBuildJob(nsName string, meta *Metadata, ref, sha string) (*batch.Job, string, error) {
+	conf := Conf.Builder.Job
+	if len(conf.Image) == 0 || len(conf.Registry) == 0 {
+		return nil, "", fmt.Errorf("job builder is not configured")
+	}
+
+	githubHost, err := url.Parse(Conf.GitHub.Host)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid github host %s: %v", Conf.GitHub.Host, err)
+	}
+	imageName := BuildJobImageName(nsName, meta, sha)
+	shortSHA := sha
+	if len(shortSHA) > 7 {
+		shortSHA = shortSHA[:7]
+	}
+	// names must start and end alphanumeric, so long service names are cut
+	// instead of the suffix
+	suffix := fmt.Sprintf("-build-%s-%d", shortSHA, time.Now().Unix())
+	prefix := meta.Service
+	if len(prefix)+len(suffix) > 63 {
+		prefix = strings.TrimRight(prefix[:63-len(suffix)], "-")
+	}
+	jobName := prefix + suffix
+
+	container := api.Container{
+		Name:  "kaniko",
+		Image: conf.Image,
+		Args: []string{
+			fmt.Sprintf("--context=git://%s/%s/%s.git#%s#%s",
+				githubHost.Host, meta.GithubOrg, meta.GithubRepo, meta.BuildRef(ref), sha),
+			fmt.Sprintf("--destination=%s", imageName),
+		},
+	}
+	podSpec := api.PodSpec{
+		RestartPolicy: api.RestartPolicyNever,
+	}
+	if len(conf.GitSecret) > 0 {
+		for _, env := range []struct{ name, key string }{
+			{"GIT_USERNAME", "username"},
+			{"GIT_PASSWORD", "password"},
+		} {
+			container.Env = append(container.Env, api.EnvVar{
+				Name: env.name,
+				ValueFrom: &api.EnvVarSource{
+					SecretKeyRef: &api.SecretKeySelector{
+						LocalObjectReference: api.LocalObjectReference{Name: conf.GitSecret},
+						Key:                  env.key,
+					},
+				},
+			})
+		}
+	}
+	if len(conf.DockerSecret) > 0 {
+		container.VolumeMounts = []api.VolumeMount{{
+			Name:      "docker-config",
+			MountPath: "/kaniko/.docker",
+			ReadOnly:  true,
+		}}
+		podSpec.Volumes = []api.Volume{{
+			Name: "docker-config",
+			VolumeSource: api.VolumeSource{
+				Secret: &api.SecretVolumeSource{
+					SecretName: conf.DockerSecret,
+					Items: []api.KeyToPath{{
+						Key:  api.DockerConfigJsonKey,
+						Path: "config.json",
+					}},
+				},
+			},
+		}}
+	}
+	podSpec.Containers = []api.Container{container}
+
+	// build pods must not carry service labels, which select pods of the
+	// service and its deployment
+	labels := map[string]string{
+		BUILD_JOB_LABEL_KEY:     jobName,
+		BUILD_SERVICE_LABEL_KEY: meta.Service,
+		"sha":                   sha,
+	}
+	activeDeadline := int64(conf.Timeout)
+	if activeDeadline <= 0 {
+		activeDeadline = int64(this.pollTimeout.Seconds())
+	}
+	job, err := this.client.Batch().Jobs(nsName).Create(&batch.Job{
+		ObjectMeta: api.ObjectMeta{
+			Name:   jobName,
+			Labels: labels,
+		},
+		Spec: batch.JobSpec{
+			ActiveDeadlineSeconds: &activeDeadline,
+			Template: api.PodTemplateSpec{
+				ObjectMeta: api.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: podSpec,
+			},
+		},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return job, imageName, nil
+}
+
+var errBuildJobStopped = errors.New("build job stopped")
+
+// WaitForBuildJob waits until the job succeeds. the first failed pod fails
+// the build, since jobs retry failed pods until the deadline.
+// errBuildJobStopped is returned if the job is stopped by StopBuildJob.
+func (this *Kubernetes) WaitForBuildJob(job *batch.Job) error {
+	timeout := time.Duration(*job.Spec.ActiveDeadlineSeconds) * time.Second
+	ji := this.client.Batch().Jobs(job.Namespace)
+	return wait.Poll(this.pollInterval, timeout, func() (bool, error) {
+		j, err := ji.Get(job.Name)
+		if err != nil {
+			return false, err
+		}
+		if j.Status.Succeeded > 0 {
+			return true, nil
+		}
+		if j.Spec.Parallelism != nil && *j.Spec.Parallelism == 0 {
+			return false, errBuildJobStopped
+		}
+		if j.Status.Failed > 0 {
+			return false, fmt.Errorf("build pod failed")
+		}
+		for _, cond := range j.Status.Conditions {
+			if cond.Type == batch.JobFailed && cond.Status == api.ConditionTrue {
+				return false, fmt.Errorf("%s: %s", cond.Reason, cond.Message)
+			}
+		}
+		return false, nil
+	})
+}
+
+// GetBuildJob returns the build job of the name.
+func (this *Kubernetes) GetBuildJob(nsName, jobName string) (*batch.Job, error) {
+	return this.client.Batch().Jobs(nsName).Get(jobName)
+}
+
+// GetBuildJobLog returns the last lines of the log of the latest pod of the
+// build job. all lines are returned if tailLines is 0.
+func (this *Kubernetes) GetBuildJobLog(nsName, jobName string, tailLines int64) (string, error) {
+	pods, err := this.GetPods(nsName, map[string]string{BUILD_JOB_LABEL_KEY: jobName})
+	if err != nil {
+		return "", err
+	}
+	if len(pods) == 0 {
+		return "", fmt.Errorf("pod of build job %s/%s not found", nsName, jobName)
+	}
+	latest := pods[0]
+	for _, pod := range pods[1:] {
+		if latest.CreationTimestamp.Before(pod.CreationTimestamp) {
+			latest = pod
+		}
+	}
+
+	return this.GetPodLogs(nsName, latest.Name, PodLogOptions{TailLines: tailLines})
+}
+
+// StopBuildJob keeps the job from starting pods again. running pods are
+// killed, and pods are left for their logs.
+func (this *Kubernetes) StopBuildJob(nsName, jobName string) error {
+	ji := this.client.Batch().Jobs(nsName)
+	job, err := ji.Get(jobName)
+	if err != nil {
+		return err
+	}
+	parallelism := int32(0)
+	job.Spec.Parallelism = &parallelism
+	_, err = ji.Update(job)
+	return err
+}
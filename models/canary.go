@@ -0,0 +1,60 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+// Canary is kept in the service annotation while a canary deploy is in
+// progress. the service selects pods of both RCs by the "canary" label,
+// so traffic is split by the ratio of their replicas.
+type Canary struct {
+	Stable map[string]string `json:"stable"`
+	Canary map[string]string `json:"canary"`
+	Weight int               `json:"weight"`
+}
+
+// GetCanary returns the canary of svc, or nil if no canary is in progress.
+func GetCanary(svc *api.Service) (*Canary, error) {
+	canaryStr, ok := svc.Annotations[CITE_K8S_CANARY_ANNOTATION_KEY]
+	if !ok || canaryStr == "" {
+		return nil, nil
+	}
+	canary := &Canary{}
+	if err := json.Unmarshal([]byte(canaryStr), canary); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal canary annotation: %v", err)
+	}
+	return canary, nil
+}
+
+// SetCanary stores canary in the annotation of svc. nil canary removes it.
+func SetCanary(svc *api.Service, canary *Canary) error {
+	if canary == nil {
+		delete(svc.Annotations, CITE_K8S_CANARY_ANNOTATION_KEY)
+		return nil
+	}
+	b, err := json.Marshal(canary)
+	if err != nil {
+		return fmt.Errorf("failed to marshal canary annotation: %v", err)
+	}
+	if svc.Annotations == nil {
+		svc.Annotations = make(map[string]string)
+	}
+	svc.Annotations[CITE_K8S_CANARY_ANNOTATION_KEY] = string(b)
+	return nil
+}
+
+// CanaryReplicas returns the number of canary pods giving about weight
+// percent of traffic next to stable pods.
+func CanaryReplicas(stable, weight int) int {
+	replicas := (stable*weight + (100-weight)/2) / (100 - weight)
+	if replicas < 1 {
+		replicas = 1
+	}
+	if replicas > Conf.Kubernetes.MaxPods {
+		replicas = Conf.Kubernetes.MaxPods
+	}
+	return replicas
+}
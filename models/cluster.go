@@ -0,0 +1,107 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const CLUSTER_API_TIMEOUT = 60 * time.Second
+
+// Cluster is another kubernetes cluster run by its own cite. pipeline stages
+// on the cluster are read and deployed through the json api of its cite with
+// Token, an api token of a user who can push to the services of the stages.
+type Cluster struct {
+	Name  string
+	Host  string
+	Token string
+}
+
+// GetCluster returns the configured cluster of the name.
+func GetCluster(name string) (*Cluster, error) {
+	for i := range Conf.Clusters {
+		if Conf.Clusters[i].Name == name {
+			return &Conf.Clusters[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown cluster %s", name)
+}
+
+// GetService returns the service on the cluster. secrets are redacted by the
+// api.
+func (this *Cluster) GetService(nsName, svcName string) (*APIService, error) {
+	svc := new(APIService)
+	err := this.do(http.MethodGet, fmt.Sprintf("/namespaces/%s/services/%s", nsName, svcName), nil, svc)
+	return svc, err
+}
+
+// GetActiveDeployRecord returns the active deploy of the service on the
+// cluster, like Kubernetes.GetActiveDeployRecord does on this cluster.
+func (this *Cluster) GetActiveDeployRecord(nsName, svcName string) (*DeployRecord, error) {
+	svc, err := this.GetService(nsName, svcName)
+	if err != nil {
+		return nil, err
+	}
+	records := []DeployRecord{}
+	if err := this.do(http.MethodGet, fmt.Sprintf("/namespaces/%s/services/%s/history", nsName, svcName), nil, &records); err != nil {
+		return nil, err
+	}
+	if record := ActiveDeployRecord(records, svc.DeployID); record != nil {
+		return record, nil
+	}
+	return nil, fmt.Errorf("no active deploy. cluster:%s, ns:%s, svc:%s", this.Name, nsName, svcName)
+}
+
+// Deploy deploys the image to the service on the cluster. protected services
+// return the pending approval instead.
+func (this *Cluster) Deploy(nsName, svcName string, req APIDeployRequest) (*APIDeployResponse, error) {
+	res := new(APIDeployResponse)
+	err := this.do(http.MethodPost, fmt.Sprintf("/namespaces/%s/services/%s/deploys", nsName, svcName), req, res)
+	return res, err
+}
+
+// ServiceURL returns the page of the service on the cite of the cluster.
+func (this *Cluster) ServiceURL(nsName, svcName string) string {
+	return fmt.Sprintf("%s/namespaces/%s/services/%s", strings.TrimRight(this.Host, "/"), nsName, svcName)
+}
+
+func (this *Cluster) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		inJSON, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(inJSON)
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(this.Host, "/")+"/v2"+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "token "+this.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{Timeout: CLUSTER_API_TIMEOUT}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cluster %s: %v", this.Name, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= http.StatusBadRequest {
+		var apiErr APIError
+		if err := json.NewDecoder(res.Body).Decode(&apiErr); err != nil || len(apiErr.Message) == 0 {
+			return fmt.Errorf("cluster %s: %s %s: %s", this.Name, method, path, res.Status)
+		}
+		return fmt.Errorf("cluster %s: %s: %s", this.Name, res.Status, apiErr.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
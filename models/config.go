@@ -11,22 +11,46 @@ import (
 
 var (
 	Conf Config
+	// ConfigFile is the path of the loaded config, or empty if none is found.
+	// api clients like cmd/cite use models without config.
+	ConfigFile string
 )
 
 type Config struct {
 	Cite struct {
 		Host                string
 		ListenPort          string
+		Namespace           string
 		RCRetentionDuration string
 		Version             string
+		// keys signing and encrypting session cookies. random keys are
+		// generated if empty, which log users out on restarts.
+		SessionHashKey  string
+		SessionBlockKey string
 	}
 	Aggregator struct {
 		Host string
 	}
+	// Clusters are other kubernetes clusters run by their own cite, which
+	// pipeline stages may deploy to.
+	Clusters []Cluster
 	Buildbot struct {
 		Host    string
 		WebHook string
 	}
+	Builder struct {
+		Default string
+		Job     struct {
+			Image        string
+			Registry     string
+			DockerSecret string
+			GitSecret    string
+			Timeout      int
+		}
+	}
+	Docker struct {
+		AllowedRegistries []string
+	}
 	ElasticSearch struct {
 		Host       string
 		KibanaHost string
@@ -45,10 +69,16 @@ type Config struct {
 		Scope         string
 		Username      string
 		WebhookURI    string
+		WebhookSecret string
 	}
 	Grafana struct {
 		Host string
 	}
+	Preview struct {
+		TTL             string
+		MaxPerNamespace int
+		URL             string
+	}
 	Kubernetes struct {
 		Master          string
 		MaxPods         int
@@ -60,6 +90,12 @@ type Config struct {
 		DefaultMemory   string
 		MaxCPU          string
 		MaxMemory       string
+		WatchPeriod     int
+		MaxRestarts     int
+		Backend         string
+		// host paths under these may be mounted by services. none is
+		// allowed if empty.
+		AllowedHostPaths []string
 	}
 	Notification struct {
 		Watchcenter struct {
@@ -70,6 +106,13 @@ type Config struct {
 			ClientSecret string
 			RedirectURI  string
 		}
+		SMTP struct {
+			Host     string
+			Port     int
+			Username string
+			Password string
+			From     string
+		}
 		Default struct {
 			Slack string
 		}
@@ -83,9 +126,13 @@ func init() {
 	} {
 		if _, err := os.Stat(path); err == nil {
 			viper.SetConfigFile(path)
+			ConfigFile = path
 			break
 		}
 	}
+	if len(ConfigFile) == 0 {
+		return
+	}
 
 	err = viper.ReadInConfig()
 	if err != nil {
@@ -0,0 +1,203 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	gologging "github.com/op/go-logging"
+	"k8s.io/kubernetes/pkg/api"
+	k8sErrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/intstr"
+	"k8s.io/kubernetes/pkg/util/wait"
+)
+
+const (
+	DEPLOYMENT_REVISION_ANNOTATION_KEY     = "deployment.kubernetes.io/revision"
+	DEPLOYMENT_CHANGE_CAUSE_ANNOTATION_KEY = "kubernetes.io/change-cause"
+	DEPLOYMENT_REVISION_HISTORY_LIMIT      = 10
+)
+
+func (this *Kubernetes) GetDeployment(nsName, name string) (*extensions.Deployment, error) {
+	return this.client.Extensions().Deployments(nsName).Get(name)
+}
+
+// UpsertDeployment creates the deployment of the service or rolls out a new
+// revision of it. podLabels should carry sha and deploy_id of the revision.
+func (this *Kubernetes) UpsertDeployment(nsName string, meta *Metadata, imageName string, podLabels map[string]string, changeCause string) (*extensions.Deployment, error) {
+	logger.Info(fmt.Sprintf("upsert deployment. ns:%s, deployment:%s, image:%s", nsName, meta.Service, imageName))
+
+	podSpec, err := this.getPodSpec(meta.Service, imageName, meta.EnvironmentMap(), meta.SecretEnvKeys, meta.Service, meta.Volumes, meta.Resources,
+		meta.ContainerPorts, meta.HTTPProbePort(), meta.ProbePath, meta.Probe)
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range podSpec.Containers {
+		podSpec.Containers[i].ReadinessProbe = getReadinessProbe(c.LivenessProbe, Conf.Kubernetes.MinInitialDelay)
+	}
+
+	// blue-green keeps all previous pods until the new ones are ready
+	rollingUpdate := &extensions.RollingUpdateDeployment{
+		MaxSurge:       intstr.FromString("100%"),
+		MaxUnavailable: intstr.FromInt(0),
+	}
+	if meta.DeployStrategy.Type == DEPLOY_STRATEGY_ROLLING {
+		rollingUpdate = &extensions.RollingUpdateDeployment{
+			MaxSurge:       intstr.FromInt(meta.DeployStrategy.MaxSurge),
+			MaxUnavailable: intstr.FromInt(meta.DeployStrategy.MaxUnavailable),
+		}
+	}
+
+	labels := this.GetServiceLabels(meta)
+	revisionHistoryLimit := int32(DEPLOYMENT_REVISION_HISTORY_LIMIT)
+	spec := extensions.DeploymentSpec{
+		Replicas: int32(meta.Replicas),
+		Selector: &unversioned.LabelSelector{MatchLabels: labels},
+		Template: api.PodTemplateSpec{
+			ObjectMeta: api.ObjectMeta{
+				Labels: podLabels,
+			},
+			Spec: *podSpec,
+		},
+		Strategy: extensions.DeploymentStrategy{
+			Type:          extensions.RollingUpdateDeploymentStrategyType,
+			RollingUpdate: rollingUpdate,
+		},
+		RevisionHistoryLimit: &revisionHistoryLimit,
+	}
+
+	di := this.client.Extensions().Deployments(nsName)
+	deployment, err := di.Get(meta.Service)
+	if k8sErrors.IsNotFound(err) {
+		return di.Create(&extensions.Deployment{
+			ObjectMeta: api.ObjectMeta{
+				Name:   meta.Service,
+				Labels: labels,
+				Annotations: map[string]string{
+					DEPLOYMENT_CHANGE_CAUSE_ANNOTATION_KEY: changeCause,
+				},
+			},
+			Spec: spec,
+		})
+	} else if err != nil {
+		return nil, err
+	}
+
+	if deployment.Annotations == nil {
+		deployment.Annotations = make(map[string]string)
+	}
+	deployment.Annotations[DEPLOYMENT_CHANGE_CAUSE_ANNOTATION_KEY] = changeCause
+	deployment.Spec = spec
+	return di.Update(deployment)
+}
+
+// WaitForDeployment waits until all pods of the latest revision are ready
+// and pods of previous revisions are gone.
+func (this *Kubernetes) WaitForDeployment(deployment *extensions.Deployment, podSelector map[string]string, fluentLogger *gologging.Logger) error {
+	di := this.client.Extensions().Deployments(deployment.Namespace)
+	podsReady := this.podsReady(deployment.Namespace, podSelector, deployment.Spec.Replicas, fluentLogger, nil, nil)
+	return wait.Poll(this.pollInterval, this.pollTimeout, func() (bool, error) {
+		d, err := di.Get(deployment.Name)
+		if err != nil {
+			return false, err
+		}
+		if d.Status.ObservedGeneration < d.Generation {
+			return false, nil
+		}
+		if d.Status.UpdatedReplicas < d.Spec.Replicas || d.Status.Replicas > d.Spec.Replicas {
+			return false, nil
+		}
+		return podsReady()
+	})
+}
+
+func (this *Kubernetes) ScaleDeployment(nsName, name string, replicas int) (*extensions.Deployment, error) {
+	di := this.client.Extensions().Deployments(nsName)
+	deployment, err := di.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	deployment.Spec.Replicas = int32(replicas)
+	return di.Update(deployment)
+}
+
+// RollbackDeployment rolls the deployment back to the given revision, or to
+// the previous revision if revision is 0.
+func (this *Kubernetes) RollbackDeployment(nsName, name string, revision int64) error {
+	return this.client.Extensions().Deployments(nsName).Rollback(&extensions.DeploymentRollback{
+		Name: name,
+		RollbackTo: extensions.RollbackConfig{
+			Revision: revision,
+		},
+	})
+}
+
+// GetDeploymentRevisions returns replica sets of the deployment, latest
+// revision first.
+func (this *Kubernetes) GetDeploymentRevisions(deployment *extensions.Deployment) ([]extensions.ReplicaSet, error) {
+	sel, err := unversioned.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	rsl, err := this.client.Extensions().ReplicaSets(deployment.Namespace).List(api.ListOptions{LabelSelector: sel})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(replicaSetsByRevision(rsl.Items))
+	return rsl.Items, nil
+}
+
+func (this *Kubernetes) DeleteDeployment(nsName, name string) error {
+	deployment, err := this.GetDeployment(nsName, name)
+	if err != nil {
+		return err
+	}
+
+	// list revisions before delete deployment
+	rss, err := this.GetDeploymentRevisions(deployment)
+	if err != nil {
+		return err
+	}
+
+	// delete deployment
+	err = this.client.Extensions().Deployments(nsName).Delete(name, nil)
+	if err != nil {
+		return err
+	}
+
+	// delete replica sets
+	for _, rs := range rss {
+		err := this.client.Extensions().ReplicaSets(nsName).Delete(rs.Name, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	// delete deployment pods
+	pods, err := this.GetPods(nsName, deployment.Spec.Selector.MatchLabels)
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		err := this.DeletePod(nsName, pod.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func DeploymentRevision(rs extensions.ReplicaSet) int64 {
+	revision, _ := strconv.ParseInt(rs.Annotations[DEPLOYMENT_REVISION_ANNOTATION_KEY], 10, 64)
+	return revision
+}
+
+type replicaSetsByRevision []extensions.ReplicaSet
+
+func (this replicaSetsByRevision) Len() int      { return len(this) }
+func (this replicaSetsByRevision) Swap(i, j int) { this[i], this[j] = this[j], this[i] }
+func (this replicaSetsByRevision) Less(i, j int) bool {
+	return DeploymentRevision(this[i]) > DeploymentRevision(this[j])
+}
@@ -36,6 +36,28 @@ func NewDocker() *Docker {
 	return dockerInst
 }
 
+// CheckRegistry returns an error unless the image comes from one of
+// Docker.AllowedRegistries, or the registry of the job builder. an allowed
+// registry may include a path, e.g. "docker.io/myorg", and images without a
+// registry are of docker.io.
+func (this *Docker) CheckRegistry(imageName string) error {
+	name := imageName
+	if i := strings.Index(name, "/"); i < 0 || !strings.ContainsAny(name[:i], ".:") && name[:i] != "localhost" {
+		name = "docker.io/" + name
+	}
+	registries := Conf.Docker.AllowedRegistries
+	if len(Conf.Builder.Job.Registry) > 0 {
+		registries = append([]string{Conf.Builder.Job.Registry}, registries...)
+	}
+	for _, registry := range registries {
+		registry = strings.TrimRight(registry, "/")
+		if len(registry) > 0 && strings.HasPrefix(name, registry+"/") {
+			return nil
+		}
+	}
+	return fmt.Errorf("image %s is not from allowed registries %v", imageName, registries)
+}
+
 func (this *Docker) CheckImage(imageName string) bool {
 	i := strings.SplitN(imageName, "/", 2)
 	repo := i[0]
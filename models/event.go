@@ -0,0 +1,143 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// EventKind is the kind of a notification message.
+type EventKind string
+
+const (
+	EVENT_BUILD_STARTED  EventKind = "build_started"
+	EVENT_BUILD_SUCCESS  EventKind = "build_success"
+	EVENT_BUILD_FAILURE  EventKind = "build_failure"
+	EVENT_DEPLOY_STARTED EventKind = "deploy_started"
+	EVENT_DEPLOY_SUCCESS EventKind = "deploy_success"
+	EVENT_DEPLOY_FAILURE EventKind = "deploy_failure"
+	EVENT_CANARY_STARTED EventKind = "canary_started"
+
+	EVENT_APPROVAL_REQUESTED EventKind = "approval_requested"
+	EVENT_APPROVAL_APPROVED  EventKind = "approval_approved"
+	EVENT_APPROVAL_REJECTED  EventKind = "approval_rejected"
+	EVENT_APPROVAL_EXPIRED   EventKind = "approval_expired"
+)
+
+var EventKinds = []EventKind{
+	EVENT_BUILD_STARTED,
+	EVENT_BUILD_SUCCESS,
+	EVENT_BUILD_FAILURE,
+	EVENT_DEPLOY_STARTED,
+	EVENT_DEPLOY_SUCCESS,
+	EVENT_DEPLOY_FAILURE,
+	EVENT_CANARY_STARTED,
+	EVENT_APPROVAL_REQUESTED,
+	EVENT_APPROVAL_APPROVED,
+	EVENT_APPROVAL_REJECTED,
+	EVENT_APPROVAL_EXPIRED,
+}
+
+func (this EventKind) Valid() bool {
+	for _, kind := range EventKinds {
+		if this == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// State returns the message state of the event, which drivers use to
+// decorate messages.
+func (this EventKind) State() string {
+	switch this {
+	case EVENT_BUILD_SUCCESS, EVENT_DEPLOY_SUCCESS, EVENT_APPROVAL_APPROVED:
+		return MESSAGE_STATE_SUCCESS
+	case EVENT_BUILD_FAILURE, EVENT_DEPLOY_FAILURE, EVENT_APPROVAL_REJECTED, EVENT_APPROVAL_EXPIRED:
+		return MESSAGE_STATE_FAILURE
+	default:
+		return MESSAGE_STATE_PENDING
+	}
+}
+
+// EventTemplate is a text/template rendering the text of an event message.
+// the message is passed as data, e.g. "{{.Service}} failed: {{.Text}}".
+type EventTemplate struct {
+	Event    EventKind `json:"event" schema:"event"`
+	Template string    `json:"template" schema:"template"`
+}
+
+// Subscribed returns whether the notification receives the event. empty
+// Events subscribes to all events, and messages without event are sent to
+// all notifications.
+func (this Notification) Subscribed(event EventKind) bool {
+	if len(this.Events) == 0 || len(event) == 0 {
+		return true
+	}
+	for _, e := range this.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Template returns the template of the event, or empty string if the
+// notification uses the default text.
+func (this Notification) Template(event EventKind) string {
+	for _, tpl := range this.Templates {
+		if tpl.Event == event {
+			return tpl.Template
+		}
+	}
+	return ""
+}
+
+// Render returns the message with text rendered by the template of its
+// event. the default text is kept if the template fails.
+func (this Notification) Render(msg Message) Message {
+	tplStr := this.Template(msg.Event)
+	if len(tplStr) == 0 {
+		return msg
+	}
+	tpl, err := template.New(string(msg.Event)).Parse(tplStr)
+	if err != nil {
+		logger.Errorf("invalid %s template of %s notification: %v", msg.Event, this.Driver, err)
+		return msg
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, msg); err != nil {
+		logger.Errorf("failed to render %s template of %s notification: %v", msg.Event, this.Driver, err)
+		return msg
+	}
+	msg.Text = buf.String()
+	return msg
+}
+
+func (this Notification) validateEvents() error {
+	for _, event := range this.Events {
+		if !event.Valid() {
+			return fmt.Errorf("unknown event %s", event)
+		}
+	}
+	for _, tpl := range this.Templates {
+		if !tpl.Event.Valid() {
+			return fmt.Errorf("unknown event %s", tpl.Event)
+		}
+		if _, err := template.New(string(tpl.Event)).Parse(tpl.Template); err != nil {
+			return fmt.Errorf("invalid %s template: %v", tpl.Event, err)
+		}
+	}
+	return nil
+}
+
+// compactTemplates drops empty templates posted from the form.
+func (this *Notification) compactTemplates() {
+	templates := []EventTemplate{}
+	for _, tpl := range this.Templates {
+		if len(tpl.Template) > 0 {
+			templates = append(templates, tpl)
+		}
+	}
+	this.Templates = templates
+}
@@ -79,6 +79,10 @@ func (this *GitHub) GetLoginURL() string {
 	return this.oauthConf.AuthCodeURL(this.oauthStateString, oauth2.AccessTypeOnline)
 }
 
+func (this *GitHub) GetCommitURL(owner, repo, sha string) string {
+	return fmt.Sprintf("%s/%s/%s/commit/%s", Conf.GitHub.Host, owner, repo, sha)
+}
+
 func (this *GitHub) GetOAuth2Token(state, code string) (string, error) {
 	if this.oauthStateString != state {
 		return "", fmt.Errorf(
@@ -221,6 +225,23 @@ func (this *GitHub) GetSHA(owner, repo, branch string) (string, error) {
 	return *br.Commit.SHA, nil
 }
 
+// GetTagSHA returns the commit of the tag. annotated tags are resolved to
+// the commits they point.
+func (this *GitHub) GetTagSHA(owner, repo, tag string) (string, error) {
+	ref, _, err := this.client.Git.GetRef(owner, repo, "tags/"+tag)
+	if err != nil {
+		return "", err
+	}
+	if ref.Object.Type != nil && *ref.Object.Type == "tag" {
+		annotated, _, err := this.client.Git.GetTag(owner, repo, *ref.Object.SHA)
+		if err != nil {
+			return "", err
+		}
+		return *annotated.Object.SHA, nil
+	}
+	return *ref.Object.SHA, nil
+}
+
 func (this *GitHub) CheckDockerfile(owner string, repo string) (bool, error) {
 	branches, err := this.ListBranches(owner, repo, &github.ListOptions{
 		PerPage: 100,
@@ -250,7 +271,38 @@ func (this *GitHub) CreateCommitStatus(owner, repo, ref, statusStr string) {
 	}
 }
 
+func (this *GitHub) CreateBuildStatus(owner, repo, ref, state, context, description, targetURL string) {
+	req := &github.RepoStatus{
+		State:       github.String(state),
+		Context:     github.String(context),
+		Description: github.String(description),
+	}
+	if len(targetURL) > 0 {
+		req.TargetURL = github.String(targetURL)
+	}
+	if _, _, err := this.client.Repositories.CreateStatus(owner, repo, ref, req); err != nil {
+		logger.Warningf("failed to create build status on %s/%s:%s: %v", owner, repo, ref, err)
+	}
+}
+
+// CreateComment comments on the issue or the pull request.
+func (this *GitHub) CreateComment(owner, repo string, number int, body string) error {
+	req := &github.IssueComment{
+		Body: github.String(body),
+	}
+	_, _, err := this.client.Issues.CreateComment(owner, repo, number, req)
+	return err
+}
+
+// UpsertHook creates or updates webhooks of the repo. hooks are signed by the
+// webhook secret, which cite verifies on every event.
 func (this *GitHub) UpsertHook(owner, repo string) error {
+	secret, err := WebhookSecret()
+	if err != nil {
+		logger.Error(fmt.Sprintf("failed to get webhook secret: %v", err))
+		return err
+	}
+
 	hooks := map[string]*github.Hook{
 		"buildbot": &github.Hook{
 			Name:   github.String("web"),
@@ -258,14 +310,16 @@ func (this *GitHub) UpsertHook(owner, repo string) error {
 			Config: map[string]interface{}{
 				"url":          github.String(Conf.Buildbot.WebHook),
 				"content_type": github.String("json"),
+				"secret":       github.String(secret),
 			},
 		},
 		"cite": &github.Hook{
 			Name:   github.String("web"),
-			Events: []string{"status"},
+			Events: []string{"status", "pull_request", "create", "release"},
 			Config: map[string]interface{}{
 				"url":          github.String(Conf.Cite.Host + Conf.Cite.ListenPort + Conf.GitHub.WebhookURI),
 				"content_type": github.String("json"),
+				"secret":       github.String(secret),
 			},
 		},
 	}
@@ -310,14 +364,19 @@ func (this *GitHub) ListHooks(owner, repo string) ([]github.Hook, error) {
 	return hooks, err
 }
 
-func (this *GitHub) CreateDeployment(owner, repo, ref, description string) (int, error) {
-	logger.Info(fmt.Sprintf("create deployment. owner:%s, repo:%s, ref:%s", owner, repo, ref))
+// CreateDeployment creates a github deployment of the ref. empty environment
+// is the default environment of github, production.
+func (this *GitHub) CreateDeployment(owner, repo, ref, environment, description string) (int, error) {
+	logger.Info(fmt.Sprintf("create deployment. owner:%s, repo:%s, ref:%s, environment:%s", owner, repo, ref, environment))
 	req := &github.DeploymentRequest{
 		AutoMerge:        github.Bool(false),
 		Ref:              github.String(ref),
 		Description:      github.String(description),
 		RequiredContexts: &[]string{},
 	}
+	if len(environment) > 0 {
+		req.Environment = github.String(environment)
+	}
 	deployment, _, err := this.client.Repositories.CreateDeployment(owner, repo, req)
 	if err != nil {
 		return -1, err
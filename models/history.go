@@ -0,0 +1,207 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	k8sErrors "k8s.io/kubernetes/pkg/api/errors"
+)
+
+const (
+	DEPLOY_HISTORY_CONFIGMAP = "cite-deploy-history"
+	DEPLOY_HISTORY_LIMIT     = 100
+	DEPLOY_HISTORY_RETRIES   = 3
+
+	DEPLOY_RESULT_RUNNING     = "running"
+	DEPLOY_RESULT_SUCCESS     = "success"
+	DEPLOY_RESULT_FAILURE     = "failure"
+	DEPLOY_RESULT_ROLLED_BACK = "rolled_back"
+)
+
+// DeployRecord is a single deploy of a service. records are kept in a
+// configmap per namespace, so the history survives github outages and repo
+// transfers.
+type DeployRecord struct {
+	DeployID    int        `json:"deploy_id"`
+	SHA         string     `json:"sha"`
+	Image       string     `json:"image"`
+	TriggeredBy string     `json:"triggered_by"`
+	Strategy    string     `json:"strategy"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	Result      string     `json:"result"`
+	Error       string     `json:"error,omitempty"`
+	// Approvals of protected services, the deploy first and activations
+	// of the deploy after.
+	Approvals []Approval `json:"approvals,omitempty"`
+}
+
+var historyMutex sync.Mutex
+
+// GetDeployRecords returns deploy records of the service, latest first.
+func (this *Kubernetes) GetDeployRecords(nsName, svcName string) ([]DeployRecord, error) {
+	records := []DeployRecord{}
+	err := this.getRecords(nsName, DEPLOY_HISTORY_CONFIGMAP, svcName, &records)
+	return records, err
+}
+
+// AddDeployRecord prepends the record to the history of the service and
+// drops records beyond DEPLOY_HISTORY_LIMIT.
+func (this *Kubernetes) AddDeployRecord(nsName, svcName string, record DeployRecord) error {
+	records := []DeployRecord{}
+	return this.updateRecords(nsName, DEPLOY_HISTORY_CONFIGMAP, svcName, &records, func() error {
+		records = append([]DeployRecord{record}, records...)
+		if len(records) > DEPLOY_HISTORY_LIMIT {
+			records = records[:DEPLOY_HISTORY_LIMIT]
+		}
+		return nil
+	})
+}
+
+// FinishDeployRecord sets the result of the deploy and its end time.
+func (this *Kubernetes) FinishDeployRecord(nsName, svcName string, deployID int, result, errMsg string) error {
+	records := []DeployRecord{}
+	return this.updateRecords(nsName, DEPLOY_HISTORY_CONFIGMAP, svcName, &records, func() error {
+		for i := range records {
+			if records[i].DeployID == deployID {
+				now := time.Now()
+				records[i].FinishedAt = &now
+				records[i].Result = result
+				records[i].Error = errMsg
+				return nil
+			}
+		}
+		return fmt.Errorf("deploy record not found. ns:%s, svc:%s, deploy_id:%d", nsName, svcName, deployID)
+	})
+}
+
+// AddDeployApproval appends the approval to the record of the deploy.
+func (this *Kubernetes) AddDeployApproval(nsName, svcName string, deployID int, approval Approval) error {
+	records := []DeployRecord{}
+	return this.updateRecords(nsName, DEPLOY_HISTORY_CONFIGMAP, svcName, &records, func() error {
+		for i := range records {
+			if records[i].DeployID == deployID {
+				records[i].Approvals = append(records[i].Approvals, approval)
+				return nil
+			}
+		}
+		return fmt.Errorf("deploy record not found. ns:%s, svc:%s, deploy_id:%d", nsName, svcName, deployID)
+	})
+}
+
+// GetActiveDeployRecord returns the successful deploy which the service
+// routes to, or the latest successful deploy if the service selects no
+// deploy.
+func (this *Kubernetes) GetActiveDeployRecord(nsName, svcName string) (*DeployRecord, error) {
+	svc, _, err := this.GetService(nsName, svcName)
+	if err != nil {
+		return nil, err
+	}
+	records, err := this.GetDeployRecords(nsName, svcName)
+	if err != nil {
+		return nil, err
+	}
+	if record := ActiveDeployRecord(records, svc.Spec.Selector["deploy_id"]); record != nil {
+		return record, nil
+	}
+	return nil, fmt.Errorf("no active deploy. ns:%s, svc:%s", nsName, svcName)
+}
+
+// ActiveDeployRecord returns the successful deploy of activeID among records,
+// or the latest successful one if activeID is empty. nil is returned if none
+// is found.
+func ActiveDeployRecord(records []DeployRecord, activeID string) *DeployRecord {
+	for i := range records {
+		if records[i].Result != DEPLOY_RESULT_SUCCESS {
+			continue
+		}
+		if len(activeID) == 0 || strconv.Itoa(records[i].DeployID) == activeID {
+			return &records[i]
+		}
+	}
+	return nil
+}
+
+// getRecords reads records of the service from the configmap into records,
+// a pointer to a slice. records are left untouched if there are none.
+func (this *Kubernetes) getRecords(nsName, cmName, svcName string, records interface{}) error {
+	cm, err := this.client.ConfigMaps(nsName).Get(cmName)
+	if k8sErrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return unmarshalRecords(cm, svcName, records)
+}
+
+// updateRecords reads records of the service from the configmap into
+// records, calls update and writes records back. the configmap is created if
+// it does not exist, and the update is retried on conflicts.
+func (this *Kubernetes) updateRecords(nsName, cmName, svcName string, records interface{}, update func() error) error {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	ci := this.client.ConfigMaps(nsName)
+	for i := 0; i < DEPLOY_HISTORY_RETRIES; i++ {
+		cm, getErr := ci.Get(cmName)
+		exists := getErr == nil
+		if !exists {
+			if !k8sErrors.IsNotFound(getErr) {
+				return getErr
+			}
+			cm = &api.ConfigMap{
+				ObjectMeta: api.ObjectMeta{
+					Name: cmName,
+				},
+			}
+		}
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+
+		if err := unmarshalRecords(cm, svcName, records); err != nil {
+			return err
+		}
+		if err := update(); err != nil {
+			return err
+		}
+		recordsJSON, err := json.Marshal(records)
+		if err != nil {
+			return err
+		}
+		cm.Data[svcName] = string(recordsJSON)
+
+		if exists {
+			_, err = ci.Update(cm)
+		} else {
+			_, err = ci.Create(cm)
+		}
+		// retry when someone else wrote the configmap in the meantime
+		if err == nil || !(k8sErrors.IsConflict(err) || k8sErrors.IsAlreadyExists(err)) {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to update %s due to conflicts. ns:%s, svc:%s", cmName, nsName, svcName)
+}
+
+// unmarshalRecords replaces records with those of the service, or with an
+// empty slice if the service has no records yet.
+func unmarshalRecords(cm *api.ConfigMap, svcName string, records interface{}) error {
+	// json reuses elements of the slice, which would keep fields omitted
+	// from the data
+	v := reflect.ValueOf(records).Elem()
+	v.Set(reflect.Zero(v.Type()))
+	recordsJSON, ok := cm.Data[svcName]
+	if !ok {
+		recordsJSON = "[]"
+	}
+	if err := json.Unmarshal([]byte(recordsJSON), records); err != nil {
+		return fmt.Errorf("failed to unmarshal %s. ns:%s, svc:%s: %v", cm.Name, cm.Namespace, svcName, err)
+	}
+	return nil
+}
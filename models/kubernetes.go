@@ -2,16 +2,20 @@ package models
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"regexp"
+	"strconv"
 	"sync"
 	"time"
 
 	gologging "github.com/op/go-logging"
 	"k8s.io/kubernetes/pkg/api"
+	k8sErrors "k8s.io/kubernetes/pkg/api/errors"
 	"k8s.io/kubernetes/pkg/api/resource"
 	"k8s.io/kubernetes/pkg/client/restclient"
 	k8sClient "k8s.io/kubernetes/pkg/client/unversioned"
@@ -21,6 +25,13 @@ import (
 	"k8s.io/kubernetes/pkg/util/wait"
 )
 
+const (
+	POD_LOG_TIMEOUT        = 30 * time.Second
+	POD_LOG_FOLLOW_TIMEOUT = 30 * time.Minute
+	POD_LOG_LIMIT_BYTES    = 10 * 1024 * 1024
+	POD_LOG_RETRIES        = 3
+)
+
 type Kubernetes struct {
 	client       *k8sClient.Client
 	util         *Util
@@ -65,6 +76,17 @@ func (this *Kubernetes) GetLabels(githubRepo, gitBranch string) map[string]strin
 	}
 }
 
+// GetServiceLabels returns labels of objects of the service. preview
+// services of pull requests are labeled with the pull request number, so
+// that they never select objects of the branch services.
+func (this *Kubernetes) GetServiceLabels(meta *Metadata) map[string]string {
+	svcLabels := this.GetLabels(meta.GithubRepo, meta.GitBranch)
+	if meta.PullRequest > 0 {
+		svcLabels[PREVIEW_LABEL] = strconv.Itoa(meta.PullRequest)
+	}
+	return svcLabels
+}
+
 func (this *Kubernetes) GetAllNamespaces() ([]api.Namespace, error) {
 	nl, err := this.client.Namespaces().List(api.ListOptions{})
 	return nl.Items, err
@@ -152,6 +174,22 @@ func (this *Kubernetes) GetServices(namespace string, labelMap map[string]string
 	return sl.Items, nil
 }
 
+// GetBranchServices returns services of the labels excluding previews, which
+// have the labels of their head branch.
+func (this *Kubernetes) GetBranchServices(namespace string, labelMap map[string]string) ([]api.Service, error) {
+	sel := labels.Set(labelMap).AsSelector()
+	for _, key := range []string{"type", PREVIEW_LABEL} {
+		requirement, _ := labels.NewRequirement(key, labels.DoesNotExistOperator, sets.NewString())
+		sel = sel.Add(*requirement)
+	}
+	sl, err := this.client.Services(namespace).List(api.ListOptions{LabelSelector: sel})
+	if err != nil {
+		return nil, err
+	}
+
+	return sl.Items, nil
+}
+
 func (this *Kubernetes) GetService(nsName, svcName string) (*api.Service, *Metadata, error) {
 	svc, err := this.client.Services(nsName).Get(svcName)
 	if err != nil {
@@ -170,6 +208,9 @@ func (this *Kubernetes) GetService(nsName, svcName string) (*api.Service, *Metad
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to unmarshal annotation: %v", err)
 	}
+	if err := this.LoadNotificationTokens(nsName, meta); err != nil {
+		logger.Warningf("failed to load notification tokens. ns:%s, svc:%s: %v", nsName, svc.Name, err)
+	}
 
 	return svc, meta, nil
 }
@@ -185,7 +226,6 @@ func (this *Kubernetes) UpsertService(nsName, svcName string,
 
 	svcLabels["loadbalancer"] = Conf.LoadBalancer.Driver
 
-	var svc *api.Service
 	svci := this.client.Services(nsName)
 
 	// create service ports
@@ -203,9 +243,14 @@ func (this *Kubernetes) UpsertService(nsName, svcName string,
 		}
 	}
 
-	svcs, err := this.GetServices(nsName, svcLabels)
+	// services are looked up by name, since previews share labels of the
+	// branch services of their head branch
+	svc, err := svci.Get(svcName)
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return nil, err
+	}
 
-	if err != nil || len(svcs) < 1 {
+	if err != nil {
 		// create service if not exist
 
 		svcAnnotations := make(map[string]string)
@@ -234,21 +279,13 @@ func (this *Kubernetes) UpsertService(nsName, svcName string,
 			logger.Error("error on create k8s Service:", err)
 			return svc, err
 		}
-	} else if len(svcs) > 1 {
-		// return error if multiple services found
-		svcNames := make([]string, len(svcs))
-		for i, svc := range svcs {
-			svcNames[i] = svc.Name
-		}
-		err = fmt.Errorf("multiple services found: %v", svcNames)
-		logger.Error(err)
-		return svc, err
 	} else {
 		// update service
-		svc = &svcs[0]
 		if annotations != "" {
 			svc.Annotations[CITE_K8S_ANNOTATION_KEY] = annotations
 		}
+		// a canary in progress is replaced by the new selector
+		delete(svc.Annotations, CITE_K8S_CANARY_ANNOTATION_KEY)
 		svc.Spec.Ports = svcPorts
 		svc.Spec.Selector = svcSelector
 		svc, err = svci.Update(svc)
@@ -301,6 +338,28 @@ func (this *Kubernetes) DeleteService(nsName, svcName string) error {
 		}
 	}
 
+	// delete svc deployment
+	if _, err := this.GetDeployment(nsName, svcName); err == nil {
+		if err := this.DeleteDeployment(nsName, svcName); err != nil {
+			return err
+		}
+	}
+
+	// delete svc autoscaler
+	if err := this.DeleteHPA(nsName, svcName); err != nil {
+		return err
+	}
+
+	// delete svc secret env
+	if err := this.DeleteSecretEnv(nsName, svcName); err != nil {
+		return err
+	}
+
+	// delete svc notification tokens
+	if err := this.DeleteNotificationTokens(nsName, svcName); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -333,43 +392,18 @@ func (this *Kubernetes) UpdateReplicationController(nsName string, rc *api.Repli
 	return this.client.ReplicationControllers(nsName).Update(rc)
 }
 
-func (this *Kubernetes) UpsertReplicationController(nsName, rcGenerateName, imageName string, rcLabels, rcSelector map[string]string, environment map[string]string, replicas int, ports []int, probePath string, deployID int, fluentLogger *gologging.Logger) error {
+func (this *Kubernetes) UpsertReplicationController(nsName, rcGenerateName, imageName string, rcLabels, rcSelector map[string]string, environment map[string]string, secretEnvKeys []string, svcName string, volumes []Volume, resources Resources, replicas int, ports []int, httpPort int, probePath string, probe Probe, deployID int, fluentLogger *gologging.Logger) (*api.ReplicationController, error) {
 	logger.Info(fmt.Sprintf("upsert replication controller. ns:%s, rc:%s, env:%v", nsName, rcGenerateName, environment))
 
 	var rc *api.ReplicationController
 	rci := this.client.ReplicationControllers(nsName)
 
-	// create container ports
-	containerPorts := make([]api.ContainerPort, len(ports))
-	for i, port := range ports {
-		containerPorts[i] = api.ContainerPort{
-			Name:          fmt.Sprintf("port%d", port),
-			ContainerPort: int32(port),
-		}
+	podSpec, err := this.getPodSpec(rcGenerateName, imageName, environment, secretEnvKeys, svcName, volumes, resources, ports, httpPort, probePath, probe)
+	if err != nil {
+		return nil, err
 	}
 
 	// define ReplicationController spec
-	if probePath == "" {
-		probePath = "/"
-	}
-
-	containerImage := imageName
-	var containerEnvVars []api.EnvVar
-	for k, v := range environment {
-		containerEnvVars = append(containerEnvVars, api.EnvVar{
-			Name:  k,
-			Value: v,
-		})
-	}
-
-	resourceRequests := make(api.ResourceList)
-	resourceRequests[api.ResourceCPU] = resource.MustParse(Conf.Kubernetes.DefaultCPU)
-	resourceRequests[api.ResourceMemory] = resource.MustParse(Conf.Kubernetes.DefaultMemory)
-
-	resourceLimits := make(api.ResourceList)
-	resourceLimits[api.ResourceCPU] = resource.MustParse(Conf.Kubernetes.MaxCPU)
-	resourceLimits[api.ResourceMemory] = resource.MustParse(Conf.Kubernetes.MaxMemory)
-
 	rcSpec := &api.ReplicationController{
 		ObjectMeta: api.ObjectMeta{
 			GenerateName: rcGenerateName,
@@ -383,42 +417,23 @@ func (this *Kubernetes) UpsertReplicationController(nsName, rcGenerateName, imag
 					Name:   rcGenerateName,
 					Labels: rcLabels,
 				},
-				Spec: api.PodSpec{
-					Containers: []api.Container{
-						api.Container{
-							Name:  rcGenerateName,
-							Env:   containerEnvVars,
-							Image: containerImage,
-							Ports: containerPorts,
-							LivenessProbe: &api.Probe{
-								Handler: api.Handler{
-									TCPSocket: &api.TCPSocketAction{
-										Port: intstr.FromInt(ports[0]),
-									},
-								},
-								InitialDelaySeconds: int32(this.pollTimeout.Seconds()),
-								TimeoutSeconds:      int32(this.pollTimeout.Seconds()),
-							},
-							Resources: api.ResourceRequirements{
-								Requests: resourceRequests,
-								Limits:   resourceLimits,
-							},
-						},
-					},
-				},
+				Spec: *podSpec,
 			},
 		},
 	}
 
 	// create ReplicationController
-	rc, err := rci.Create(rcSpec)
+	rc, err = rci.Create(rcSpec)
 	if err != nil {
 		logger.Error("error on k8s ReplicationController create:", err)
-		return err
+		return nil, err
 	}
 
 	// wait for Pods
 	logMsg := fmt.Sprintf("wait for pod ready status: trying to connect port %d", ports[0])
+	if probeHandler := podSpec.Containers[0].LivenessProbe.Handler; probeHandler.HTTPGet != nil {
+		logMsg = fmt.Sprintf("wait for pod ready status: trying to get http://:%d%s", httpPort, probePath)
+	}
 	if fluentLogger != nil {
 		fluentLogger.Info(logMsg)
 	} else {
@@ -432,9 +447,9 @@ func (this *Kubernetes) UpsertReplicationController(nsName, rcGenerateName, imag
 		logger.Error("error while waiting for pods:", err)
 		deleteErr := rci.Delete(rc.Name)
 		if deleteErr != nil {
-			return fmt.Errorf("error while deleting RC:%v", deleteErr)
+			return nil, fmt.Errorf("error while deleting RC:%v", deleteErr)
 		}
-		return err
+		return nil, err
 	}
 	initialDelaySeconds := int(initialDelay.Seconds())
 	// tune initial delay
@@ -450,28 +465,20 @@ func (this *Kubernetes) UpsertReplicationController(nsName, rcGenerateName, imag
 		logger.Error("error while waiting for ControllerHasDesiredReplicas:", err)
 		deleteErr := rci.Delete(rc.Name)
 		if deleteErr != nil {
-			return fmt.Errorf("error while deleting RC:%v", deleteErr)
+			return nil, fmt.Errorf("error while deleting RC:%v", deleteErr)
 		}
-		return err
+		return nil, err
 	}
 
 	// patch deployed ReplicationController
 	rc, err = rci.Get(rc.Name)
 	if err != nil {
-		return fmt.Errorf("failed to get RC: %v", err)
+		return nil, fmt.Errorf("failed to get RC: %v", err)
 	}
 
 	containers := make([]api.Container, len(rcSpec.Spec.Template.Spec.Containers))
 	for i, c := range rcSpec.Spec.Template.Spec.Containers {
-		c.ReadinessProbe = &api.Probe{
-			Handler: api.Handler{
-				TCPSocket: &api.TCPSocketAction{
-					Port: intstr.FromInt(ports[0]),
-				},
-			},
-			InitialDelaySeconds: int32(initialDelaySeconds),
-			TimeoutSeconds:      int32(this.pollTimeout.Seconds()),
-		}
+		c.ReadinessProbe = getReadinessProbe(c.LivenessProbe, initialDelaySeconds)
 		containers[i] = c
 	}
 
@@ -479,10 +486,155 @@ func (this *Kubernetes) UpsertReplicationController(nsName, rcGenerateName, imag
 
 	rc, err = rci.Update(rc)
 	if err != nil {
-		return fmt.Errorf("failed to update RC: %v", err)
+		return nil, fmt.Errorf("failed to update RC: %v", err)
 	}
 
-	return nil
+	return rc, nil
+}
+
+// getPodSpec returns the pod spec of a service container with liveness probe.
+// readiness probe is added once the initial delay of the pods is known.
+func (this *Kubernetes) getPodSpec(name, imageName string, environment map[string]string, secretEnvKeys []string, svcName string, volumes []Volume, resources Resources, ports []int, httpPort int, probePath string, probe Probe) (*api.PodSpec, error) {
+	// create container ports
+	containerPorts := make([]api.ContainerPort, len(ports))
+	for i, port := range ports {
+		containerPorts[i] = api.ContainerPort{
+			Name:          fmt.Sprintf("port%d", port),
+			ContainerPort: int32(port),
+		}
+	}
+
+	probeHandler := getProbeHandler(ports[0], httpPort, probePath)
+	probeTimeout := int32(this.pollTimeout.Seconds())
+	if probe.TimeoutSeconds > 0 {
+		probeTimeout = int32(probe.TimeoutSeconds)
+	}
+
+	var containerEnvVars []api.EnvVar
+	for k, v := range environment {
+		containerEnvVars = append(containerEnvVars, api.EnvVar{
+			Name:  k,
+			Value: v,
+		})
+	}
+	containerEnvVars = append(containerEnvVars, getSecretEnvVars(SecretEnvName(svcName), secretEnvKeys)...)
+
+	podVolumes, volumeMounts, err := this.getVolumes(svcName, volumes)
+	if err != nil {
+		return nil, err
+	}
+
+	cpuRequest, memoryRequest, cpuLimit, memoryLimit, err := resources.Quantities()
+	if err != nil {
+		return nil, err
+	}
+
+	resourceRequests := make(api.ResourceList)
+	resourceRequests[api.ResourceCPU] = cpuRequest
+	resourceRequests[api.ResourceMemory] = memoryRequest
+
+	resourceLimits := make(api.ResourceList)
+	resourceLimits[api.ResourceCPU] = cpuLimit
+	resourceLimits[api.ResourceMemory] = memoryLimit
+
+	return &api.PodSpec{
+		Volumes: podVolumes,
+		Containers: []api.Container{
+			api.Container{
+				Name:         name,
+				Env:          containerEnvVars,
+				Image:        imageName,
+				Ports:        containerPorts,
+				VolumeMounts: volumeMounts,
+				LivenessProbe: &api.Probe{
+					Handler:             probeHandler,
+					InitialDelaySeconds: int32(this.pollTimeout.Seconds()),
+					TimeoutSeconds:      probeTimeout,
+					PeriodSeconds:       int32(probe.PeriodSeconds),
+					FailureThreshold:    int32(probe.FailureThreshold),
+				},
+				Resources: api.ResourceRequirements{
+					Requests: resourceRequests,
+					Limits:   resourceLimits,
+				},
+			},
+		},
+	}, nil
+}
+
+func getReadinessProbe(livenessProbe *api.Probe, initialDelaySeconds int) *api.Probe {
+	return &api.Probe{
+		Handler:             livenessProbe.Handler,
+		InitialDelaySeconds: int32(initialDelaySeconds),
+		TimeoutSeconds:      livenessProbe.TimeoutSeconds,
+		PeriodSeconds:       livenessProbe.PeriodSeconds,
+		FailureThreshold:    livenessProbe.FailureThreshold,
+	}
+}
+
+// getProbeHandler returns a http probe on the first http port if a probe path
+// is given, or a tcp probe on the first port otherwise.
+func getProbeHandler(port, httpPort int, probePath string) api.Handler {
+	if httpPort > 0 && len(probePath) > 0 {
+		return api.Handler{
+			HTTPGet: &api.HTTPGetAction{
+				Path: probePath,
+				Port: intstr.FromInt(httpPort),
+			},
+		}
+	}
+	return api.Handler{
+		TCPSocket: &api.TCPSocketAction{
+			Port: intstr.FromInt(port),
+		},
+	}
+}
+
+func (this *Kubernetes) getVolumes(svcName string, volumes []Volume) ([]api.Volume, []api.VolumeMount, error) {
+	podVolumes := make([]api.Volume, len(volumes))
+	volumeMounts := make([]api.VolumeMount, len(volumes))
+	for i, vol := range volumes {
+		// volumes saved before the checks are checked again on deploy
+		if err := vol.Validate(svcName); err != nil {
+			return nil, nil, err
+		}
+
+		var volSource api.VolumeSource
+		switch vol.Type {
+		case VOLUME_EMPTY_DIR:
+			volSource.EmptyDir = &api.EmptyDirVolumeSource{}
+		case VOLUME_HOST_PATH:
+			volSource.HostPath = &api.HostPathVolumeSource{
+				Path: vol.Source,
+			}
+		case VOLUME_PVC:
+			volSource.PersistentVolumeClaim = &api.PersistentVolumeClaimVolumeSource{
+				ClaimName: vol.Source,
+				ReadOnly:  vol.ReadOnly,
+			}
+		case VOLUME_CONFIG_MAP:
+			volSource.ConfigMap = &api.ConfigMapVolumeSource{
+				LocalObjectReference: api.LocalObjectReference{
+					Name: vol.Source,
+				},
+			}
+		case VOLUME_SECRET:
+			volSource.Secret = &api.SecretVolumeSource{
+				SecretName: vol.Source,
+			}
+		}
+
+		podVolumes[i] = api.Volume{
+			Name:         vol.Name,
+			VolumeSource: volSource,
+		}
+		volumeMounts[i] = api.VolumeMount{
+			Name:      vol.Name,
+			MountPath: vol.MountPath,
+			ReadOnly:  vol.ReadOnly,
+		}
+	}
+	return podVolumes, volumeMounts, nil
 }
 
 func (this *Kubernetes) DeleteReplicationController(nsName, rcName string) error {
@@ -542,54 +694,228 @@ func (this *Kubernetes) GetPods(nsName string, labelMap map[string]string) ([]ap
 	return pl.Items, nil
 }
 
-func (this *Kubernetes) GetPodLogs(nsName, podID string, createdAt time.Time) (string, error) {
-	logger.Info(fmt.Sprintf("get pod logs. ns:%v, pod:%v, createdAt:%v", nsName, podID, createdAt.Format(time.RFC3339)))
+// GetPod returns the pod of the name.
+func (this *Kubernetes) GetPod(nsName, podName string) (*api.Pod, error) {
+	return this.client.Pods(nsName).Get(podName)
+}
+
+// PodLogOptions selects logs of a pod. zero values fall back to defaults of
+// kubernetes, except that output is always bounded by LimitBytes.
+type PodLogOptions struct {
+	Container    string
+	Follow       bool
+	Previous     bool
+	SinceSeconds int64
+	TailLines    int64
+	LimitBytes   int64
+}
+
+// GetPodLogs returns logs of the pod. following is not allowed, since the
+// logs would never end.
+func (this *Kubernetes) GetPodLogs(nsName, podName string, opts PodLogOptions) (string, error) {
+	if opts.Follow {
+		return "", fmt.Errorf("can not follow logs of pod %s/%s into a string", nsName, podName)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), POD_LOG_TIMEOUT)
+	defer cancel()
+
+	var out bytes.Buffer
+	err := this.StreamPodLogs(ctx, nsName, podName, opts, &out)
+	return out.String(), err
+}
+
+// StreamPodLogs copies logs of the pod to w until the logs end, LimitBytes
+// is reached or ctx is done. followed logs end after POD_LOG_FOLLOW_TIMEOUT.
+func (this *Kubernetes) StreamPodLogs(ctx context.Context, nsName, podName string, opts PodLogOptions, w io.Writer) error {
+	logger.Info(fmt.Sprintf("get pod logs. ns:%v, pod:%v, opts:%+v", nsName, podName, opts))
+	logOpts := &api.PodLogOptions{
+		Container: opts.Container,
+		Follow:    opts.Follow,
+		Previous:  opts.Previous,
+	}
+	if opts.SinceSeconds > 0 {
+		logOpts.SinceSeconds = &opts.SinceSeconds
+	}
+	if opts.TailLines > 0 {
+		logOpts.TailLines = &opts.TailLines
+	}
+	limitBytes := opts.LimitBytes
+	if limitBytes <= 0 || limitBytes > POD_LOG_LIMIT_BYTES {
+		limitBytes = POD_LOG_LIMIT_BYTES
+	}
+	logOpts.LimitBytes = &limitBytes
+
+	if opts.Follow {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, POD_LOG_FOLLOW_TIMEOUT)
+		defer cancel()
+	}
+
 	var (
 		readCloser io.ReadCloser
 		err        error
 	)
-
-	for {
-		req := this.client.RESTClient.Get().
-			Namespace(nsName).
-			Name(podID).
-			Resource("pods").
-			SubResource("log")
-		req.Param("previous", "true")
-		req.Param("tailLines", "100")
-
-		readCloser, err = req.Stream()
+	for i := 0; i < POD_LOG_RETRIES; i++ {
+		readCloser, err = this.client.Pods(nsName).GetLogs(podName, logOpts).Stream()
 		if err == nil {
 			break
-		} else {
-			logger.Info(fmt.Sprintf("error while getting logs:%v", err))
-			time.Sleep(1 * time.Second)
+		}
+		logger.Info(fmt.Sprintf("error while getting logs:%v", err))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
 		}
 	}
-
-	var out bytes.Buffer
+	if err != nil {
+		return err
+	}
 	defer readCloser.Close()
-	_, err = io.Copy(&out, readCloser)
-	return out.String(), err
+
+	// the request has no context, so closing the stream stops the copy
+	copied := make(chan struct{})
+	defer close(copied)
+	go func() {
+		select {
+		case <-ctx.Done():
+			readCloser.Close()
+		case <-copied:
+		}
+	}()
+
+	_, err = io.Copy(w, io.LimitReader(readCloser, limitBytes))
+	if ctx.Err() != nil {
+		// stopped by the caller or the follow timeout
+		return nil
+	}
+	return err
+}
+
+// LabelPods sets label key=value on every pod matching labelMap.
+// pods created afterwards by their controller won't have the label.
+func (this *Kubernetes) LabelPods(nsName string, labelMap map[string]string, key, value string) error {
+	pods, err := this.GetPods(nsName, labelMap)
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		if pod.Labels == nil {
+			pod.Labels = make(map[string]string)
+		}
+		pod.Labels[key] = value
+		if _, err := this.client.Pods(nsName).Update(&pod); err != nil {
+			return fmt.Errorf("failed to label pod %s/%s: %v", nsName, pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// ResourceUsage is the sum of container resources of the running pods in a
+// namespace.
+type ResourceUsage struct {
+	Pods           int
+	CPURequests    resource.Quantity
+	MemoryRequests resource.Quantity
+	CPULimits      resource.Quantity
+	MemoryLimits   resource.Quantity
+}
+
+func (this *Kubernetes) GetResourceUsage(nsName string) (*ResourceUsage, error) {
+	pods, err := this.GetAllPods(nsName)
+	if err != nil {
+		return nil, err
+	}
+	usage := &ResourceUsage{}
+	for _, pod := range pods {
+		if pod.Status.Phase == api.PodSucceeded || pod.Status.Phase == api.PodFailed {
+			continue
+		}
+		usage.Pods++
+		for _, c := range pod.Spec.Containers {
+			usage.CPURequests.Add(c.Resources.Requests[api.ResourceCPU])
+			usage.MemoryRequests.Add(c.Resources.Requests[api.ResourceMemory])
+			usage.CPULimits.Add(c.Resources.Limits[api.ResourceCPU])
+			usage.MemoryLimits.Add(c.Resources.Limits[api.ResourceMemory])
+		}
+	}
+	return usage, nil
 }
 
 func (this *Kubernetes) DeletePod(nsName, podName string) error {
 	return this.client.Pods(nsName).Delete(podName, &api.DeleteOptions{})
 }
 
-func (this *Kubernetes) WaitForRC(rc *api.ReplicationController) error {
-	if err := wait.Poll(this.pollInterval, this.pollTimeout, this.allPodsReady(rc, nil, nil, nil)); err != nil {
+func (this *Kubernetes) WaitForRC(rc *api.ReplicationController, fluentLogger *gologging.Logger) error {
+	if err := wait.Poll(this.pollInterval, this.pollTimeout, this.allPodsReady(rc, fluentLogger, nil, nil)); err != nil {
 		return err
 	}
 	return nil
 }
 
+// WatchPods keeps checking the selected pods for the given period after they
+// got traffic. it returns an error if the pods restart more than maxRestarts
+// times in total, crash, or are not all ready at the end of the period.
+func (this *Kubernetes) WatchPods(nsName string, podSelector map[string]string, replicas int, period time.Duration, maxRestarts int, fluentLogger *gologging.Logger) error {
+	sel := labels.Set(podSelector).AsSelector()
+	podRestarts := func() (map[string]int32, error) {
+		pods, err := this.client.Pods(nsName).List(api.ListOptions{LabelSelector: sel})
+		if err != nil {
+			return nil, err
+		}
+		restarts := make(map[string]int32)
+		for _, pod := range pods.Items {
+			for _, c := range pod.Status.ContainerStatuses {
+				restarts[pod.Name] += c.RestartCount
+			}
+		}
+		return restarts, nil
+	}
+
+	baseRestarts, err := podRestarts()
+	if err != nil {
+		return err
+	}
+
+	ready := this.podsReady(nsName, podSelector, int32(replicas), fluentLogger, nil, nil)
+	err = wait.Poll(this.pollInterval, period, func() (bool, error) {
+		restarts, err := podRestarts()
+		if err != nil {
+			return false, err
+		}
+		total := 0
+		for podName, count := range restarts {
+			total += int(count - baseRestarts[podName])
+		}
+		if total > maxRestarts {
+			return false, fmt.Errorf("pods restarted %d times in %v", total, period)
+		}
+		if _, err := ready(); err != nil {
+			return false, err
+		}
+		return false, nil
+	})
+	if err != wait.ErrWaitTimeout {
+		return err
+	}
+
+	if ok, err := ready(); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("pods are not ready after %v", period)
+	}
+	return nil
+}
+
 func (this *Kubernetes) allPodsReady(controller *api.ReplicationController, fluentLogger *gologging.Logger, startTime *time.Time, initialDelay *time.Duration) wait.ConditionFunc {
-	sel := labels.Set(controller.Spec.Selector).AsSelector()
+	return this.podsReady(controller.Namespace, controller.Spec.Selector, controller.Spec.Replicas, fluentLogger, startTime, initialDelay)
+}
+
+func (this *Kubernetes) podsReady(nsName string, podSelector map[string]string, replicas int32, fluentLogger *gologging.Logger, startTime *time.Time, initialDelay *time.Duration) wait.ConditionFunc {
+	sel := labels.Set(podSelector).AsSelector()
 	logger.Info("label selector:", sel)
 
 	return func() (bool, error) {
-		pods, err := this.client.Pods(controller.Namespace).List(api.ListOptions{LabelSelector: sel})
+		pods, err := this.client.Pods(nsName).List(api.ListOptions{LabelSelector: sel})
 		if err != nil {
 			return false, err
 		}
@@ -601,7 +927,11 @@ func (this *Kubernetes) allPodsReady(controller *api.ReplicationController, flue
 			for _, c := range pod.Status.ContainerStatuses {
 				if c.State.Waiting != nil {
 					if c.State.Waiting.Reason == "CrashLoopBackOff" {
-						msg, err := this.GetPodLogs(pod.Namespace, pod.Name, pod.CreationTimestamp.Time)
+						msg, err := this.GetPodLogs(pod.Namespace, pod.Name, PodLogOptions{
+							Container: c.Name,
+							Previous:  true,
+							TailLines: 100,
+						})
 						if err != nil {
 							msg = fmt.Sprintf("failed to get message: %v", err)
 						}
@@ -617,15 +947,26 @@ func (this *Kubernetes) allPodsReady(controller *api.ReplicationController, flue
 				for _, container := range pod.Spec.Containers {
 					if len(container.Ports) <= 0 {
 						readyPods++
+						continue
+					}
+					if probe := container.LivenessProbe; probe != nil && probe.HTTPGet != nil {
+						url := fmt.Sprintf("http://%s:%d%s", pod.Status.PodIP, probe.HTTPGet.Port.IntValue(), probe.HTTPGet.Path)
+						if err := checkHTTP(url); err != nil {
+							logger.Info(err)
+						} else {
+							readyPods++
+						}
+						continue
 					}
 					// for _, port := range container.Ports {
 					port := container.Ports[0]
 					if port.Protocol == api.ProtocolTCP {
-						_, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", pod.Status.PodIP, port.ContainerPort), 10*time.Second)
+						conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", pod.Status.PodIP, port.ContainerPort), 10*time.Second)
 						if err != nil {
 							logMsg := fmt.Sprintf("failed to connect : %s:%d", pod.Status.PodIP, port.ContainerPort)
 							logger.Info(logMsg)
 						} else {
+							conn.Close()
 							readyPods++
 						}
 					} else if port.Protocol == api.ProtocolUDP {
@@ -641,13 +982,32 @@ func (this *Kubernetes) allPodsReady(controller *api.ReplicationController, flue
 		}
 		logMsg := fmt.Sprintf("pod ready: %d/%d = %.2f%%",
 			readyPods,
-			controller.Spec.Replicas,
-			float32(readyPods)/float32(controller.Spec.Replicas)*100)
+			replicas,
+			float32(readyPods)/float32(replicas)*100)
 		if fluentLogger != nil {
 			fluentLogger.Info(logMsg)
 		} else {
 			logger.Info(logMsg)
 		}
-		return readyPods == controller.Spec.Replicas, nil
+		return readyPods == replicas, nil
 	}
 }
+
+// checkHTTP succeeds on 2xx and 3xx status codes like kubernetes http probes.
+func checkHTTP(url string) error {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unhealthy status from %s: %s", url, resp.Status)
+	}
+	return nil
+}
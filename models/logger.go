@@ -58,5 +58,27 @@ func NewFluentLogger(tag string, args map[string]interface{}) *gologging.Logger
 func (b *fluentLogger) Log(level gologging.Level, calldepth int, rec *gologging.Record) error {
 	b.args["level"] = rec.Level.String()
 	b.args["msg"] = rec.Message()
+	b.publish(rec)
 	return b.client.Post(b.tag, b.args)
 }
+
+// publish streams records of deploy loggers to the service page.
+func (b *fluentLogger) publish(rec *gologging.Record) {
+	nsName, _ := b.args["namespace"].(string)
+	svcName, _ := b.args["service"].(string)
+	if len(nsName) == 0 || len(svcName) == 0 {
+		return
+	}
+	sha, _ := b.args["sha"].(string)
+	deployID, _ := b.args["deploy_id"].(int)
+	NewLogStream().Publish(StreamEvent{
+		Type:      STREAM_EVENT_DEPLOY,
+		Namespace: nsName,
+		Service:   svcName,
+		SHA:       sha,
+		DeployID:  deployID,
+		Level:     rec.Level.String(),
+		Message:   rec.Message(),
+		Time:      rec.Time,
+	})
+}
@@ -3,10 +3,31 @@ package models
 import (
 	"encoding/json"
 	"fmt"
+	"path"
+	"regexp"
 	"strconv"
 	"strings"
+
+	"k8s.io/kubernetes/pkg/api/resource"
 )
 
+const (
+	VOLUME_EMPTY_DIR  = "emptydir"
+	VOLUME_HOST_PATH  = "hostpath"
+	VOLUME_PVC        = "pvc"
+	VOLUME_CONFIG_MAP = "configmap"
+	VOLUME_SECRET     = "secret"
+
+	DEPLOY_STRATEGY_BLUE_GREEN = "bluegreen"
+	DEPLOY_STRATEGY_ROLLING    = "rolling"
+	DEPLOY_STRATEGY_CANARY     = "canary"
+
+	BACKEND_REPLICATION_CONTROLLER = "rc"
+	BACKEND_DEPLOYMENT             = "deployment"
+)
+
+var envKeyRegex = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
 type Metadata struct {
 	Namespace      string         `json:"namespace" form:"namespace" form:"namespace"`
 	Service        string         `json:"service" form:"service" schema:"service"`
@@ -19,11 +40,25 @@ type Metadata struct {
 	HTTPPort       string         `json:"http_port" form:"http_port" schema:"http_port"`
 	TCPPort        string         `json:"tcp_port" form:"tcp_port" schema:"tcp_port"`
 	ProbePath      string         `json:"probe_path" form:"probe_path" schema:"probe_path"`
+	Probe          Probe          `json:"probe" schema:"probe"`
+	Resources      Resources      `json:"resources" schema:"res"`
 	Replicas       int            `json:"replicas" form:"replicas" schema:"replicas"`
 	Watchcenter    int            `json:"watchcenter" form:"watchcenter" schema:"watchcenter"`
 	Environment    string         `json:"environment" form:"environment" schema:"environment"`
+	SecretEnvKeys  []string       `json:"secret_env_keys"`
 	Notification   []Notification `json:"notification" schema:"noti"`
-	environmentMap map[string]string
+	Volumes        []Volume       `json:"volumes" schema:"vol"`
+	DeployStrategy DeployStrategy `json:"deploy_strategy" schema:"strategy"`
+	Backend        string         `json:"backend" form:"backend" schema:"backend"`
+	Builder        string         `json:"builder" form:"builder" schema:"builder"`
+	Autoscaling    Autoscaling    `json:"autoscaling" schema:"hpa"`
+	Triggers       []Trigger      `json:"triggers,omitempty" schema:"trigger"`
+	Protection     Protection     `json:"protection" schema:"protect"`
+	PullRequest    int            `json:"pull_request,omitempty"`
+	// SecretEnvironment is only used to post values to the service secret.
+	// values are never stored in metadata.
+	SecretEnvironment []SecretEnv `json:"-" schema:"secenv"`
+	environmentMap    map[string]string
 }
 
 type Notification struct {
@@ -31,6 +66,320 @@ type Notification struct {
 	Enable      bool   `json:"enable" schema:"enable"`
 	Endpoint    string `json:"endpoint" schema:"endpoint"`
 	Description string `json:"description" schema:"description"`
+	// Token is the bot token or the signing secret of the notification. it
+	// is stored in the notification secret of the service under TokenKey,
+	// and loaded with the metadata. empty token keeps the stored one.
+	Token    string `json:"-" schema:"token"`
+	TokenKey string `json:"token_key,omitempty" schema:"token_key"`
+	// LegacyToken is the token of notifications saved before tokens moved
+	// to the secret. it is moved on the next save.
+	LegacyToken string `json:"token,omitempty" schema:"-"`
+	// Events to notify. empty means all events.
+	Events    []EventKind     `json:"events,omitempty" schema:"events"`
+	Templates []EventTemplate `json:"templates,omitempty" schema:"tpl"`
+}
+
+// SecretEnv is an environment variable stored in the service secret. empty
+// value keeps the value already stored.
+type SecretEnv struct {
+	Key   string `schema:"key"`
+	Value string `schema:"value"`
+}
+
+// Volume is a pod volume mounted into the service container.
+// Source is the host path, claim name, configmap name or secret name
+// depending on Type, and is ignored for emptydir volumes.
+type Volume struct {
+	Name      string `json:"name" schema:"name"`
+	Type      string `json:"type" schema:"type"`
+	Source    string `json:"source" schema:"source"`
+	MountPath string `json:"mount_path" schema:"mount_path"`
+	ReadOnly  bool   `json:"read_only" schema:"read_only"`
+}
+
+// Validate checks the volume of the service. host paths must be under
+// Kubernetes.AllowedHostPaths, and secrets managed by cite can only be
+// mounted by their own service.
+func (this *Volume) Validate(svcName string) error {
+	if len(this.Name) == 0 {
+		return fmt.Errorf("volume name required")
+	}
+	if NewUtil().NormalizeByHyphen("", this.Name) != this.Name {
+		return fmt.Errorf("invalid volume name %s: only [a-z0-9-] allowed", this.Name)
+	}
+	switch this.Type {
+	case VOLUME_EMPTY_DIR:
+	case VOLUME_HOST_PATH:
+		if !path.IsAbs(this.Source) {
+			return fmt.Errorf("volume %s: host path must be absolute: %s", this.Name, this.Source)
+		}
+		if !hostPathAllowed(this.Source) {
+			return fmt.Errorf("volume %s: host path %s is not allowed", this.Name, this.Source)
+		}
+	case VOLUME_PVC, VOLUME_CONFIG_MAP:
+		if len(this.Source) == 0 {
+			return fmt.Errorf("volume %s: %s name required", this.Name, this.Type)
+		}
+	case VOLUME_SECRET:
+		if len(this.Source) == 0 {
+			return fmt.Errorf("volume %s: %s name required", this.Name, this.Type)
+		}
+		if !secretMountable(this.Source, svcName) {
+			return fmt.Errorf("volume %s: secret %s is managed by cite", this.Name, this.Source)
+		}
+	default:
+		return fmt.Errorf("volume %s: unknown volume type %s", this.Name, this.Type)
+	}
+	if !path.IsAbs(this.MountPath) || strings.Contains(this.MountPath, ":") {
+		return fmt.Errorf("volume %s: invalid mount path %s", this.Name, this.MountPath)
+	}
+	return nil
+}
+
+// hostPathAllowed reports whether the host path is under one of
+// Kubernetes.AllowedHostPaths. no host path is allowed by default, since host
+// paths give containers access to the node.
+func hostPathAllowed(hostPath string) bool {
+	hostPath = path.Clean(hostPath)
+	for _, allowed := range Conf.Kubernetes.AllowedHostPaths {
+		allowed = path.Clean(allowed)
+		if !path.IsAbs(allowed) || allowed == "/" {
+			continue
+		}
+		if hostPath == allowed || strings.HasPrefix(hostPath, allowed+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// secretMountable reports whether the service may mount the secret. secrets
+// of cite, e.g. secret env and notification tokens of other services or
+// credentials of the job builder, are kept from services of other repos in
+// the namespace.
+func secretMountable(secretName, svcName string) bool {
+	if secretName == SecretEnvName(svcName) || secretName == NotificationSecretName(svcName) {
+		return true
+	}
+	switch secretName {
+	case API_TOKEN_SECRET, Conf.Builder.Job.GitSecret, Conf.Builder.Job.DockerSecret:
+		return false
+	}
+	return !strings.HasSuffix(secretName, "-env") && !strings.HasSuffix(secretName, "-notify")
+}
+
+func (this *Metadata) ValidateVolumes() error {
+	names := make(map[string]bool)
+	mountPaths := make(map[string]bool)
+	for _, vol := range this.Volumes {
+		if err := vol.Validate(this.Service); err != nil {
+			return err
+		}
+		if names[vol.Name] {
+			return fmt.Errorf("duplicated volume name %s", vol.Name)
+		}
+		if mountPaths[path.Clean(vol.MountPath)] {
+			return fmt.Errorf("duplicated volume mount path %s", vol.MountPath)
+		}
+		names[vol.Name] = true
+		mountPaths[path.Clean(vol.MountPath)] = true
+	}
+	return nil
+}
+
+// UseDeployment reports whether the service runs on a Deployment. services
+// created before backends were selectable have no backend and keep RCs.
+func (this *Metadata) UseDeployment() bool {
+	return this.Backend == BACKEND_DEPLOYMENT
+}
+
+func (this *Metadata) ValidateBackend() error {
+	switch this.Backend {
+	case "", BACKEND_REPLICATION_CONTROLLER:
+	case BACKEND_DEPLOYMENT:
+		if this.DeployStrategy.Type == DEPLOY_STRATEGY_CANARY {
+			return fmt.Errorf("canary deploy is not supported on deployment backend")
+		}
+	default:
+		return fmt.Errorf("unknown backend %s", this.Backend)
+	}
+	return nil
+}
+
+// Autoscaling configures the HorizontalPodAutoscaler of the service.
+type Autoscaling struct {
+	Enable      bool `json:"enable" schema:"enable"`
+	MinReplicas int  `json:"min_replicas" schema:"min_replicas"`
+	MaxReplicas int  `json:"max_replicas" schema:"max_replicas"`
+	TargetCPU   int  `json:"target_cpu" schema:"target_cpu"`
+}
+
+func (this *Autoscaling) Validate(replicas int) error {
+	if !this.Enable {
+		return nil
+	}
+	if this.MinReplicas <= 0 || this.MinReplicas > this.MaxReplicas || this.MaxReplicas > Conf.Kubernetes.MaxPods {
+		return fmt.Errorf("replicas must be 1 <= min <= max <= %d", Conf.Kubernetes.MaxPods)
+	}
+	if this.TargetCPU <= 0 || this.TargetCPU > 100 {
+		return fmt.Errorf("target cpu must be between 1 and 100")
+	}
+	if !this.InBounds(replicas) {
+		return fmt.Errorf("replicas %d out of autoscaling bounds %d-%d", replicas, this.MinReplicas, this.MaxReplicas)
+	}
+	return nil
+}
+
+// InBounds reports whether replicas are allowed by the autoscaling bounds.
+func (this *Autoscaling) InBounds(replicas int) bool {
+	return !this.Enable || (this.MinReplicas <= replicas && replicas <= this.MaxReplicas)
+}
+
+// Probe tunes liveness and readiness probes of the service container.
+// zero values fall back to defaults.
+type Probe struct {
+	TimeoutSeconds   int `json:"timeout_seconds" schema:"timeout_seconds"`
+	PeriodSeconds    int `json:"period_seconds" schema:"period_seconds"`
+	FailureThreshold int `json:"failure_threshold" schema:"failure_threshold"`
+}
+
+func (this *Metadata) ValidateProbe() error {
+	if len(this.ProbePath) > 0 && !strings.HasPrefix(this.ProbePath, "/") {
+		return fmt.Errorf("probe path must start with /: %s", this.ProbePath)
+	}
+	if this.Probe.TimeoutSeconds < 0 || this.Probe.PeriodSeconds < 0 || this.Probe.FailureThreshold < 0 {
+		return fmt.Errorf("probe timeout, period and failure threshold must not be negative")
+	}
+	return nil
+}
+
+// HTTPProbePort returns the first http port, or 0 for tcp only services.
+func (this *Metadata) HTTPProbePort() int {
+	httpPorts, _ := NewUtil().TCPPortsToList(this.HTTPPort)
+	if len(httpPorts) < 1 {
+		return 0
+	}
+	return httpPorts[0]
+}
+
+// Resources are cpu and memory requests and limits of the service container.
+// empty values fall back to the defaults in config.
+type Resources struct {
+	CPURequest    string `json:"cpu_request" schema:"cpu_request"`
+	MemoryRequest string `json:"memory_request" schema:"memory_request"`
+	CPULimit      string `json:"cpu_limit" schema:"cpu_limit"`
+	MemoryLimit   string `json:"memory_limit" schema:"memory_limit"`
+}
+
+// Quantities parses resources, filling empty values with config defaults.
+func (this *Resources) Quantities() (cpuRequest, memoryRequest, cpuLimit, memoryLimit resource.Quantity, err error) {
+	parse := func(name, value, defaultValue string) resource.Quantity {
+		if err != nil {
+			return resource.Quantity{}
+		}
+		if len(value) == 0 {
+			value = defaultValue
+		}
+		q, parseErr := resource.ParseQuantity(value)
+		if parseErr != nil {
+			err = fmt.Errorf("invalid %s %s: %v", name, value, parseErr)
+		}
+		return q
+	}
+	cpuRequest = parse("cpu request", this.CPURequest, Conf.Kubernetes.DefaultCPU)
+	memoryRequest = parse("memory request", this.MemoryRequest, Conf.Kubernetes.DefaultMemory)
+	cpuLimit = parse("cpu limit", this.CPULimit, Conf.Kubernetes.MaxCPU)
+	memoryLimit = parse("memory limit", this.MemoryLimit, Conf.Kubernetes.MaxMemory)
+	return
+}
+
+func (this *Resources) Validate() error {
+	cpuRequest, memoryRequest, cpuLimit, memoryLimit, err := this.Quantities()
+	if err != nil {
+		return err
+	}
+	maxCPU := resource.MustParse(Conf.Kubernetes.MaxCPU)
+	maxMemory := resource.MustParse(Conf.Kubernetes.MaxMemory)
+
+	if cpuRequest.Sign() <= 0 || memoryRequest.Sign() <= 0 {
+		return fmt.Errorf("cpu and memory requests must be positive")
+	}
+	if cpuRequest.Cmp(cpuLimit) > 0 {
+		return fmt.Errorf("cpu request %s exceeds cpu limit %s", cpuRequest.String(), cpuLimit.String())
+	}
+	if memoryRequest.Cmp(memoryLimit) > 0 {
+		return fmt.Errorf("memory request %s exceeds memory limit %s", memoryRequest.String(), memoryLimit.String())
+	}
+	if cpuLimit.Cmp(maxCPU) > 0 {
+		return fmt.Errorf("cpu limit %s exceeds maximum %s", cpuLimit.String(), maxCPU.String())
+	}
+	if memoryLimit.Cmp(maxMemory) > 0 {
+		return fmt.Errorf("memory limit %s exceeds maximum %s", memoryLimit.String(), maxMemory.String())
+	}
+	return nil
+}
+
+// DeployStrategy decides how a new replication controller replaces the
+// active one. bluegreen starts all new pods before switching the service
+// selector. rolling steps the new RC up and the active RC down, keeping at
+// most MaxSurge extra pods and at most MaxUnavailable missing pods.
+// canary sends about CanaryWeight percent of traffic to the new RC until
+// it is promoted or aborted.
+type DeployStrategy struct {
+	Type           string `json:"type" schema:"type"`
+	MaxSurge       int    `json:"max_surge" schema:"max_surge"`
+	MaxUnavailable int    `json:"max_unavailable" schema:"max_unavailable"`
+	CanaryWeight   int    `json:"canary_weight" schema:"canary_weight"`
+}
+
+func (this *DeployStrategy) Validate(replicas int) error {
+	switch this.Type {
+	case "", DEPLOY_STRATEGY_BLUE_GREEN:
+	case DEPLOY_STRATEGY_ROLLING:
+		if this.MaxSurge < 0 || this.MaxUnavailable < 0 {
+			return fmt.Errorf("max surge and max unavailable must not be negative")
+		}
+		if this.MaxSurge == 0 && this.MaxUnavailable == 0 {
+			return fmt.Errorf("max surge and max unavailable cannot be both zero")
+		}
+		if this.MaxUnavailable >= replicas {
+			return fmt.Errorf("max unavailable %d must be less than replicas %d", this.MaxUnavailable, replicas)
+		}
+	case DEPLOY_STRATEGY_CANARY:
+		if this.CanaryWeight <= 0 || this.CanaryWeight >= 100 {
+			return fmt.Errorf("canary weight must be between 1 and 99")
+		}
+	default:
+		return fmt.Errorf("unknown deploy strategy %s", this.Type)
+	}
+	return nil
+}
+
+func (this *Metadata) ValidateNotification() error {
+	for i := range this.Notification {
+		this.Notification[i].compactTemplates()
+		if err := this.Notification[i].Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *Metadata) ValidateSecretEnv() error {
+	keys := make(map[string]bool)
+	for _, env := range this.SecretEnvironment {
+		if !envKeyRegex.MatchString(env.Key) {
+			return fmt.Errorf("invalid secret env key %s", env.Key)
+		}
+		if keys[env.Key] {
+			return fmt.Errorf("duplicated secret env key %s", env.Key)
+		}
+		if _, ok := this.EnvironmentMap()[env.Key]; ok {
+			return fmt.Errorf("%s is defined in both environment variables and secret env", env.Key)
+		}
+		keys[env.Key] = true
+	}
+	return nil
 }
 
 func (this *Metadata) EnvironmentMap() map[string]string {
@@ -103,6 +452,9 @@ func UnmarshalMetadata(s string) (*Metadata, error) {
 		meta.ContainerPorts = ports
 		meta.ContainerPort = ports[0]
 	}
+	for _, key := range meta.SecretEnvKeys {
+		meta.SecretEnvironment = append(meta.SecretEnvironment, SecretEnv{Key: key})
+	}
 	logger.Debugf("meta: %v", meta)
 	return meta, nil
 }
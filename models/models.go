@@ -10,6 +10,8 @@ var (
 )
 
 const (
-	CITE_BUILDBOT_GITHUB_CONTEXT = "buildbot/cite-build"
-	CITE_K8S_ANNOTATION_KEY = "cite.io/created-by"
+	CITE_BUILDBOT_GITHUB_CONTEXT   = "buildbot/cite-build"
+	CITE_JOB_GITHUB_CONTEXT        = "cite/job"
+	CITE_K8S_ANNOTATION_KEY        = "cite.io/created-by"
+	CITE_K8S_CANARY_ANNOTATION_KEY = "cite.io/canary"
 )
@@ -2,17 +2,93 @@ package models
 
 import (
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"strconv"
 	"strings"
 	"sync"
 )
 
+const (
+	MESSAGE_STATE_PENDING = "pending"
+	MESSAGE_STATE_SUCCESS = "success"
+	MESSAGE_STATE_FAILURE = "failure"
+)
+
+// NotificationDriver delivers messages to the endpoint of a notification.
+// drivers register themselves by RegisterNotificationDriver.
+type NotificationDriver interface {
+	// Validate checks the config of an enabled notification before it is
+	// stored in metadata.
+	Validate(nm Notification) error
+	Send(nm Notification, msg Message) error
+}
+
+// Message is a notification message. fields other than Text are optional
+// and used by drivers which support rich or structured messages.
+type Message struct {
+	Event     EventKind
+	Text      string
+	State     string
+	Namespace string
+	Service   string
+	SHA       string
+	DeployID  int
+	Image     string
+	Log       string
+	CommitURL string
+	BuildURL  string
+}
+
+// String returns the message as plain text for drivers without rich message
+// support.
+func (this Message) String() string {
+	lines := []string{this.Text}
+	if len(this.CommitURL) > 0 {
+		lines = append(lines, fmt.Sprintf("* commit: %s", this.CommitURL))
+	}
+	if len(this.BuildURL) > 0 {
+		lines = append(lines, fmt.Sprintf("* buildbot url: %s", this.BuildURL))
+	}
+	return strings.Join(lines, "\n")
+}
+
+var (
+	notificationDrivers      = make(map[string]NotificationDriver)
+	notificationDriversMutex sync.RWMutex
+)
+
+func RegisterNotificationDriver(name string, driver NotificationDriver) {
+	notificationDriversMutex.Lock()
+	defer notificationDriversMutex.Unlock()
+	notificationDrivers[name] = driver
+}
+
+func GetNotificationDriver(name string) (NotificationDriver, bool) {
+	notificationDriversMutex.RLock()
+	defer notificationDriversMutex.RUnlock()
+	driver, ok := notificationDrivers[name]
+	return driver, ok
+}
+
+// Validate checks events and templates of the notification, and its config
+// by the driver. disabled notifications are not validated.
+func (this Notification) Validate() error {
+	if !this.Enable {
+		return nil
+	}
+	driver, ok := GetNotificationDriver(this.Driver)
+	if !ok {
+		return fmt.Errorf("unknown notification driver %s", this.Driver)
+	}
+	if err := this.validateEvents(); err != nil {
+		return fmt.Errorf("%s: %v", this.Driver, err)
+	}
+	if err := driver.Validate(this); err != nil {
+		return fmt.Errorf("%s: %v", this.Driver, err)
+	}
+	return nil
+}
+
 type Notifier struct {
-	k8s *Kubernetes
-	wc  *WatchCenter
-	// slack Slack
+	wc *WatchCenter
 }
 
 var (
@@ -23,36 +99,36 @@ var (
 func NewNotifier() *Notifier {
 	notiOnce.Do(func() {
 		notiInst = &Notifier{
-			k8s: NewKubernetes(),
-			wc:  NewWatchCenter(),
+			wc: NewWatchCenter(),
 		}
 	})
 	return notiInst
 }
 
 func (n *Notifier) Send(nms []Notification, msg string) error {
+	return n.SendMessage(nms, Message{Text: msg})
+}
+
+// SendMessage sends the message to all enabled notifications subscribing to
+// its event. a failed notification does not stop the others.
+func (n *Notifier) SendMessage(nms []Notification, msg Message) error {
+	errs := []string{}
 	for _, nm := range nms {
-		if !nm.Enable {
+		if !nm.Enable || !nm.Subscribed(msg.Event) {
 			continue
 		}
-		switch nm.Driver {
-		case "slack":
-			payload := fmt.Sprintf(`{"text": "%s"}`, msg)
-			resp, err := http.Post(nm.Endpoint, "application/json", strings.NewReader(payload))
-			defer resp.Body.Close()
-			if err != nil {
-				respBody, _ := ioutil.ReadAll(resp.Body)
-				return fmt.Errorf("failed to send slack message: %s", respBody)
-			}
-		case "watchcenter":
-			ep, err := strconv.Atoi(nm.Endpoint)
-			if err != nil {
-				return fmt.Errorf("failed to convert watchcenter endpoint %s to int: %v", nm.Endpoint, err)
-			}
-			n.wc.SendGroupTalk(ep, msg)
-		default:
+		driver, ok := GetNotificationDriver(nm.Driver)
+		if !ok {
 			logger.Errorf("unknown notification driver %s", nm.Driver)
+			continue
 		}
+		if err := driver.Send(nm, nm.Render(msg)); err != nil {
+			logger.Errorf("failed to send %s notification: %v", nm.Driver, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", nm.Driver, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to send notifications: %s", strings.Join(errs, ", "))
 	}
 	return nil
 }
@@ -68,10 +144,14 @@ func (n *Notifier) SendSystem(msg string) error {
 }
 
 func (n *Notifier) SendWithFallback(nms []Notification, wc int, msg string) error {
+	return n.SendMessageWithFallback(nms, wc, Message{Text: msg})
+}
+
+func (n *Notifier) SendMessageWithFallback(nms []Notification, wc int, msg Message) error {
 	// for backward compatibility
 	if len(nms) == 0 {
-		n.wc.SendGroupTalk(wc, msg)
+		n.wc.SendGroupTalk(wc, msg.String())
 		return nil
 	}
-	return n.Send(nms, msg)
+	return n.SendMessage(nms, msg)
 }
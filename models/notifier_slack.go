@@ -0,0 +1,101 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/nlopes/slack"
+)
+
+// slackDriver posts messages through chat.postMessage with the bot token
+// when the notification has one. notifications created before bot support
+// have an incoming webhook url as endpoint and no token.
+type slackDriver struct {
+	client *http.Client
+}
+
+var slackColors = map[string]string{
+	MESSAGE_STATE_PENDING: "warning",
+	MESSAGE_STATE_SUCCESS: "good",
+	MESSAGE_STATE_FAILURE: "danger",
+}
+
+func init() {
+	// the slack api client has no timeout by default, so a stalled slack
+	// would block notifiers forever
+	slack.HTTPClient = &http.Client{Timeout: WEBHOOK_TIMEOUT}
+	RegisterNotificationDriver("slack", slackDriver{
+		client: &http.Client{Timeout: WEBHOOK_TIMEOUT},
+	})
+}
+
+func (this slackDriver) Validate(nm Notification) error {
+	if len(nm.Endpoint) == 0 {
+		return fmt.Errorf("channel or incoming webhook url required")
+	}
+	if !nm.HasToken() && !strings.HasPrefix(nm.Endpoint, "https://") {
+		return fmt.Errorf("bot token required for channel %s", nm.Endpoint)
+	}
+	return nil
+}
+
+func (this slackDriver) Send(nm Notification, msg Message) error {
+	attachment := slackAttachment(msg)
+	if len(nm.Token) == 0 {
+		return this.sendWebhook(nm.Endpoint, attachment)
+	}
+
+	params := slack.NewPostMessageParameters()
+	params.AsUser = true
+	params.Attachments = []slack.Attachment{attachment}
+	if _, _, err := slack.New(nm.Token).PostMessage(nm.Endpoint, "", params); err != nil {
+		return fmt.Errorf("failed to post slack message to %s: %v", nm.Endpoint, err)
+	}
+	return nil
+}
+
+func (this slackDriver) sendWebhook(webhookURL string, attachment slack.Attachment) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"attachments": []slack.Attachment{attachment},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := this.client.Post(webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to send slack message: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed to send slack message: %s, %s", resp.Status, respBody)
+	}
+	return nil
+}
+
+func slackAttachment(msg Message) slack.Attachment {
+	attachment := slack.Attachment{
+		Color:      slackColors[msg.State],
+		Fallback:   msg.String(),
+		Text:       msg.Text,
+		MarkdownIn: []string{"text"},
+	}
+	if len(msg.CommitURL) > 0 {
+		attachment.Fields = append(attachment.Fields, slack.AttachmentField{
+			Title: "Commit",
+			Value: msg.CommitURL,
+		})
+	}
+	if len(msg.BuildURL) > 0 {
+		attachment.Fields = append(attachment.Fields, slack.AttachmentField{
+			Title: "Buildbot",
+			Value: msg.BuildURL,
+		})
+	}
+	return attachment
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"strings"
+)
+
+// smtpDriver mails messages to the comma separated addresses of the
+// endpoint through the smtp server in config.
+type smtpDriver struct{}
+
+func init() {
+	RegisterNotificationDriver("smtp", smtpDriver{})
+}
+
+func (this smtpDriver) Validate(nm Notification) error {
+	if len(Conf.Notification.SMTP.Host) == 0 {
+		return fmt.Errorf("smtp server is not configured")
+	}
+	if _, err := mail.ParseAddressList(nm.Endpoint); err != nil {
+		return fmt.Errorf("invalid email addresses %s: %v", nm.Endpoint, err)
+	}
+	return nil
+}
+
+func (this smtpDriver) Send(nm Notification, msg Message) error {
+	conf := Conf.Notification.SMTP
+	addrs, err := mail.ParseAddressList(nm.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid email addresses %s: %v", nm.Endpoint, err)
+	}
+	to := make([]string, len(addrs))
+	for i, addr := range addrs {
+		to[i] = addr.Address
+	}
+
+	var auth smtp.Auth
+	if len(conf.Username) > 0 {
+		auth = smtp.PlainAuth("", conf.Username, conf.Password, conf.Host)
+	}
+
+	subject := strings.SplitN(msg.Text, "\n", 2)[0]
+	if len(msg.Service) > 0 {
+		subject = fmt.Sprintf("%s/%s: %s", msg.Namespace, msg.Service, subject)
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %s\r\n", conf.From)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "[cite] "+subject))
+	fmt.Fprintf(&body, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&body, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&body, "\r\n%s\r\n", msg.String())
+
+	port := conf.Port
+	if port == 0 {
+		port = 25
+	}
+	addr := fmt.Sprintf("%s:%d", conf.Host, port)
+	if err := smtp.SendMail(addr, auth, conf.From, to, body.Bytes()); err != nil {
+		return fmt.Errorf("failed to send mail to %s: %v", nm.Endpoint, err)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type watchCenterDriver struct{}
+
+func init() {
+	RegisterNotificationDriver("watchcenter", watchCenterDriver{})
+}
+
+func (this watchCenterDriver) Validate(nm Notification) error {
+	if _, err := strconv.Atoi(nm.Endpoint); err != nil {
+		return fmt.Errorf("invalid group id %s", nm.Endpoint)
+	}
+	return nil
+}
+
+func (this watchCenterDriver) Send(nm Notification, msg Message) error {
+	groupID, err := strconv.Atoi(nm.Endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to convert watchcenter endpoint %s to int: %v", nm.Endpoint, err)
+	}
+	NewWatchCenter().SendGroupTalk(groupID, msg.String())
+	return nil
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	WEBHOOK_SIGNATURE_HEADER = "X-Cite-Signature"
+	WEBHOOK_TIMEOUT          = 10 * time.Second
+)
+
+// webhookDriver posts messages as json to the endpoint. the body is signed
+// with HMAC-SHA256 of the notification token and the signature is sent as
+// "sha256=<hex>" in the X-Cite-Signature header.
+type webhookDriver struct {
+	client *http.Client
+}
+
+type webhookPayload struct {
+	Event     EventKind `json:"event,omitempty"`
+	Text      string    `json:"text"`
+	State     string    `json:"state,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	Service   string    `json:"service,omitempty"`
+	SHA       string    `json:"sha,omitempty"`
+	DeployID  int       `json:"deploy_id,omitempty"`
+	Image     string    `json:"image,omitempty"`
+	CommitURL string    `json:"commit_url,omitempty"`
+	BuildURL  string    `json:"build_url,omitempty"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+func init() {
+	RegisterNotificationDriver("webhook", webhookDriver{
+		client: &http.Client{Timeout: WEBHOOK_TIMEOUT},
+	})
+}
+
+func (this webhookDriver) Validate(nm Notification) error {
+	u, err := url.Parse(nm.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("invalid url %s", nm.Endpoint)
+	}
+	if !nm.HasToken() {
+		return fmt.Errorf("secret required to sign payload")
+	}
+	return nil
+}
+
+func (this webhookDriver) Send(nm Notification, msg Message) error {
+	payload, err := json.Marshal(webhookPayload{
+		Event:     msg.Event,
+		Text:      msg.Text,
+		State:     msg.State,
+		Namespace: msg.Namespace,
+		Service:   msg.Service,
+		SHA:       msg.SHA,
+		DeployID:  msg.DeployID,
+		Image:     msg.Image,
+		CommitURL: msg.CommitURL,
+		BuildURL:  msg.BuildURL,
+		SentAt:    time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", nm.Endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WEBHOOK_SIGNATURE_HEADER, "sha256="+WebhookSignature(nm.Token, payload))
+
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post webhook to %s: %v", nm.Endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed to post webhook to %s: %s, %s", nm.Endpoint, resp.Status, respBody)
+	}
+	return nil
+}
+
+// WebhookSignature returns hex encoded HMAC-SHA256 of the payload.
+func WebhookSignature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,271 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	PIPELINE_CONFIGMAP   = "cite-pipelines"
+	PIPELINE_KEY         = "pipelines"
+	PROMOTION_CONFIGMAP  = "cite-promotions"
+	PROMOTION_LIMIT      = 100
+	PIPELINE_STAGE_LIMIT = 10
+
+	PROMOTION_STATE_WAITING  = "waiting"
+	PROMOTION_STATE_PROMOTED = "promoted"
+	PROMOTION_STATE_REJECTED = "rejected"
+	PROMOTION_STATE_FAILED   = "failed"
+)
+
+var (
+	ErrPromotionDecided = fmt.Errorf("promotion already decided")
+	ErrPromotionSelf    = fmt.Errorf("requester cannot approve own promotion")
+)
+
+// Pipeline links services into ordered stages, e.g. dev, staging and prod.
+// an image running on a stage is promoted to the next stage with its sha, so
+// later stages run exactly what earlier stages tested. stages may be services
+// of other namespaces, but all of them are built from the same repo.
+// pipelines are kept in a configmap of the namespace owning them.
+type Pipeline struct {
+	Name      string          `json:"name" schema:"name"`
+	Stages    []PipelineStage `json:"stages" schema:"stage"`
+	CreatedBy string          `json:"created_by" schema:"-"`
+	CreatedAt time.Time       `json:"created_at" schema:"-"`
+}
+
+// PipelineStage is a service in a pipeline. Environment is the github
+// deployment environment of deploys promoted to the stage, the stage name by
+// default. promotions to stages requiring Approval wait until someone with
+// push permission on the service, other than the requester, approves them.
+// stages on other clusters name one of Conf.Clusters, and are read and
+// deployed through the cite of the cluster. stages on this cluster have no
+// Cluster.
+type PipelineStage struct {
+	Name        string `json:"name" schema:"name"`
+	Cluster     string `json:"cluster,omitempty" schema:"cluster"`
+	Namespace   string `json:"namespace" schema:"namespace"`
+	Service     string `json:"service" schema:"service"`
+	Environment string `json:"environment,omitempty" schema:"environment"`
+	Approval    bool   `json:"approval" schema:"approval"`
+}
+
+func (this *PipelineStage) GitHubEnvironment() string {
+	if len(this.Environment) > 0 {
+		return this.Environment
+	}
+	return this.Name
+}
+
+// Target returns the service of the stage, prefixed by its cluster if on
+// another cluster.
+func (this *PipelineStage) Target() string {
+	if len(this.Cluster) > 0 {
+		return fmt.Sprintf("%s:%s/%s", this.Cluster, this.Namespace, this.Service)
+	}
+	return this.Namespace + "/" + this.Service
+}
+
+// ServiceURL returns the page of the service of the stage, on the cite of its
+// cluster if on another cluster.
+func (this *PipelineStage) ServiceURL() string {
+	if len(this.Cluster) > 0 {
+		if cluster, err := GetCluster(this.Cluster); err == nil {
+			return cluster.ServiceURL(this.Namespace, this.Service)
+		}
+	}
+	return fmt.Sprintf("/namespaces/%s/services/%s", this.Namespace, this.Service)
+}
+
+// Promotion is a deploy of the image of a stage to the next stage. records
+// are kept per pipeline, latest first.
+type Promotion struct {
+	ID          string     `json:"id"`
+	From        int        `json:"from"`
+	To          int        `json:"to"`
+	SHA         string     `json:"sha"`
+	Image       string     `json:"image"`
+	State       string     `json:"state"`
+	DeployID    int        `json:"deploy_id,omitempty"`
+	RequestedBy string     `json:"requested_by"`
+	RequestedAt time.Time  `json:"requested_at"`
+	DecidedBy   string     `json:"decided_by,omitempty"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+func NewPromotion(from int, record DeployRecord, requestedBy string) Promotion {
+	now := time.Now()
+	return Promotion{
+		ID:          strconv.FormatInt(now.UnixNano(), 36),
+		From:        from,
+		To:          from + 1,
+		SHA:         record.SHA,
+		Image:       record.Image,
+		State:       PROMOTION_STATE_WAITING,
+		RequestedBy: requestedBy,
+		RequestedAt: now,
+	}
+}
+
+func (this *Pipeline) Validate() error {
+	if len(this.Name) == 0 || NewUtil().NormalizeByHyphen("", this.Name) != this.Name {
+		return fmt.Errorf("invalid pipeline name %s: only [a-z0-9-] allowed", this.Name)
+	}
+	if len(this.Stages) < 2 || len(this.Stages) > PIPELINE_STAGE_LIMIT {
+		return fmt.Errorf("pipeline needs 2 to %d stages", PIPELINE_STAGE_LIMIT)
+	}
+	names := make(map[string]bool)
+	services := make(map[string]bool)
+	for _, stage := range this.Stages {
+		if len(stage.Name) == 0 || len(stage.Namespace) == 0 || len(stage.Service) == 0 {
+			return fmt.Errorf("stage name, namespace and service required")
+		}
+		if names[stage.Name] {
+			return fmt.Errorf("duplicated stage name %s", stage.Name)
+		}
+		if len(stage.Cluster) > 0 {
+			if _, err := GetCluster(stage.Cluster); err != nil {
+				return fmt.Errorf("invalid stage %s: %v", stage.Name, err)
+			}
+		}
+		svc := stage.Target()
+		if services[svc] {
+			return fmt.Errorf("service %s is in more than one stage", svc)
+		}
+		names[stage.Name] = true
+		services[svc] = true
+	}
+	return nil
+}
+
+// GetPipelines returns pipelines of the namespace.
+func (this *Kubernetes) GetPipelines(nsName string) ([]Pipeline, error) {
+	pipelines := []Pipeline{}
+	err := this.getRecords(nsName, PIPELINE_CONFIGMAP, PIPELINE_KEY, &pipelines)
+	return pipelines, err
+}
+
+func (this *Kubernetes) GetPipeline(nsName, name string) (Pipeline, error) {
+	pipelines, err := this.GetPipelines(nsName)
+	if err != nil {
+		return Pipeline{}, err
+	}
+	for _, pipeline := range pipelines {
+		if pipeline.Name == name {
+			return pipeline, nil
+		}
+	}
+	return Pipeline{}, fmt.Errorf("pipeline not found. ns:%s, pipeline:%s", nsName, name)
+}
+
+// UpsertPipeline creates the pipeline, or replaces stages of the existing
+// one.
+func (this *Kubernetes) UpsertPipeline(nsName string, pipeline Pipeline) error {
+	pipelines := []Pipeline{}
+	return this.updateRecords(nsName, PIPELINE_CONFIGMAP, PIPELINE_KEY, &pipelines, func() error {
+		for i := range pipelines {
+			if pipelines[i].Name == pipeline.Name {
+				pipelines[i].Stages = pipeline.Stages
+				return nil
+			}
+		}
+		pipelines = append(pipelines, pipeline)
+		return nil
+	})
+}
+
+// DeletePipeline deletes the pipeline. promotions are kept, and show up
+// again if a pipeline of the same name is created.
+func (this *Kubernetes) DeletePipeline(nsName, name string) error {
+	pipelines := []Pipeline{}
+	return this.updateRecords(nsName, PIPELINE_CONFIGMAP, PIPELINE_KEY, &pipelines, func() error {
+		for i := range pipelines {
+			if pipelines[i].Name == name {
+				pipelines = append(pipelines[:i], pipelines[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("pipeline not found. ns:%s, pipeline:%s", nsName, name)
+	})
+}
+
+// GetPromotions returns promotions of the pipeline, latest first.
+func (this *Kubernetes) GetPromotions(nsName, pipelineName string) ([]Promotion, error) {
+	promotions := []Promotion{}
+	err := this.getRecords(nsName, PROMOTION_CONFIGMAP, pipelineName, &promotions)
+	return promotions, err
+}
+
+func (this *Kubernetes) GetPromotion(nsName, pipelineName, id string) (Promotion, error) {
+	promotions, err := this.GetPromotions(nsName, pipelineName)
+	if err != nil {
+		return Promotion{}, err
+	}
+	for _, promotion := range promotions {
+		if promotion.ID == id {
+			return promotion, nil
+		}
+	}
+	return Promotion{}, fmt.Errorf("promotion not found. ns:%s, pipeline:%s, id:%s", nsName, pipelineName, id)
+}
+
+// AddPromotion prepends the promotion to promotions of the pipeline and drops
+// records beyond PROMOTION_LIMIT.
+func (this *Kubernetes) AddPromotion(nsName, pipelineName string, promotion Promotion) error {
+	promotions := []Promotion{}
+	return this.updateRecords(nsName, PROMOTION_CONFIGMAP, pipelineName, &promotions, func() error {
+		promotions = append([]Promotion{promotion}, promotions...)
+		if len(promotions) > PROMOTION_LIMIT {
+			promotions = promotions[:PROMOTION_LIMIT]
+		}
+		return nil
+	})
+}
+
+// DecidePromotion sets the state of the waiting promotion, and returns the
+// updated promotion. ErrPromotionDecided is returned if someone else decided
+// it already.
+func (this *Kubernetes) DecidePromotion(nsName, pipelineName, id, state, decidedBy string) (Promotion, error) {
+	var decided Promotion
+	promotions := []Promotion{}
+	err := this.updateRecords(nsName, PROMOTION_CONFIGMAP, pipelineName, &promotions, func() error {
+		for i := range promotions {
+			if promotions[i].ID != id {
+				continue
+			}
+			if promotions[i].State != PROMOTION_STATE_WAITING {
+				return ErrPromotionDecided
+			}
+			now := time.Now()
+			promotions[i].State = state
+			promotions[i].DecidedBy = decidedBy
+			promotions[i].DecidedAt = &now
+			decided = promotions[i]
+			return nil
+		}
+		return fmt.Errorf("promotion not found. ns:%s, pipeline:%s, id:%s", nsName, pipelineName, id)
+	})
+	return decided, err
+}
+
+// FinishPromotion records the github deployment or the error of the promoted
+// deploy.
+func (this *Kubernetes) FinishPromotion(nsName, pipelineName, id string, deployID int, errMsg string) error {
+	promotions := []Promotion{}
+	return this.updateRecords(nsName, PROMOTION_CONFIGMAP, pipelineName, &promotions, func() error {
+		for i := range promotions {
+			if promotions[i].ID == id {
+				promotions[i].DeployID = deployID
+				if len(errMsg) > 0 {
+					promotions[i].State = PROMOTION_STATE_FAILED
+					promotions[i].Error = errMsg
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("promotion not found. ns:%s, pipeline:%s, id:%s", nsName, pipelineName, id)
+	})
+}
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	k8sErrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/util/sets"
+)
+
+const (
+	PREVIEW_LABEL                  = "pr"
+	PREVIEW_EXPIRES_ANNOTATION_KEY = "cite.preview.expires"
+	PREVIEW_DEFAULT_TTL            = 72 * time.Hour
+	PREVIEW_DEFAULT_MAX            = 5
+)
+
+// ErrPreviewLimit is returned when the namespace has as many previews as
+// Preview.MaxPerNamespace.
+var ErrPreviewLimit = fmt.Errorf("preview limit reached")
+
+// PreviewServiceName returns the name of the preview of the service for the
+// pull request.
+func PreviewServiceName(svcName string, number int) string {
+	return fmt.Sprintf("%s-pr-%d", svcName, number)
+}
+
+// PreviewTTL returns how long previews live after the last push.
+func PreviewTTL() time.Duration {
+	ttl, err := time.ParseDuration(Conf.Preview.TTL)
+	if err != nil || ttl <= 0 {
+		return PREVIEW_DEFAULT_TTL
+	}
+	return ttl
+}
+
+func PreviewMaxPerNamespace() int {
+	if Conf.Preview.MaxPerNamespace > 0 {
+		return Conf.Preview.MaxPerNamespace
+	}
+	return PREVIEW_DEFAULT_MAX
+}
+
+// PreviewURL returns the url of the preview by Preview.URL, or the service
+// page of cite if it is not configured.
+func PreviewURL(nsName, svcName string) string {
+	if len(Conf.Preview.URL) == 0 {
+		return fmt.Sprintf("%s/namespaces/%s/services/%s", Conf.Cite.Host, nsName, svcName)
+	}
+	return strings.NewReplacer("{service}", svcName, "{namespace}", nsName).Replace(Conf.Preview.URL)
+}
+
+// PreviewExpired reports whether the preview service outlived its TTL.
+func PreviewExpired(svc *api.Service) bool {
+	expires, err := time.Parse(time.RFC3339, svc.Annotations[PREVIEW_EXPIRES_ANNOTATION_KEY])
+	if err != nil {
+		// previews of unknown expiry expire by their age
+		return time.Since(svc.CreationTimestamp.Time) > PreviewTTL()
+	}
+	return time.Now().After(expires)
+}
+
+// GetPreviewServices returns preview services matching labelMap.
+func (this *Kubernetes) GetPreviewServices(nsName string, labelMap map[string]string) ([]api.Service, error) {
+	sel := labels.Set(labelMap).AsSelector()
+	svcRequirement, _ := labels.NewRequirement("type", labels.DoesNotExistOperator, sets.NewString())
+	previewRequirement, _ := labels.NewRequirement(PREVIEW_LABEL, labels.ExistsOperator, sets.NewString())
+	sel = sel.Add(*svcRequirement, *previewRequirement)
+	sl, err := this.client.Services(nsName).List(api.ListOptions{LabelSelector: sel})
+	if err != nil {
+		return nil, err
+	}
+	return sl.Items, nil
+}
+
+// UpsertPreviewService creates the preview of the service for the pull
+// request, or extends the TTL of the existing one. previews run a replica of
+// the head branch, and deploy every build of it. created is true if the
+// preview is new.
+func (this *Kubernetes) UpsertPreviewService(nsName string, base *Metadata, number int, headBranch string) (meta *Metadata, created bool, err error) {
+	svcName := PreviewServiceName(base.Service, number)
+	svc, meta, err := this.GetService(nsName, svcName)
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return nil, false, err
+	}
+
+	if svc == nil {
+		previews, err := this.GetPreviewServices(nsName, map[string]string{})
+		if err != nil {
+			return nil, false, err
+		}
+		if len(previews) >= PreviewMaxPerNamespace() {
+			return nil, false, ErrPreviewLimit
+		}
+
+		preview := *base
+		preview.Service = svcName
+		preview.GitBranch = headBranch
+		preview.PullRequest = number
+		preview.AutoDeploy = true
+		preview.Replicas = 1
+		preview.Autoscaling = Autoscaling{}
+		preview.DeployStrategy = DeployStrategy{}
+		preview.Triggers = nil
+		preview.Protection = Protection{}
+		// pull requests are commented instead
+		preview.Notification = nil
+		preview.Watchcenter = 0
+		meta = &preview
+
+		if err := this.CopySecretEnv(nsName, base.Service, svcName); err != nil {
+			return nil, false, err
+		}
+		httpPorts, _ := this.util.TCPPortsToList(meta.HTTPPort)
+		tcpPorts, _ := this.util.TCPPortsToList(meta.TCPPort)
+		svcLabels := this.GetServiceLabels(meta)
+		svcSelector := make(map[string]string)
+		for k, v := range svcLabels {
+			svcSelector[k] = v
+		}
+		svc, err = this.UpsertService(nsName, svcName, svcLabels, svcSelector, meta.Marshal(), append(httpPorts, tcpPorts...))
+		if err != nil {
+			return nil, false, err
+		}
+		created = true
+	}
+
+	svc.Annotations[PREVIEW_EXPIRES_ANNOTATION_KEY] = time.Now().Add(PreviewTTL()).Format(time.RFC3339)
+	if _, err := this.UpdateService(nsName, svc); err != nil {
+		return nil, false, err
+	}
+	return meta, created, nil
+}
+
+// DeletePreviewServices deletes previews of the repo for the pull request,
+// and returns names of deleted services.
+func (this *Kubernetes) DeletePreviewServices(nsName, githubRepo string, number int) ([]string, error) {
+	svcs, err := this.GetPreviewServices(nsName, map[string]string{
+		"service":     this.util.NormalizeByHyphen("", githubRepo),
+		PREVIEW_LABEL: strconv.Itoa(number),
+	})
+	if err != nil {
+		return nil, err
+	}
+	deleted := []string{}
+	for _, svc := range svcs {
+		if err := this.DeleteService(nsName, svc.Name); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, svc.Name)
+	}
+	return deleted, nil
+}
+
+// CopySecretEnv copies secret env of a service to another.
+func (this *Kubernetes) CopySecretEnv(nsName, fromSvcName, toSvcName string) error {
+	si := this.client.Secrets(nsName)
+	secret, err := si.Get(SecretEnvName(fromSvcName))
+	if k8sErrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_, err = si.Create(&api.Secret{
+		ObjectMeta: api.ObjectMeta{
+			Name:   SecretEnvName(toSvcName),
+			Labels: secret.Labels,
+		},
+		Type: secret.Type,
+		Data: secret.Data,
+	})
+	if k8sErrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,227 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/kubernetes/pkg/api"
+	k8sErrors "k8s.io/kubernetes/pkg/api/errors"
+)
+
+// SecretEnvName returns the name of the secret holding secret env of the
+// service.
+func SecretEnvName(svcName string) string {
+	return fmt.Sprintf("%s-env", svcName)
+}
+
+// UpsertSecretEnv writes secret env of the metadata into the service secret
+// and records the keys in the metadata. keys posted without value keep the
+// stored value, and keys not posted are removed.
+func (this *Kubernetes) UpsertSecretEnv(nsName string, meta *Metadata) error {
+	secretName := SecretEnvName(meta.Service)
+	si := this.client.Secrets(nsName)
+
+	secret, err := si.Get(secretName)
+	exists := err == nil
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return err
+	}
+
+	data := make(map[string][]byte)
+	keys := []string{}
+	for _, env := range meta.SecretEnvironment {
+		if len(env.Value) > 0 {
+			data[env.Key] = []byte(env.Value)
+		} else if exists && secret.Data[env.Key] != nil {
+			data[env.Key] = secret.Data[env.Key]
+		} else {
+			return fmt.Errorf("value required for secret env %s", env.Key)
+		}
+		keys = append(keys, env.Key)
+	}
+	sort.Strings(keys)
+	meta.SecretEnvKeys = keys
+
+	if len(data) == 0 {
+		if exists {
+			return si.Delete(secretName)
+		}
+		return nil
+	}
+
+	if !exists {
+		_, err = si.Create(&api.Secret{
+			ObjectMeta: api.ObjectMeta{
+				Name:   secretName,
+				Labels: this.GetServiceLabels(meta),
+			},
+			Type: api.SecretTypeOpaque,
+			Data: data,
+		})
+		return err
+	}
+	secret.Data = data
+	_, err = si.Update(secret)
+	return err
+}
+
+func (this *Kubernetes) DeleteSecretEnv(nsName, svcName string) error {
+	err := this.client.Secrets(nsName).Delete(SecretEnvName(svcName))
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func getSecretEnvVars(secretName string, secretKeys []string) []api.EnvVar {
+	envVars := make([]api.EnvVar, len(secretKeys))
+	for i, key := range secretKeys {
+		envVars[i] = api.EnvVar{
+			Name: key,
+			ValueFrom: &api.EnvVarSource{
+				SecretKeyRef: &api.SecretKeySelector{
+					LocalObjectReference: api.LocalObjectReference{
+						Name: secretName,
+					},
+					Key: key,
+				},
+			},
+		}
+	}
+	return envVars
+}
+
+// NOTIFICATION_LEGACY_TOKEN_KEY refers to the token of a notification saved
+// before tokens moved to the secret.
+const NOTIFICATION_LEGACY_TOKEN_KEY = "legacy"
+
+// NotificationSecretName returns the name of the secret holding tokens of
+// notifications of the service.
+func NotificationSecretName(svcName string) string {
+	return fmt.Sprintf("%s-notify", svcName)
+}
+
+// HasToken reports whether the notification has a token, posted or stored.
+func (this *Notification) HasToken() bool {
+	return len(this.Token) > 0 || len(this.TokenKey) > 0 || len(this.LegacyToken) > 0
+}
+
+// StoredTokenKey returns the key of the stored token which the settings form
+// posts back.
+func (this Notification) StoredTokenKey() string {
+	if len(this.TokenKey) == 0 && len(this.LegacyToken) > 0 {
+		return NOTIFICATION_LEGACY_TOKEN_KEY
+	}
+	return this.TokenKey
+}
+
+// KeepLegacyTokens copies legacy tokens of the stored metadata into posted
+// notifications referring to them.
+func (this *Metadata) KeepLegacyTokens(stored *Metadata) {
+	for i := range this.Notification {
+		nm := &this.Notification[i]
+		if nm.TokenKey != NOTIFICATION_LEGACY_TOKEN_KEY {
+			continue
+		}
+		nm.TokenKey = ""
+		if i < len(stored.Notification) && stored.Notification[i].Driver == nm.Driver {
+			nm.LegacyToken = stored.Notification[i].LegacyToken
+		}
+	}
+}
+
+// UpsertNotificationTokens writes tokens of notifications of the metadata
+// into the notification secret and records their keys in the metadata.
+// notifications posted without token keep the token stored under their key.
+func (this *Kubernetes) UpsertNotificationTokens(nsName string, meta *Metadata) error {
+	secretName := NotificationSecretName(meta.Service)
+	si := this.client.Secrets(nsName)
+
+	secret, err := si.Get(secretName)
+	exists := err == nil
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return err
+	}
+
+	data := make(map[string][]byte)
+	for i := range meta.Notification {
+		nm := &meta.Notification[i]
+		token := nm.Token
+		if len(token) == 0 {
+			token = nm.LegacyToken
+		}
+		if len(token) == 0 && len(nm.TokenKey) > 0 {
+			if !exists || secret.Data[nm.TokenKey] == nil {
+				return fmt.Errorf("stored token of %s notification not found, post it again", nm.Driver)
+			}
+			token = string(secret.Data[nm.TokenKey])
+		}
+		nm.LegacyToken = ""
+		nm.TokenKey = ""
+		if len(token) == 0 {
+			continue
+		}
+		nm.Token = token
+		nm.TokenKey = fmt.Sprintf("%s-%d", nm.Driver, i)
+		data[nm.TokenKey] = []byte(token)
+	}
+
+	if len(data) == 0 {
+		if exists {
+			return si.Delete(secretName)
+		}
+		return nil
+	}
+
+	if !exists {
+		_, err = si.Create(&api.Secret{
+			ObjectMeta: api.ObjectMeta{
+				Name:   secretName,
+				Labels: this.GetServiceLabels(meta),
+			},
+			Type: api.SecretTypeOpaque,
+			Data: data,
+		})
+		return err
+	}
+	secret.Data = data
+	_, err = si.Update(secret)
+	return err
+}
+
+// LoadNotificationTokens fills tokens of notifications of the metadata from
+// the notification secret. services without stored tokens are not looked
+// up.
+func (this *Kubernetes) LoadNotificationTokens(nsName string, meta *Metadata) error {
+	stored := false
+	for i := range meta.Notification {
+		nm := &meta.Notification[i]
+		if len(nm.LegacyToken) > 0 {
+			nm.Token = nm.LegacyToken
+		}
+		stored = stored || len(nm.TokenKey) > 0
+	}
+	if !stored {
+		return nil
+	}
+
+	secret, err := this.client.Secrets(nsName).Get(NotificationSecretName(meta.Service))
+	if err != nil {
+		return err
+	}
+	for i := range meta.Notification {
+		nm := &meta.Notification[i]
+		if len(nm.TokenKey) > 0 {
+			nm.Token = string(secret.Data[nm.TokenKey])
+		}
+	}
+	return nil
+}
+
+func (this *Kubernetes) DeleteNotificationTokens(nsName, svcName string) error {
+	err := this.client.Secrets(nsName).Delete(NotificationSecretName(svcName))
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	STREAM_EVENT_DEPLOY = "deploy"
+	STREAM_EVENT_BUILD  = "build"
+	STREAM_BUFFER_SIZE  = 100
+)
+
+// StreamEvent is a line of deploy progress or build log of a service.
+type StreamEvent struct {
+	Type      string    `json:"type"`
+	Namespace string    `json:"namespace"`
+	Service   string    `json:"service"`
+	SHA       string    `json:"sha,omitempty"`
+	DeployID  int       `json:"deploy_id,omitempty"`
+	Level     string    `json:"level,omitempty"`
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+}
+
+// LogStream fans out stream events of services to subscribers, e.g. service
+// pages following deploys and builds.
+type LogStream struct {
+	mutex       sync.RWMutex
+	subscribers map[string]map[chan StreamEvent]bool
+}
+
+var (
+	logStreamOnce sync.Once
+	logStreamInst *LogStream
+)
+
+func NewLogStream() *LogStream {
+	logStreamOnce.Do(func() {
+		logStreamInst = &LogStream{
+			subscribers: make(map[string]map[chan StreamEvent]bool),
+		}
+	})
+	return logStreamInst
+}
+
+// Subscribe returns events of the service and a function to unsubscribe.
+func (this *LogStream) Subscribe(nsName, svcName string) (<-chan StreamEvent, func()) {
+	key := nsName + "/" + svcName
+	ch := make(chan StreamEvent, STREAM_BUFFER_SIZE)
+
+	this.mutex.Lock()
+	if _, ok := this.subscribers[key]; !ok {
+		this.subscribers[key] = make(map[chan StreamEvent]bool)
+	}
+	this.subscribers[key][ch] = true
+	this.mutex.Unlock()
+
+	return ch, func() {
+		this.mutex.Lock()
+		defer this.mutex.Unlock()
+		delete(this.subscribers[key], ch)
+		if len(this.subscribers[key]) == 0 {
+			delete(this.subscribers, key)
+		}
+	}
+}
+
+// Publish sends the event to subscribers of its service. events are dropped
+// for subscribers which fall behind, so that deploys and builds never block
+// on slow clients.
+func (this *LogStream) Publish(event StreamEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	for ch := range this.subscribers[event.Namespace+"/"+event.Service] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
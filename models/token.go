@@ -0,0 +1,181 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	k8sErrors "k8s.io/kubernetes/pkg/api/errors"
+)
+
+const (
+	API_TOKEN_SECRET  = "cite-api-tokens"
+	API_TOKEN_PREFIX  = "cite_"
+	API_TOKEN_BYTES   = 20
+	API_TOKEN_RETRIES = 3
+)
+
+// APIToken is a personal token authenticating the json api as its user.
+// calls are authorized with the github token of the user, so tokens have the
+// same permissions as the user on the web. tokens are kept in a secret by
+// their hash, and the plain token is shown only once when created.
+type APIToken struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	UserLogin   string    `json:"user_login"`
+	GitHubToken string    `json:"github_token"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type ByCreatedAt []APIToken
+
+func (s ByCreatedAt) Len() int           { return len(s) }
+func (s ByCreatedAt) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s ByCreatedAt) Less(i, j int) bool { return s[i].CreatedAt.Before(s[j].CreatedAt) }
+
+var apiTokenMutex sync.Mutex
+
+// CiteNamespace returns the namespace keeping objects of cite itself.
+func CiteNamespace() string {
+	if len(Conf.Cite.Namespace) > 0 {
+		return Conf.Cite.Namespace
+	}
+	return api.NamespaceDefault
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken mints a token of the user and returns the plain token.
+func (this *Kubernetes) CreateAPIToken(userLogin, githubToken, name string) (string, *APIToken, error) {
+	buf := make([]byte, API_TOKEN_BYTES)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	token := API_TOKEN_PREFIX + hex.EncodeToString(buf)
+	hash := hashAPIToken(token)
+	apiToken := &APIToken{
+		ID:          hash[:12],
+		Name:        name,
+		UserLogin:   userLogin,
+		GitHubToken: githubToken,
+		CreatedAt:   time.Now(),
+	}
+	tokenJSON, err := json.Marshal(apiToken)
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = this.updateAPITokens(func(data map[string][]byte) error {
+		data[hash] = tokenJSON
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return token, apiToken, nil
+}
+
+// GetAPIToken returns the token of the plain token.
+func (this *Kubernetes) GetAPIToken(token string) (*APIToken, error) {
+	secret, err := this.client.Secrets(CiteNamespace()).Get(API_TOKEN_SECRET)
+	if err != nil {
+		return nil, err
+	}
+	tokenJSON, ok := secret.Data[hashAPIToken(token)]
+	if !ok {
+		return nil, fmt.Errorf("api token not found")
+	}
+	apiToken := new(APIToken)
+	if err := json.Unmarshal(tokenJSON, apiToken); err != nil {
+		return nil, err
+	}
+	return apiToken, nil
+}
+
+// ListAPITokens returns tokens of the user, oldest first.
+func (this *Kubernetes) ListAPITokens(userLogin string) ([]APIToken, error) {
+	tokens := []APIToken{}
+	secret, err := this.client.Secrets(CiteNamespace()).Get(API_TOKEN_SECRET)
+	if k8sErrors.IsNotFound(err) {
+		return tokens, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, tokenJSON := range secret.Data {
+		var apiToken APIToken
+		if err := json.Unmarshal(tokenJSON, &apiToken); err != nil {
+			return nil, err
+		}
+		if apiToken.UserLogin == userLogin {
+			tokens = append(tokens, apiToken)
+		}
+	}
+	sort.Sort(ByCreatedAt(tokens))
+	return tokens, nil
+}
+
+// DeleteAPIToken revokes the token of the user.
+func (this *Kubernetes) DeleteAPIToken(userLogin, id string) error {
+	return this.updateAPITokens(func(data map[string][]byte) error {
+		for hash, tokenJSON := range data {
+			var apiToken APIToken
+			if err := json.Unmarshal(tokenJSON, &apiToken); err != nil {
+				return err
+			}
+			if apiToken.ID == id && apiToken.UserLogin == userLogin {
+				delete(data, hash)
+				return nil
+			}
+		}
+		return fmt.Errorf("api token %s not found", id)
+	})
+}
+
+func (this *Kubernetes) updateAPITokens(update func(data map[string][]byte) error) error {
+	apiTokenMutex.Lock()
+	defer apiTokenMutex.Unlock()
+
+	si := this.client.Secrets(CiteNamespace())
+	for i := 0; i < API_TOKEN_RETRIES; i++ {
+		secret, getErr := si.Get(API_TOKEN_SECRET)
+		exists := getErr == nil
+		if !exists {
+			if !k8sErrors.IsNotFound(getErr) {
+				return getErr
+			}
+			secret = &api.Secret{
+				ObjectMeta: api.ObjectMeta{
+					Name: API_TOKEN_SECRET,
+				},
+				Type: api.SecretTypeOpaque,
+			}
+		}
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		if err := update(secret.Data); err != nil {
+			return err
+		}
+
+		var err error
+		if exists {
+			_, err = si.Update(secret)
+		} else {
+			_, err = si.Create(secret)
+		}
+		// retry when someone else wrote the secret in the meantime
+		if err == nil || !(k8sErrors.IsConflict(err) || k8sErrors.IsAlreadyExists(err)) {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to update api tokens due to conflicts")
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"fmt"
+	"path"
+)
+
+const (
+	TRIGGER_BRANCH  = "branch"
+	TRIGGER_TAG     = "tag"
+	TRIGGER_RELEASE = "release"
+)
+
+// Trigger deploys the service on pushes to branches, created tags or
+// published releases whose name matches Pattern, a glob of path.Match, e.g.
+// "v[0-9]*". empty pattern matches every release, and is not allowed for
+// branches and tags. the bound GitBranch of auto deploy services needs no
+// trigger.
+type Trigger struct {
+	Type    string `json:"type" schema:"type"`
+	Pattern string `json:"pattern" schema:"pattern"`
+}
+
+func (this *Trigger) Validate() error {
+	switch this.Type {
+	case TRIGGER_BRANCH, TRIGGER_TAG:
+		if len(this.Pattern) == 0 {
+			return fmt.Errorf("%s trigger: pattern required", this.Type)
+		}
+	case TRIGGER_RELEASE:
+	default:
+		return fmt.Errorf("unknown trigger type %s", this.Type)
+	}
+	if _, err := path.Match(this.Pattern, ""); err != nil {
+		return fmt.Errorf("%s trigger: invalid pattern %s: %v", this.Type, this.Pattern, err)
+	}
+	return nil
+}
+
+// Match reports whether the ref of triggerType fires the trigger. refs are
+// branch names or tag names.
+func (this *Trigger) Match(triggerType, ref string) bool {
+	if this.Type != triggerType {
+		return false
+	}
+	if this.Type == TRIGGER_RELEASE && len(this.Pattern) == 0 {
+		return true
+	}
+	matched, _ := path.Match(this.Pattern, ref)
+	return matched
+}
+
+func (this *Metadata) ValidateTriggers() error {
+	for _, trigger := range this.Triggers {
+		if err := trigger.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Triggered reports whether the ref of triggerType deploys the service.
+func (this *Metadata) Triggered(triggerType, ref string) bool {
+	if triggerType == TRIGGER_BRANCH && this.AutoDeploy && ref == this.GitBranch {
+		return true
+	}
+	for _, trigger := range this.Triggers {
+		if trigger.Match(triggerType, ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// Builds reports whether pushes to the branch are built for the service.
+// services build their bound branch even without auto deploy.
+func (this *Metadata) Builds(branch string) bool {
+	return branch == this.GitBranch || this.Triggered(TRIGGER_BRANCH, branch)
+}
@@ -103,9 +103,13 @@ func (this *WatchCenter) sendTalk(api, to, msg string) {
 		"msg": []string{
 			fmt.Sprintf("[CITE-%s] %s", Conf.Cite.Version, msg)},
 	}
-	resp, _ := http.PostForm(wcURL, values)
-	respBody, _ := ioutil.ReadAll(resp.Body)
+	resp, err := http.PostForm(wcURL, values)
+	if err != nil {
+		logger.Warning("error while send message to watchcenter:", err)
+		return
+	}
 	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
 
 	var wcResp WatchCenterResponse
 	json.Unmarshal(respBody, &wcResp)
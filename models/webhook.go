@@ -0,0 +1,176 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	k8sErrors "k8s.io/kubernetes/pkg/api/errors"
+)
+
+const (
+	WEBHOOK_SECRET       = "cite-webhook"
+	WEBHOOK_SECRET_KEY   = "secret"
+	WEBHOOK_SECRET_BYTES = 20
+
+	GITHUB_DELIVERY_TTL   = 72 * time.Hour
+	GITHUB_DELIVERY_LIMIT = 10000
+)
+
+var (
+	webhookSecretMutex sync.Mutex
+	webhookSecret      string
+)
+
+// WebhookSecret returns the secret signing github webhooks of this
+// installation. GitHub.WebhookSecret of the config is used if it is set,
+// otherwise a secret is generated once and kept in kubernetes.
+func WebhookSecret() (string, error) {
+	if len(Conf.GitHub.WebhookSecret) > 0 {
+		return Conf.GitHub.WebhookSecret, nil
+	}
+
+	webhookSecretMutex.Lock()
+	defer webhookSecretMutex.Unlock()
+	if len(webhookSecret) > 0 {
+		return webhookSecret, nil
+	}
+
+	si := NewKubernetes().client.Secrets(CiteNamespace())
+	secret, err := si.Get(WEBHOOK_SECRET)
+	if k8sErrors.IsNotFound(err) {
+		buf := make([]byte, WEBHOOK_SECRET_BYTES)
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		secret, err = si.Create(&api.Secret{
+			ObjectMeta: api.ObjectMeta{
+				Name: WEBHOOK_SECRET,
+			},
+			Type: api.SecretTypeOpaque,
+			Data: map[string][]byte{
+				WEBHOOK_SECRET_KEY: []byte(hex.EncodeToString(buf)),
+			},
+		})
+		// another replica may have created it in the meantime
+		if k8sErrors.IsAlreadyExists(err) {
+			secret, err = si.Get(WEBHOOK_SECRET)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	value, ok := secret.Data[WEBHOOK_SECRET_KEY]
+	if !ok || len(value) == 0 {
+		return "", fmt.Errorf("%s not found in secret %s/%s", WEBHOOK_SECRET_KEY, CiteNamespace(), WEBHOOK_SECRET)
+	}
+	webhookSecret = string(value)
+	return webhookSecret, nil
+}
+
+// VerifyWebhookSignature checks the body against the signature of github,
+// which is the X-Hub-Signature-256 header ("sha256=<hex>") or the
+// X-Hub-Signature header ("sha1=<hex>").
+func VerifyWebhookSignature(signature string, body []byte) error {
+	if len(signature) == 0 {
+		return fmt.Errorf("signature missing")
+	}
+	secret, err := WebhookSecret()
+	if err != nil {
+		return fmt.Errorf("failed to get webhook secret: %v", err)
+	}
+
+	entries := strings.SplitN(signature, "=", 2)
+	if len(entries) != 2 {
+		return fmt.Errorf("malformed signature %s", signature)
+	}
+	var newHash func() hash.Hash
+	switch entries[0] {
+	case "sha256":
+		newHash = sha256.New
+	case "sha1":
+		newHash = sha1.New
+	default:
+		return fmt.Errorf("unsupported signature algorithm %s", entries[0])
+	}
+	actual, err := hex.DecodeString(entries[1])
+	if err != nil {
+		return fmt.Errorf("malformed signature %s: %v", signature, err)
+	}
+
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), actual) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// GitHubDeliveries remembers X-GitHub-Delivery ids of received webhooks, so
+// that replayed events are dropped. ids are kept for GITHUB_DELIVERY_TTL, up
+// to GITHUB_DELIVERY_LIMIT ids.
+type GitHubDeliveries struct {
+	mutex    sync.Mutex
+	received map[string]time.Time
+	order    []string
+}
+
+var (
+	githubDeliveriesOnce sync.Once
+	githubDeliveriesInst *GitHubDeliveries
+)
+
+func NewGitHubDeliveries() *GitHubDeliveries {
+	githubDeliveriesOnce.Do(func() {
+		githubDeliveriesInst = &GitHubDeliveries{
+			received: make(map[string]time.Time),
+		}
+	})
+	return githubDeliveriesInst
+}
+
+// Receive records the delivery id, and returns false if it was already
+// received.
+func (this *GitHubDeliveries) Receive(id string) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	now := time.Now()
+	for len(this.order) > 0 {
+		oldest := this.order[0]
+		if len(this.order) < GITHUB_DELIVERY_LIMIT && now.Sub(this.received[oldest]) < GITHUB_DELIVERY_TTL {
+			break
+		}
+		delete(this.received, oldest)
+		this.order = this.order[1:]
+	}
+
+	if _, ok := this.received[id]; ok {
+		return false
+	}
+	this.received[id] = now
+	this.order = append(this.order, id)
+	return true
+}
+
+// Forget drops the delivery id, so that the event can be redelivered. it is
+// used when the event failed to be handled.
+func (this *GitHubDeliveries) Forget(id string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	delete(this.received, id)
+	for i, received := range this.order {
+		if received == id {
+			this.order = append(this.order[:i], this.order[i+1:]...)
+			break
+		}
+	}
+}